@@ -0,0 +1,66 @@
+package checkconfig
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/google/subcommands"
+	"github.com/hansmi/baamhackl/internal/cmdutil"
+	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/credential"
+)
+
+// Command implements the "check-config" subcommand.
+type Command struct {
+	output     io.Writer
+	configFlag config.Flag
+}
+
+func (*Command) Name() string {
+	return "check-config"
+}
+
+func (*Command) Synopsis() string {
+	return "Validate a configuration file."
+}
+
+func (c *Command) Usage() string {
+	return cmdutil.Usage(c, "", `Beyond parsing the configuration the users and groups configured for handler commands must exist.`)
+}
+
+func (c *Command) SetFlags(fs *flag.FlagSet) {
+	c.configFlag.SetFlags(fs)
+}
+
+func (c *Command) execute() error {
+	cfg, err := c.configFlag.Load()
+	if err != nil {
+		return err
+	}
+
+	if err := credential.Check(cfg.Handlers); err != nil {
+		return err
+	}
+
+	output := c.output
+
+	if output == nil {
+		output = os.Stdout
+	}
+
+	fmt.Fprintf(output, "Configuration is valid (%d handlers).\n", len(cfg.Handlers))
+
+	return nil
+}
+
+func (c *Command) Execute(ctx context.Context, fs *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return subcommands.ExitUsageError
+	}
+
+	return cmdutil.ExecuteStatus(c.execute())
+}
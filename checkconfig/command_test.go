@@ -0,0 +1,83 @@
+package checkconfig
+
+import (
+	"flag"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hansmi/baamhackl/internal/testutil"
+)
+
+func TestExecute(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		content    string
+		wantErr    string
+		wantOutput string
+	}{
+		{
+			name: "valid",
+			content: `
+handlers:
+  - name: first
+    path: /tmp
+    command: ["/bin/true"]
+  - name: second
+    path: /tmp
+    command: ["/bin/true"]
+    group: "12345"
+`,
+			wantOutput: "Configuration is valid (2 handlers).\n",
+		},
+		{
+			name: "unknown user",
+			content: `
+handlers:
+  - name: test
+    path: /tmp
+    command: ["/bin/true"]
+    user: baamhackl-unknown-user
+`,
+			wantErr: `handler "test": user "baamhackl-unknown-user"`,
+		},
+		{
+			name: "syntax error",
+			content: `
+handlers:
+  - name: test
+`,
+			wantErr: "loading configuration",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "config.yaml"), tc.content)
+
+			var buf strings.Builder
+
+			cmd := &Command{output: &buf}
+
+			fs := flag.NewFlagSet("", flag.PanicOnError)
+			cmd.SetFlags(fs)
+
+			if err := fs.Parse([]string{"-config", path}); err != nil {
+				t.Fatal(err)
+			}
+
+			err := cmd.execute()
+
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("execute() returned %v, want error containing %q", err, tc.wantErr)
+				}
+			} else if err != nil {
+				t.Errorf("execute() failed: %v", err)
+			}
+
+			if diff := cmp.Diff(tc.wantOutput, buf.String()); diff != "" {
+				t.Errorf("Output diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"io"
+	"net/rpc"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/google/subcommands"
+	"github.com/hansmi/baamhackl/internal/cmdutil"
+	"github.com/hansmi/baamhackl/internal/service"
+	"go.uber.org/multierr"
+)
+
+// Amount of time the server waits for events before returning an empty
+// response.
+const pollTimeout = 30 * time.Second
+
+type serviceCaller interface {
+	Call(string, any, any) error
+}
+
+// Command implements the "events" subcommand.
+type Command struct {
+	output   io.Writer
+	service  serviceCaller
+	handlers string
+	history  bool
+	count    int
+}
+
+func (*Command) Name() string {
+	return "events"
+}
+
+func (*Command) Synopsis() string {
+	return "Print task lifecycle events from a running server."
+}
+
+func (c *Command) Usage() string {
+	return cmdutil.Usage(c, "<socket>", `Events are received via the given Unix socket and written to standard output as newline-delimited JSON until interrupted.`)
+}
+
+func (c *Command) SetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.handlers, "handler", "",
+		"Comma-separated list of handler names. Events from all handlers are printed if empty.")
+	fs.BoolVar(&c.history, "history", false,
+		"Include events retained by the server from before the command started.")
+	fs.IntVar(&c.count, "count", 0,
+		"Exit after printing the given number of events. Zero for no limit.")
+}
+
+func (c *Command) execute(ctx context.Context, socketPath string) (err error) {
+	req := service.EventsRequest{
+		Tail:    !c.history,
+		Timeout: pollTimeout,
+	}
+
+	for _, i := range strings.Split(c.handlers, ",") {
+		if i = strings.TrimSpace(i); i != "" {
+			req.Handlers = append(req.Handlers, i)
+		}
+	}
+
+	output := c.output
+
+	if output == nil {
+		output = os.Stdout
+	}
+
+	svc := c.service
+
+	if svc == nil {
+		client, err := rpc.Dial("unix", socketPath)
+		if err != nil {
+			return err
+		}
+
+		defer multierr.AppendInvoke(&err, multierr.Close(client))
+
+		svc = client
+	}
+
+	enc := json.NewEncoder(output)
+	printed := 0
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		var resp service.EventsResponse
+
+		if err := svc.Call("Service.Events", req, &resp); err != nil {
+			return err
+		}
+
+		for _, e := range resp.Events {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+
+			if printed++; c.count > 0 && printed >= c.count {
+				return nil
+			}
+		}
+
+		req.After = resp.Next
+		req.Tail = false
+	}
+}
+
+func (c *Command) Execute(ctx context.Context, fs *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return subcommands.ExitUsageError
+	}
+
+	return cmdutil.ExecuteStatus(c.execute(ctx, fs.Arg(0)))
+}
@@ -0,0 +1,91 @@
+package events
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hansmi/baamhackl/internal/eventbus"
+	"github.com/hansmi/baamhackl/internal/service"
+)
+
+type fakeCaller struct {
+	calls     []service.EventsRequest
+	responses []service.EventsResponse
+}
+
+func (c *fakeCaller) Call(method string, args any, reply any) error {
+	c.calls = append(c.calls, args.(service.EventsRequest))
+
+	*reply.(*service.EventsResponse) = c.responses[0]
+	c.responses = c.responses[1:]
+
+	return nil
+}
+
+func TestExecute(t *testing.T) {
+	svc := &fakeCaller{
+		responses: []service.EventsResponse{
+			{
+				Events: []eventbus.Event{
+					{Seq: 3, Type: eventbus.FileReported, Handler: "first", Name: "a.txt"},
+				},
+				Next: 3,
+			},
+			{Next: 5},
+			{
+				Events: []eventbus.Event{
+					{Seq: 6, Type: eventbus.TaskQueued, Handler: "second", Name: "b.txt"},
+					{Seq: 7, Type: eventbus.Pruned, Handler: "second"},
+				},
+				Next: 7,
+			},
+		},
+	}
+
+	var buf strings.Builder
+
+	cmd := &Command{
+		output:   &buf,
+		service:  svc,
+		handlers: "first, second,",
+		count:    2,
+	}
+
+	if err := cmd.execute(context.Background(), ""); err != nil {
+		t.Errorf("execute() failed: %v", err)
+	}
+
+	wantRequests := []service.EventsRequest{
+		{Tail: true, Handlers: []string{"first", "second"}, Timeout: pollTimeout},
+		{After: 3, Handlers: []string{"first", "second"}, Timeout: pollTimeout},
+		{After: 5, Handlers: []string{"first", "second"}, Timeout: pollTimeout},
+	}
+
+	if diff := cmp.Diff(wantRequests, svc.calls); diff != "" {
+		t.Errorf("Request diff (-want +got):\n%s", diff)
+	}
+
+	want := `{"seq":3,"time":"0001-01-01T00:00:00Z","type":"file_reported","handler":"first","name":"a.txt"}
+{"seq":6,"time":"0001-01-01T00:00:00Z","type":"task_queued","handler":"second","name":"b.txt"}
+`
+
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("Output diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestExecuteCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cmd := &Command{
+		service: &fakeCaller{},
+		history: true,
+	}
+
+	if err := cmd.execute(ctx, ""); err != context.Canceled {
+		t.Errorf("execute() returned %v, want cancellation", err)
+	}
+}
@@ -0,0 +1,237 @@
+// Package cgroup manages cgroup v2 groups for handler commands within
+// a delegated hierarchy.
+package cgroup
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hansmi/baamhackl/internal/config"
+	"go.uber.org/multierr"
+	"golang.org/x/sys/unix"
+)
+
+const mountPoint = "/sys/fs/cgroup"
+
+// Period for CPU bandwidth limits in microseconds.
+const cpuMaxPeriod = 100000
+
+// How long to wait for remaining processes to disappear after killing them.
+const removeTimeout = time.Second
+
+// ErrNotDelegated is returned if the process doesn't have a writable cgroup v2
+// hierarchy.
+var ErrNotDelegated = errors.New("cgroup v2 hierarchy is not delegated")
+
+// Controllers enabled for child groups if available.
+var wantControllers = []string{"cpu", "memory"}
+
+func writeFile(path, content string) error {
+	return os.WriteFile(path, []byte(content), 0o644)
+}
+
+// ownCgroup extracts the path of the unified hierarchy from the content of
+// /proc/self/cgroup.
+func ownCgroup(content []byte) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+
+	for scanner.Scan() {
+		if path, ok := strings.CutPrefix(scanner.Text(), "0::"); ok {
+			return path, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", fmt.Errorf("%w: no unified hierarchy", ErrNotDelegated)
+}
+
+// Manager creates per-command groups below the cgroup of the process.
+type Manager struct {
+	dir string
+}
+
+// Setup prepares the cgroup of the current process for per-command groups.
+// The process itself is moved into a leaf group named "supervisor" as
+// processes may only reside in leaf groups when controllers are enabled.
+func Setup() (*Manager, error) {
+	var st unix.Statfs_t
+
+	if err := unix.Statfs(mountPoint, &st); err != nil {
+		return nil, err
+	} else if st.Type != unix.CGROUP2_SUPER_MAGIC {
+		return nil, fmt.Errorf("%w: %s is not a cgroup v2 filesystem", ErrNotDelegated, mountPoint)
+	}
+
+	content, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := ownCgroup(content)
+	if err != nil {
+		return nil, err
+	}
+
+	return setup(filepath.Join(mountPoint, path), os.Getpid())
+}
+
+func setup(dir string, pid int) (*Manager, error) {
+	for _, name := range []string{"cgroup.procs", "cgroup.subtree_control"} {
+		if err := unix.Access(filepath.Join(dir, name), unix.W_OK); err != nil {
+			return nil, fmt.Errorf("%w: %s: %v", ErrNotDelegated, dir, err)
+		}
+	}
+
+	supervisor := filepath.Join(dir, "supervisor")
+
+	if err := os.Mkdir(supervisor, 0o755); !(err == nil || os.IsExist(err)) {
+		return nil, err
+	}
+
+	if err := writeFile(filepath.Join(supervisor, "cgroup.procs"), strconv.Itoa(pid)); err != nil {
+		return nil, fmt.Errorf("moving process into %s failed: %w", supervisor, err)
+	}
+
+	available, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range strings.Fields(string(available)) {
+		if !slices.Contains(wantControllers, name) {
+			continue
+		}
+
+		if err := writeFile(filepath.Join(dir, "cgroup.subtree_control"), "+"+name); err != nil {
+			return nil, fmt.Errorf("enabling %s controller failed: %w", name, err)
+		}
+	}
+
+	return &Manager{dir: dir}, nil
+}
+
+// Create makes a new group with the given limits.
+func (m *Manager) Create(limits config.CgroupLimits) (_ *Group, err error) {
+	path, err := os.MkdirTemp(m.dir, "command-")
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			os.Remove(path)
+		}
+	}()
+
+	if limits.MemoryMaxBytes > 0 {
+		if err := writeFile(filepath.Join(path, "memory.max"), strconv.FormatUint(limits.MemoryMaxBytes, 10)); err != nil {
+			return nil, fmt.Errorf("setting memory limit failed: %w", err)
+		}
+	}
+
+	if limits.CPUMax > 0 {
+		quota := max(1000, int64(limits.CPUMax*cpuMaxPeriod))
+
+		if err := writeFile(filepath.Join(path, "cpu.max"), fmt.Sprintf("%d %d", quota, cpuMaxPeriod)); err != nil {
+			return nil, fmt.Errorf("setting CPU limit failed: %w", err)
+		}
+	}
+
+	fd, err := unix.Open(path, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Group{path: path, fd: fd}, nil
+}
+
+// Stats contains the resource usage of a group.
+type Stats struct {
+	// Maximum memory usage in bytes. Zero if not supported by the kernel.
+	MemoryPeak uint64
+
+	// Number of processes killed due to exceeding the memory limit.
+	OOMKills uint64
+}
+
+// Group is a cgroup for a single command.
+type Group struct {
+	path string
+	fd   int
+}
+
+// Path returns the path of the group in the cgroup filesystem.
+func (g *Group) Path() string {
+	return g.path
+}
+
+// FD returns a file descriptor referring to the group, e.g. for starting
+// a process directly within the group.
+func (g *Group) FD() int {
+	return g.fd
+}
+
+// Stats reads the resource usage of the group.
+func (g *Group) Stats() (Stats, error) {
+	var s Stats
+
+	if content, err := os.ReadFile(filepath.Join(g.path, "memory.peak")); err == nil {
+		if s.MemoryPeak, err = strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64); err != nil {
+			return s, err
+		}
+	} else if !os.IsNotExist(err) {
+		return s, err
+	}
+
+	content, err := os.ReadFile(filepath.Join(g.path, "memory.events"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = nil
+		}
+
+		return s, err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if value, ok := strings.CutPrefix(line, "oom_kill "); ok {
+			if s.OOMKills, err = strconv.ParseUint(value, 10, 64); err != nil {
+				return s, err
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// Close removes the group. Processes remaining in the group are killed.
+func (g *Group) Close() error {
+	err := unix.Close(g.fd)
+
+	removeErr := os.Remove(g.path)
+
+	if errors.Is(removeErr, unix.EBUSY) {
+		if killErr := writeFile(filepath.Join(g.path, "cgroup.kill"), "1"); killErr != nil {
+			multierr.AppendInto(&err, killErr)
+		}
+
+		deadline := time.Now().Add(removeTimeout)
+
+		for errors.Is(removeErr, unix.EBUSY) && time.Now().Before(deadline) {
+			time.Sleep(10 * time.Millisecond)
+			removeErr = os.Remove(g.path)
+		}
+	}
+
+	return multierr.Append(err, removeErr)
+}
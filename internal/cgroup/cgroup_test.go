@@ -0,0 +1,137 @@
+package cgroup
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/testutil"
+)
+
+func readFile(t *testing.T, path string) string {
+	t.Helper()
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(content)
+}
+
+func TestOwnCgroup(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		want    string
+		wantErr error
+	}{
+		{
+			name:    "unified",
+			content: "0::/system.slice/baamhackl.service\n",
+			want:    "/system.slice/baamhackl.service",
+		},
+		{
+			name:    "hybrid",
+			content: "1:name=systemd:/\n0::/user.slice\n",
+			want:    "/user.slice",
+		},
+		{
+			name:    "legacy",
+			content: "4:memory:/\n1:cpu:/\n",
+			wantErr: ErrNotDelegated,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ownCgroup([]byte(tc.content))
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("Error diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("ownCgroup() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSetupNotDelegated(t *testing.T) {
+	if _, err := setup(t.TempDir(), 1); !errors.Is(err, ErrNotDelegated) {
+		t.Errorf("setup() returned %v, want %v", err, ErrNotDelegated)
+	}
+}
+
+func TestManager(t *testing.T) {
+	dir := t.TempDir()
+
+	testutil.MustWriteFile(t, filepath.Join(dir, "cgroup.procs"), "")
+	testutil.MustWriteFile(t, filepath.Join(dir, "cgroup.subtree_control"), "")
+	testutil.MustWriteFile(t, filepath.Join(dir, "cgroup.controllers"), "cpuset io memory pids\n")
+
+	m, err := setup(dir, 1234)
+	if err != nil {
+		t.Fatalf("setup() failed: %v", err)
+	}
+
+	if diff := cmp.Diff("1234", readFile(t, filepath.Join(dir, "supervisor", "cgroup.procs"))); diff != "" {
+		t.Errorf("Supervisor processes diff (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff("+memory", readFile(t, filepath.Join(dir, "cgroup.subtree_control"))); diff != "" {
+		t.Errorf("Subtree controllers diff (-want +got):\n%s", diff)
+	}
+
+	g, err := m.Create(config.CgroupLimits{
+		MemoryMaxBytes: 64 << 20,
+		CPUMax:         1.5,
+	})
+	if err != nil {
+		t.Fatalf("Create() failed: %v", err)
+	}
+
+	if got := filepath.Dir(g.Path()); got != dir {
+		t.Errorf("Group %q not within %q", g.Path(), dir)
+	}
+
+	if diff := cmp.Diff(strconv.Itoa(64<<20), readFile(t, filepath.Join(g.Path(), "memory.max"))); diff != "" {
+		t.Errorf("Memory limit diff (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff("150000 100000", readFile(t, filepath.Join(g.Path(), "cpu.max"))); diff != "" {
+		t.Errorf("CPU limit diff (-want +got):\n%s", diff)
+	}
+
+	testutil.MustWriteFile(t, filepath.Join(g.Path(), "memory.peak"), "1048576\n")
+	testutil.MustWriteFile(t, filepath.Join(g.Path(), "memory.events"), "low 0\nhigh 0\nmax 3\noom 1\noom_kill 2\n")
+
+	if stats, err := g.Stats(); err != nil {
+		t.Errorf("Stats() failed: %v", err)
+	} else if diff := cmp.Diff(Stats{MemoryPeak: 1 << 20, OOMKills: 2}, stats); diff != "" {
+		t.Errorf("Stats() diff (-want +got):\n%s", diff)
+	}
+
+	// Files in a cgroup can't be removed. Emulate an empty group.
+	for _, name := range []string{"memory.max", "cpu.max", "memory.peak", "memory.events"} {
+		if err := os.Remove(filepath.Join(g.Path(), name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if stats, err := g.Stats(); err != nil {
+		t.Errorf("Stats() failed: %v", err)
+	} else if diff := cmp.Diff(Stats{}, stats); diff != "" {
+		t.Errorf("Stats() diff (-want +got):\n%s", diff)
+	}
+
+	if err := g.Close(); err != nil {
+		t.Errorf("Close() failed: %v", err)
+	}
+
+	testutil.MustNotExist(t, g.Path())
+}
@@ -1,14 +1,18 @@
 package config
 
 import (
+	"fmt"
+	"os"
 	"time"
 
 	"github.com/goccy/go-yaml"
+	"github.com/hansmi/baamhackl/internal/relpath"
 )
 
 // Default configuration for a handler.
 var HandlerDefaults = Handler{
 	Timeout:           time.Hour,
+	KillGrace:         10 * time.Second,
 	SettleDuration:    time.Second,
 	RetryCount:        2,
 	RetryDelayInitial: 15 * time.Minute,
@@ -18,6 +22,38 @@ var HandlerDefaults = Handler{
 	JournalRetention:  24 * 7 * time.Hour,
 	SuccessDir:        "_/success",
 	FailureDir:        "_/failure",
+	DuplicatesDir:     "_/duplicates",
+	Output: CommandOutput{
+		MaxBytes: 16 * 1024 * 1024,
+	},
+}
+
+// Actions for changed files whose content was processed successfully before.
+const (
+	// Archive duplicates as successful without running the command.
+	DuplicateActionSkip = "skip"
+
+	// Move duplicates into a separate directory without running the command.
+	DuplicateActionMove = "move"
+
+	// Run the command for duplicates anyway.
+	DuplicateActionRun = "run"
+)
+
+// Stdin configures filter commands reading the changed file from standard
+// input and writing the result to standard output.
+type Stdin struct {
+	// Whether to connect the copy of the changed file to standard input and
+	// capture standard output separately from standard error.
+	Enabled bool `yaml:"enabled"`
+
+	// Directory into which the standard output of successful commands is
+	// moved. Relative paths are resolved against the handler path.
+	OutputDir string `yaml:"output_dir" validate:"required_if=Enabled true"`
+
+	// Suffix appended to the name of the changed file to name the output,
+	// e.g. ".gz".
+	OutputSuffix string `yaml:"output_suffix" validate:"excludes=/"`
 }
 
 type Handler struct {
@@ -29,12 +65,75 @@ type Handler struct {
 
 	// Command executed when file changes are detected. Arguments are visible
 	// in log files and shouldn't contain confidential information such as
-	// passwords or access tokens.
+	// passwords or access tokens. Use EnvFiles instead.
 	Command []string `yaml:"command" validate:"required,gte=1"`
 
+	// Additional environment variables for the command. Values are visible
+	// in log files.
+	Env map[string]string `yaml:"env" validate:"dive,keys,required,excludes==,endkeys"`
+
+	// Environment variables whose values are read from files whenever the
+	// command is started, e.g. for passwords or access tokens. A single
+	// trailing newline is removed. Values are redacted in log files.
+	EnvFiles map[string]string `yaml:"env_files" validate:"dive,keys,required,excludes==,endkeys,required"`
+
+	// Don't pass the environment of Baamhackl to the command. Variables
+	// named in EnvAllowlist are passed nonetheless.
+	ClearEnv bool `yaml:"clear_env"`
+
+	// Names of inherited environment variables to pass to the command when
+	// ClearEnv is set, e.g. "PATH".
+	EnvAllowlist []string `yaml:"env_allowlist" validate:"dive,required"`
+
+	// User as which to run the command, either a name or a numeric ID.
+	// Defaults to the user running Baamhackl.
+	User string `yaml:"user"`
+
+	// Primary group for the command, either a name or a numeric ID. Defaults
+	// to the primary group of the user.
+	Group string `yaml:"group"`
+
+	// Supplementary groups for the command. Defaults to the groups of the
+	// user if one is configured. Set to an empty list to drop all
+	// supplementary groups.
+	SupplementaryGroups []string `yaml:"supplementary_groups"`
+
+	// Compute a SHA-256 checksum of changed files while copying them for the
+	// command. The original file must still have the same checksum after the
+	// command finished.
+	InputChecksum bool `yaml:"input_checksum"`
+
+	// How to handle changed files whose content was processed successfully
+	// before within the journal retention period. Detecting duplicates
+	// requires computing checksums. Leave empty to disable.
+	DuplicateAction string `yaml:"duplicate_action" validate:"omitempty,oneof=skip move run"`
+
 	// Timeout for executing command.
 	Timeout time.Duration `yaml:"timeout" validate:"min=0"`
 
+	// Amount of time to wait after sending SIGTERM to the process group of
+	// a command on timeout or shutdown before sending SIGKILL.
+	KillGrace time.Duration `yaml:"kill_grace" validate:"min=0"`
+
+	// Capturing of the command output.
+	Output CommandOutput `yaml:"output"`
+
+	// Run the command as a filter from standard input to standard output.
+	Stdin Stdin `yaml:"stdin"`
+
+	// Resource limits for the command.
+	Rlimits Rlimits `yaml:"rlimits"`
+
+	// Limits enforced using a cgroup per command. Requires a delegated cgroup
+	// v2 hierarchy.
+	Cgroup CgroupLimits `yaml:"cgroup"`
+
+	// Filesystem restrictions for the command.
+	Landlock Landlock `yaml:"landlock"`
+
+	// Isolation of the command using Linux namespaces.
+	Namespaces Namespaces `yaml:"namespaces"`
+
 	// Observe input directory recursively (excluding the infrastructure
 	// directories).
 	Recursive bool `yaml:"recursive"`
@@ -48,6 +147,15 @@ type Handler struct {
 	// Maximum file size for running command
 	MaxSizeBytes uint64 `yaml:"max_size_bytes"`
 
+	// Minimum amount of free space on the filesystems holding the observed
+	// and journal directories before starting a command. Use zero to
+	// disable.
+	MinFreeBytes uint64 `yaml:"min_free_bytes"`
+
+	// Minimum amount of free space in percent of the filesystem size. Use
+	// zero to disable.
+	MinFreePercent float64 `yaml:"min_free_percent" validate:"min=0,max=100"`
+
 	// Amount of time the filesystem should be idle before dispatching
 	// triggers.
 	SettleDuration time.Duration `yaml:"settle_duration" validate:"min=0"`
@@ -74,11 +182,19 @@ type Handler struct {
 	// How long to keep journal entries.
 	JournalRetention time.Duration `yaml:"journal_retention" validate:"min=1h|gtefield=Timeout|gtefield=RetryDelayMax"`
 
+	// Amount of time after which journal entries are compressed into
+	// a single archive. Use 0s to disable compression.
+	JournalCompressAfter time.Duration `yaml:"journal_compress_after" validate:"min=0"`
+
 	// Directory into which files are moved whose processing succeeded.
 	SuccessDir string `yaml:"success_dir" validate:"required"`
 
 	// Directory into which files are moved whose processing failed.
 	FailureDir string `yaml:"failure_dir" validate:"required"`
+
+	// Directory into which duplicate files are moved if the duplicate action
+	// is "move".
+	DuplicatesDir string `yaml:"duplicates_dir" validate:"required"`
 }
 
 var _ yaml.InterfaceUnmarshaler = (*Handler)(nil)
@@ -88,5 +204,28 @@ func (h *Handler) UnmarshalYAML(unmarshal func(any) error) error {
 
 	type handler Handler
 
-	return unmarshal((*handler)(h))
+	if err := unmarshal((*handler)(h)); err != nil {
+		return err
+	}
+
+	return h.validateStdin()
+}
+
+// validateStdin rejects a filter output directory resolving to the observed
+// directory. Outputs moved there would be picked up as changed files.
+func (h *Handler) validateStdin() error {
+	if !h.Stdin.Enabled || h.Stdin.OutputDir == "" {
+		return nil
+	}
+
+	r, err := relpath.Resolve(h.Path, h.Stdin.OutputDir)
+	if err != nil {
+		return err
+	}
+
+	if r.Relative == "." {
+		return fmt.Errorf("%w: stdin output_dir %q must differ from handler path %q", os.ErrInvalid, h.Stdin.OutputDir, h.Path)
+	}
+
+	return nil
 }
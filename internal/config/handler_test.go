@@ -4,6 +4,8 @@ import (
 	"regexp"
 	"testing"
 	"time"
+
+	"github.com/hansmi/baamhackl/internal/ref"
 )
 
 func TestHandler(t *testing.T) {
@@ -21,6 +23,7 @@ command: ["/bin/false"]
 				Path:              "foo/bar",
 				Command:           []string{"/bin/false"},
 				Timeout:           time.Hour,
+				KillGrace:         10 * time.Second,
 				SettleDuration:    time.Second,
 				RetryCount:        2,
 				RetryDelayInitial: 15 * time.Minute,
@@ -30,6 +33,10 @@ command: ["/bin/false"]
 				JournalRetention:  7 * 24 * time.Hour,
 				SuccessDir:        "_/success",
 				FailureDir:        "_/failure",
+				DuplicatesDir:     "_/duplicates",
+				Output: CommandOutput{
+					MaxBytes: 16 << 20,
+				},
 			},
 		},
 		{
@@ -39,9 +46,27 @@ command: ["/bin/false"]
 name: custom
 path: /abs/path
 command: ["/bin/true", "arg"]
+user: ocr
+group: "1234"
+supplementary_groups: [scanner, video]
+input_checksum: true
+duplicate_action: move
 timeout: 3m17s
+kill_grace: 30s
+output:
+  max_bytes: 65536
+  separate: true
+  log: true
+rlimits:
+  address_space_bytes: 4294967296
+  core_size_bytes: 0
+cgroup:
+  memory_max_bytes: 1073741824
+  cpu_max: 1.5
 recursive: true
 include_hidden: true
+min_free_bytes: 1048576
+min_free_percent: 2.5
 settle_duration: 3s
 retry_count: 123
 retry_delay_initial: 7m3s
@@ -49,25 +74,50 @@ retry_delay_factor: 7
 retry_delay_max: 2h
 journal_dir: /another/dir
 journal_retention: 2h7s
+journal_compress_after: 1h30m
 success_dir: /another/success
 failure_dir: /another/failure
+duplicates_dir: /another/duplicates
 `,
 			want: Handler{
-				Name:              "custom",
-				Path:              "/abs/path",
-				Command:           []string{"/bin/true", "arg"},
-				Timeout:           3*time.Minute + 17*time.Second,
-				Recursive:         true,
-				IncludeHidden:     true,
-				SettleDuration:    3 * time.Second,
-				RetryCount:        123,
-				RetryDelayInitial: 7*time.Minute + 3*time.Second,
-				RetryDelayFactor:  7,
-				RetryDelayMax:     2 * time.Hour,
-				JournalDir:        "/another/dir",
-				JournalRetention:  2*time.Hour + 7*time.Second,
-				SuccessDir:        "/another/success",
-				FailureDir:        "/another/failure",
+				Name:                "custom",
+				Path:                "/abs/path",
+				Command:             []string{"/bin/true", "arg"},
+				User:                "ocr",
+				Group:               "1234",
+				SupplementaryGroups: []string{"scanner", "video"},
+				InputChecksum:       true,
+				DuplicateAction:     DuplicateActionMove,
+				Timeout:             3*time.Minute + 17*time.Second,
+				KillGrace:           30 * time.Second,
+				Output: CommandOutput{
+					MaxBytes: 64 << 10,
+					Separate: true,
+					Log:      true,
+				},
+				Rlimits: Rlimits{
+					AddressSpaceBytes: ref.Ref[uint64](4 << 30),
+					CoreSizeBytes:     ref.Ref[uint64](0),
+				},
+				Cgroup: CgroupLimits{
+					MemoryMaxBytes: 1 << 30,
+					CPUMax:         1.5,
+				},
+				Recursive:            true,
+				IncludeHidden:        true,
+				MinFreeBytes:         1024 * 1024,
+				MinFreePercent:       2.5,
+				SettleDuration:       3 * time.Second,
+				RetryCount:           123,
+				RetryDelayInitial:    7*time.Minute + 3*time.Second,
+				RetryDelayFactor:     7,
+				RetryDelayMax:        2 * time.Hour,
+				JournalDir:           "/another/dir",
+				JournalRetention:     2*time.Hour + 7*time.Second,
+				JournalCompressAfter: 90 * time.Minute,
+				SuccessDir:           "/another/success",
+				FailureDir:           "/another/failure",
+				DuplicatesDir:        "/another/duplicates",
 			},
 		},
 		{
@@ -111,5 +161,230 @@ command: []
 			want:    Handler{},
 			wantErr: regexp.MustCompile(`(?i)\bcommand\b.*\bfailed\b.*\bgte\b`),
 		},
+		{
+			name: "free percent too large",
+			input: `
+---
+name: free
+path: foo/bar
+command: ["/bin/true"]
+min_free_percent: 101
+`,
+			want:    Handler{},
+			wantErr: regexp.MustCompile(`(?i)\bmin_free_percent\b.*\bfailed\b.*\bmax\b`),
+		},
+		{
+			name: "invalid duplicate action",
+			input: `
+---
+name: dup
+path: foo/bar
+command: ["/bin/true"]
+duplicate_action: ignore
+`,
+			want:    Handler{},
+			wantErr: regexp.MustCompile(`(?i)\bduplicate_action\b.*\bfailed\b.*\boneof\b`),
+		},
+		{
+			name: "landlock",
+			input: `
+---
+name: sandboxed
+path: foo/bar
+command: ["/bin/true"]
+landlock:
+  enabled: true
+  read_only: [/usr, /etc/ssl]
+  read_write: [/srv/output]
+  unsupported: ignore
+`,
+			want: func() Handler {
+				o := HandlerDefaults
+				o.Name = "sandboxed"
+				o.Path = "foo/bar"
+				o.Command = []string{"/bin/true"}
+				o.Landlock = Landlock{
+					Enabled:     true,
+					ReadOnly:    []string{"/usr", "/etc/ssl"},
+					ReadWrite:   []string{"/srv/output"},
+					Unsupported: LandlockUnsupportedIgnore,
+				}
+				return o
+			}(),
+		},
+		{
+			name: "namespaces",
+			input: `
+---
+name: isolated
+path: foo/bar
+command: ["/bin/true"]
+namespaces:
+  enabled: true
+  network: true
+  read_only: [/usr]
+  read_write: [/srv/output]
+`,
+			want: func() Handler {
+				o := HandlerDefaults
+				o.Name = "isolated"
+				o.Path = "foo/bar"
+				o.Command = []string{"/bin/true"}
+				o.Namespaces = Namespaces{
+					Enabled:   true,
+					Network:   true,
+					ReadOnly:  []string{"/usr"},
+					ReadWrite: []string{"/srv/output"},
+				}
+				return o
+			}(),
+		},
+		{
+			name: "environment",
+			input: `
+---
+name: upload
+path: foo/bar
+command: ["/bin/true"]
+env:
+  BUCKET: archive
+env_files:
+  TOKEN: /run/secrets/token
+clear_env: true
+env_allowlist: [PATH, HOME]
+`,
+			want: func() Handler {
+				o := HandlerDefaults
+				o.Name = "upload"
+				o.Path = "foo/bar"
+				o.Command = []string{"/bin/true"}
+				o.Env = map[string]string{"BUCKET": "archive"}
+				o.EnvFiles = map[string]string{"TOKEN": "/run/secrets/token"}
+				o.ClearEnv = true
+				o.EnvAllowlist = []string{"PATH", "HOME"}
+				return o
+			}(),
+		},
+		{
+			name: "invalid environment variable name",
+			input: `
+---
+name: upload
+path: foo/bar
+command: ["/bin/true"]
+env:
+  "A=B": value
+`,
+			want:    Handler{},
+			wantErr: regexp.MustCompile(`(?i)\benv\b.*\bfailed\b.*\bexcludes\b`),
+		},
+		{
+			name: "missing environment file",
+			input: `
+---
+name: upload
+path: foo/bar
+command: ["/bin/true"]
+env_files:
+  TOKEN: ""
+`,
+			want:    Handler{},
+			wantErr: regexp.MustCompile(`(?i)\benv_?files\b.*\bfailed\b.*\brequired\b`),
+		},
+		{
+			name: "landlock relative path",
+			input: `
+---
+name: sandboxed
+path: foo/bar
+command: ["/bin/true"]
+landlock:
+  enabled: true
+  read_write: [output]
+`,
+			want:    Handler{},
+			wantErr: regexp.MustCompile(`(?i)\bread_write\b.*\bfailed\b.*\bstartswith\b`),
+		},
+		{
+			name: "stdin",
+			input: `
+---
+name: compress
+path: foo/bar
+command: ["gzip"]
+stdin:
+  enabled: true
+  output_dir: _/compressed
+  output_suffix: .gz
+`,
+			want: func() Handler {
+				o := HandlerDefaults
+				o.Name = "compress"
+				o.Path = "foo/bar"
+				o.Command = []string{"gzip"}
+				o.Stdin = Stdin{
+					Enabled:      true,
+					OutputDir:    "_/compressed",
+					OutputSuffix: ".gz",
+				}
+				return o
+			}(),
+		},
+		{
+			name: "stdin without output directory",
+			input: `
+---
+name: compress
+path: foo/bar
+command: ["gzip"]
+stdin:
+  enabled: true
+`,
+			want:    Handler{},
+			wantErr: regexp.MustCompile(`(?i)\boutput_dir\b.*\bfailed\b.*\brequired_if\b`),
+		},
+		{
+			name: "stdin output in observed directory",
+			input: `
+---
+name: compress
+path: foo/bar
+command: ["gzip"]
+stdin:
+  enabled: true
+  output_dir: ./
+`,
+			want:    Handler{},
+			wantErr: regexp.MustCompile(`(?i)\boutput_dir\b.*\bmust differ from handler path\b`),
+		},
+		{
+			name: "stdin output in observed directory by absolute path",
+			input: `
+---
+name: compress
+path: /srv/input
+command: ["gzip"]
+stdin:
+  enabled: true
+  output_dir: /srv/input/
+`,
+			want:    Handler{},
+			wantErr: regexp.MustCompile(`(?i)\boutput_dir\b.*\bmust differ from handler path\b`),
+		},
+		{
+			name: "stdin suffix with slash",
+			input: `
+---
+name: compress
+path: foo/bar
+command: ["gzip"]
+stdin:
+  enabled: true
+  output_dir: /srv/output
+  output_suffix: /x
+`,
+			want:    Handler{},
+			wantErr: regexp.MustCompile(`(?i)\boutput_suffix\b.*\bfailed\b.*\bexcludes\b`),
+		},
 	}.run(t)
 }
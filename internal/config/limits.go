@@ -0,0 +1,57 @@
+package config
+
+// Rlimits configures resource limits applied to handler commands. Unset
+// limits are inherited from the Baamhackl process.
+type Rlimits struct {
+	// Maximum size of the virtual memory (RLIMIT_AS).
+	AddressSpaceBytes *uint64 `yaml:"address_space_bytes"`
+
+	// Amount of CPU time in seconds (RLIMIT_CPU).
+	CPUSeconds *uint64 `yaml:"cpu_seconds"`
+
+	// Maximum number of open file descriptors (RLIMIT_NOFILE).
+	OpenFiles *uint64 `yaml:"open_files"`
+
+	// Maximum size of files created by the command (RLIMIT_FSIZE).
+	FileSizeBytes *uint64 `yaml:"file_size_bytes"`
+
+	// Maximum size of core dumps (RLIMIT_CORE).
+	CoreSizeBytes *uint64 `yaml:"core_size_bytes"`
+}
+
+// Empty reports whether no limit is configured.
+func (r Rlimits) Empty() bool {
+	return r.AddressSpaceBytes == nil && r.CPUSeconds == nil &&
+		r.OpenFiles == nil && r.FileSizeBytes == nil && r.CoreSizeBytes == nil
+}
+
+// CgroupLimits configures limits enforced via a dedicated cgroup per command.
+type CgroupLimits struct {
+	// Memory usage limit (memory.max). Zero for no limit.
+	MemoryMaxBytes uint64 `yaml:"memory_max_bytes"`
+
+	// Maximum number of CPUs the command may use (cpu.max), e.g. 0.5 for half
+	// a CPU. Zero for no limit.
+	CPUMax float64 `yaml:"cpu_max" validate:"min=0"`
+}
+
+// Enabled reports whether a cgroup should be created for commands.
+func (c CgroupLimits) Enabled() bool {
+	return c.MemoryMaxBytes > 0 || c.CPUMax > 0
+}
+
+// CommandOutput configures how the output of commands is captured in the
+// journal.
+type CommandOutput struct {
+	// Maximum number of bytes kept per output file. The first and last half
+	// are retained with a marker in between if the output is larger. Zero for
+	// no limit.
+	MaxBytes uint64 `yaml:"max_bytes"`
+
+	// Write standard output and standard error to separate files.
+	Separate bool `yaml:"separate"`
+
+	// Forward output lines to the task log at debug level while the command
+	// is running.
+	Log bool `yaml:"log"`
+}
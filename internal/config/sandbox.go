@@ -0,0 +1,48 @@
+package config
+
+// Policies for running commands on kernels without Landlock support.
+const (
+	// Refuse to run the command.
+	LandlockUnsupportedError = "error"
+
+	// Run the command without filesystem restrictions.
+	LandlockUnsupportedIgnore = "ignore"
+)
+
+// Landlock configures filesystem restrictions for commands using the Linux
+// Landlock security module. The copy of the changed file is always readable
+// and the working directory writable.
+type Landlock struct {
+	// Whether to restrict filesystem access.
+	Enabled bool `yaml:"enabled"`
+
+	// Absolute paths to which read and execute access is granted, e.g.
+	// "/usr".
+	ReadOnly []string `yaml:"read_only" validate:"dive,startswith=/"`
+
+	// Absolute paths to which full access is granted, e.g. output
+	// directories.
+	ReadWrite []string `yaml:"read_write" validate:"dive,startswith=/"`
+
+	// What to do if the kernel doesn't support Landlock. Defaults to
+	// "error".
+	Unsupported string `yaml:"unsupported" validate:"omitempty,oneof=error ignore"`
+}
+
+// Namespaces configures running commands in new user, mount, PID, IPC and
+// network namespaces. Commands only see a minimal filesystem containing the
+// input and working directories in addition to the configured paths.
+type Namespaces struct {
+	// Whether to run commands in new namespaces.
+	Enabled bool `yaml:"enabled"`
+
+	// Keep access to the network of the host instead of using a new network
+	// namespace.
+	Network bool `yaml:"network"`
+
+	// Absolute paths made available read-only, e.g. "/usr".
+	ReadOnly []string `yaml:"read_only" validate:"dive,startswith=/"`
+
+	// Absolute paths made available read-write, e.g. output directories.
+	ReadWrite []string `yaml:"read_write" validate:"dive,startswith=/"`
+}
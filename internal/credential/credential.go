@@ -0,0 +1,117 @@
+// Package credential resolves the user and groups configured for handler
+// commands.
+package credential
+
+import (
+	"fmt"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"github.com/hansmi/baamhackl/internal/config"
+	"go.uber.org/multierr"
+)
+
+func parseID(value string) (uint32, error) {
+	id, err := strconv.ParseUint(value, 10, 32)
+
+	return uint32(id), err
+}
+
+func lookupUser(name string) (*user.User, error) {
+	if _, err := parseID(name); err == nil {
+		return user.LookupId(name)
+	}
+
+	return user.Lookup(name)
+}
+
+// lookupGroup resolves a group name or numeric ID. Numeric IDs without an
+// entry in the group database are accepted.
+func lookupGroup(name string) (uint32, error) {
+	if id, err := parseID(name); err == nil {
+		return id, nil
+	}
+
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return 0, err
+	}
+
+	return parseID(g.Gid)
+}
+
+// Resolve returns the credential for running the command of a handler. Nil is
+// returned if neither a user nor a group is configured. Without explicitly
+// configured supplementary groups those of the user are used.
+func Resolve(cfg *config.Handler) (*syscall.Credential, error) {
+	if cfg.User == "" && cfg.Group == "" && cfg.SupplementaryGroups == nil {
+		return nil, nil
+	}
+
+	cred := &syscall.Credential{
+		Uid: uint32(syscall.Getuid()),
+		Gid: uint32(syscall.Getgid()),
+	}
+
+	var u *user.User
+
+	if cfg.User != "" {
+		var err error
+
+		if u, err = lookupUser(cfg.User); err != nil {
+			return nil, fmt.Errorf("user %q: %w", cfg.User, err)
+		}
+
+		if cred.Uid, err = parseID(u.Uid); err != nil {
+			return nil, err
+		}
+
+		if cred.Gid, err = parseID(u.Gid); err != nil {
+			return nil, err
+		}
+	}
+
+	if cfg.Group != "" {
+		gid, err := lookupGroup(cfg.Group)
+		if err != nil {
+			return nil, fmt.Errorf("group %q: %w", cfg.Group, err)
+		}
+
+		cred.Gid = gid
+	}
+
+	groups := cfg.SupplementaryGroups
+
+	if groups == nil && u != nil {
+		var err error
+
+		if groups, err = u.GroupIds(); err != nil {
+			return nil, fmt.Errorf("groups of user %q: %w", cfg.User, err)
+		}
+	}
+
+	for _, name := range groups {
+		gid, err := lookupGroup(name)
+		if err != nil {
+			return nil, fmt.Errorf("supplementary group %q: %w", name, err)
+		}
+
+		cred.Groups = append(cred.Groups, gid)
+	}
+
+	return cred, nil
+}
+
+// Check verifies that the users and groups configured for all handlers exist.
+func Check(handlers []*config.Handler) error {
+	var result error
+
+	for _, h := range handlers {
+		if _, err := Resolve(h); err != nil {
+			multierr.AppendInto(&result, fmt.Errorf("handler %q: %w", h.Name, err))
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,127 @@
+package credential
+
+import (
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hansmi/baamhackl/internal/config"
+)
+
+func TestResolve(t *testing.T) {
+	current, err := user.Current()
+	if err != nil {
+		t.Skipf("Current user unknown: %v", err)
+	}
+
+	uid, _ := strconv.ParseUint(current.Uid, 10, 32)
+	gid, _ := strconv.ParseUint(current.Gid, 10, 32)
+
+	groupIDs, err := current.GroupIds()
+	if err != nil {
+		t.Skipf("Groups of current user unknown: %v", err)
+	}
+
+	var groups []uint32
+
+	for _, i := range groupIDs {
+		id, _ := strconv.ParseUint(i, 10, 32)
+		groups = append(groups, uint32(id))
+	}
+
+	for _, tc := range []struct {
+		name    string
+		cfg     config.Handler
+		want    *syscall.Credential
+		wantErr string
+	}{
+		{name: "unconfigured"},
+		{
+			name: "user name",
+			cfg:  config.Handler{User: current.Username},
+			want: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: groups},
+		},
+		{
+			name: "numeric user",
+			cfg:  config.Handler{User: current.Uid},
+			want: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), Groups: groups},
+		},
+		{
+			name: "groups",
+			cfg: config.Handler{
+				User:                current.Username,
+				Group:               "12345",
+				SupplementaryGroups: []string{"23456", current.Gid},
+			},
+			want: &syscall.Credential{Uid: uint32(uid), Gid: 12345, Groups: []uint32{23456, uint32(gid)}},
+		},
+		{
+			name: "no supplementary groups",
+			cfg: config.Handler{
+				User:                current.Uid,
+				SupplementaryGroups: []string{},
+			},
+			want: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)},
+		},
+		{
+			name: "group only",
+			cfg:  config.Handler{Group: "12345"},
+			want: &syscall.Credential{Uid: uint32(syscall.Getuid()), Gid: 12345},
+		},
+		{
+			name:    "unknown user",
+			cfg:     config.Handler{User: "baamhackl-unknown-user"},
+			wantErr: `user "baamhackl-unknown-user"`,
+		},
+		{
+			name:    "unknown group",
+			cfg:     config.Handler{Group: "baamhackl-unknown-group"},
+			wantErr: `group "baamhackl-unknown-group"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Resolve(&tc.cfg)
+
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Errorf("Resolve() returned %v, want error containing %q", err, tc.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Errorf("Resolve() failed: %v", err)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Resolve() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCheck(t *testing.T) {
+	err := Check([]*config.Handler{
+		{Name: "valid"},
+		{Name: "first", User: "baamhackl-unknown-user"},
+		{Name: "second", Group: "baamhackl-unknown-group"},
+	})
+
+	if err == nil {
+		t.Fatalf("Check() succeeded unexpectedly")
+	}
+
+	for _, want := range []string{`handler "first"`, `handler "second"`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Error %q doesn't contain %q", err, want)
+		}
+	}
+
+	if strings.Contains(err.Error(), `"valid"`) {
+		t.Errorf("Error %q mentions valid handler", err)
+	}
+}
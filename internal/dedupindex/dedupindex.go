@@ -0,0 +1,157 @@
+// Package dedupindex implements a persistent index of file checksums for
+// detecting duplicate files.
+package dedupindex
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/renameio/v2"
+	"github.com/jonboulle/clockwork"
+)
+
+var clock clockwork.Clock = clockwork.NewRealClock()
+
+// Entry describes the first time a particular content was processed.
+type Entry struct {
+	// Path to the journal entry.
+	Journal string `json:"journal"`
+
+	// Whether processing was successful.
+	Success bool `json:"success"`
+
+	// Time when processing finished.
+	Time time.Time `json:"time"`
+}
+
+type fileContent struct {
+	Entries map[string]Entry `json:"entries"`
+}
+
+// Index maps file checksums to journal entries. Entries older than the
+// retention time are ignored and eventually removed. The index is stored in
+// a JSON file.
+type Index struct {
+	path      string
+	retention time.Duration
+
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+func New(path string, retention time.Duration) *Index {
+	return &Index{
+		path:      path,
+		retention: retention,
+	}
+}
+
+func (i *Index) expired(e Entry) bool {
+	return i.retention > 0 && e.Time.Before(clock.Now().Add(-i.retention))
+}
+
+func (i *Index) loadLocked() error {
+	if i.entries != nil {
+		return nil
+	}
+
+	var content fileContent
+
+	if buf, err := os.ReadFile(i.path); err == nil {
+		if err := json.Unmarshal(buf, &content); err != nil {
+			return fmt.Errorf("%s: %w", i.path, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	i.entries = content.Entries
+
+	if i.entries == nil {
+		i.entries = map[string]Entry{}
+	}
+
+	return nil
+}
+
+func (i *Index) saveLocked() error {
+	buf, err := json.MarshalIndent(fileContent{Entries: i.entries}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return renameio.WriteFile(i.path, append(buf, '\n'), 0o600)
+}
+
+// Lookup returns the entry for a checksum, if any.
+func (i *Index) Lookup(checksum []byte) (Entry, bool, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if err := i.loadLocked(); err != nil {
+		return Entry{}, false, err
+	}
+
+	e, ok := i.entries[hex.EncodeToString(checksum)]
+
+	if ok && i.expired(e) {
+		return Entry{}, false, nil
+	}
+
+	return e, ok, nil
+}
+
+// Record stores the outcome of processing a file with the given checksum.
+// The first successful entry is retained until it expires.
+func (i *Index) Record(checksum []byte, e Entry) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if err := i.loadLocked(); err != nil {
+		return err
+	}
+
+	key := hex.EncodeToString(checksum)
+
+	if existing, ok := i.entries[key]; ok && existing.Success && !i.expired(existing) {
+		return nil
+	}
+
+	if e.Time.IsZero() {
+		e.Time = clock.Now()
+	}
+
+	i.entries[key] = e
+
+	return i.saveLocked()
+}
+
+// Prune removes expired entries. The number of removed entries is returned.
+func (i *Index) Prune() (int, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if err := i.loadLocked(); err != nil {
+		return 0, err
+	}
+
+	count := 0
+
+	for key, e := range i.entries {
+		if i.expired(e) {
+			delete(i.entries, key)
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 0, nil
+	}
+
+	return count, i.saveLocked()
+}
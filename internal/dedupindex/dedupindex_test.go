@@ -0,0 +1,104 @@
+package dedupindex
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hansmi/baamhackl/internal/testutil"
+	"github.com/jonboulle/clockwork"
+)
+
+func TestIndex(t *testing.T) {
+	fc := clockwork.NewFakeClockAt(time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC))
+	testutil.ReplaceClock(t, &clock, fc)
+
+	path := filepath.Join(t.TempDir(), "index.json")
+	first := []byte{1, 2, 3}
+	second := []byte{4, 5, 6}
+
+	idx := New(path, time.Hour)
+
+	if _, ok, err := idx.Lookup(first); err != nil {
+		t.Errorf("Lookup() failed: %v", err)
+	} else if ok {
+		t.Errorf("Lookup() found entry in empty index")
+	}
+
+	testutil.MustNotExist(t, path)
+
+	failure := Entry{Journal: "/journal/failure"}
+
+	if err := idx.Record(first, failure); err != nil {
+		t.Errorf("Record() failed: %v", err)
+	}
+
+	failure.Time = fc.Now()
+
+	success := Entry{Journal: "/journal/success", Success: true}
+
+	// Failures are replaced by later entries.
+	if err := idx.Record(first, success); err != nil {
+		t.Errorf("Record() failed: %v", err)
+	}
+
+	success.Time = fc.Now()
+
+	fc.Advance(time.Minute)
+
+	// The first successful entry is retained.
+	if err := idx.Record(first, Entry{Journal: "/journal/later", Success: true}); err != nil {
+		t.Errorf("Record() failed: %v", err)
+	}
+
+	if err := idx.Record(second, failure); err != nil {
+		t.Errorf("Record() failed: %v", err)
+	}
+
+	// Entries are persisted.
+	idx = New(path, time.Hour)
+
+	for _, tc := range []struct {
+		checksum []byte
+		want     Entry
+	}{
+		{checksum: first, want: success},
+		{checksum: second, want: failure},
+	} {
+		if got, ok, err := idx.Lookup(tc.checksum); err != nil {
+			t.Errorf("Lookup() failed: %v", err)
+		} else if !ok {
+			t.Errorf("Lookup(%x) found no entry", tc.checksum)
+		} else if diff := cmp.Diff(tc.want, got); diff != "" {
+			t.Errorf("Lookup(%x) diff (-want +got):\n%s", tc.checksum, diff)
+		}
+	}
+
+	fc.Advance(2 * time.Hour)
+
+	if _, ok, err := idx.Lookup(first); err != nil {
+		t.Errorf("Lookup() failed: %v", err)
+	} else if ok {
+		t.Errorf("Lookup() returned expired entry")
+	}
+
+	if count, err := idx.Prune(); err != nil {
+		t.Errorf("Prune() failed: %v", err)
+	} else if diff := cmp.Diff(2, count); diff != "" {
+		t.Errorf("Pruned entry count diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestIndexCorrupt(t *testing.T) {
+	path := testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "index.json"), "{")
+
+	if _, _, err := New(path, 0).Lookup(nil); err == nil {
+		t.Errorf("Lookup() succeeded on corrupt index")
+	}
+
+	if err := os.Remove(path); err != nil {
+		t.Error(err)
+	}
+}
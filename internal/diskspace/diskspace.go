@@ -0,0 +1,89 @@
+// Package diskspace checks whether filesystems have enough free space
+// available.
+package diskspace
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+var ErrLow = errors.New("insufficient free disk space")
+
+// Usage describes the space available on the filesystem holding a path.
+type Usage struct {
+	Path string
+
+	// Number of bytes available to unprivileged users.
+	Free uint64
+
+	// Total size of the filesystem in bytes.
+	Total uint64
+}
+
+// FreePercent returns the amount of free space as a percentage of the total
+// filesystem size.
+func (u Usage) FreePercent() float64 {
+	if u.Total == 0 {
+		return 0
+	}
+
+	return 100 * float64(u.Free) / float64(u.Total)
+}
+
+// Stat retrieves usage information for the filesystem holding path. If path
+// doesn't exist the nearest existing parent directory is used.
+func Stat(path string) (Usage, error) {
+	var st unix.Statfs_t
+
+	path = filepath.Clean(path)
+
+	for {
+		err := unix.Statfs(path, &st)
+		if err == nil {
+			break
+		}
+
+		parent := filepath.Dir(path)
+
+		if !errors.Is(err, unix.ENOENT) || parent == path {
+			return Usage{}, &os.PathError{Op: "statfs", Path: path, Err: err}
+		}
+
+		path = parent
+	}
+
+	return Usage{
+		Path:  path,
+		Free:  st.Bavail * uint64(st.Bsize),
+		Total: st.Blocks * uint64(st.Bsize),
+	}, nil
+}
+
+// Threshold describes the minimum amount of free space.
+type Threshold struct {
+	MinFreeBytes   uint64
+	MinFreePercent float64
+}
+
+// Enabled returns whether any limit is configured.
+func (t Threshold) Enabled() bool {
+	return t.MinFreeBytes > 0 || t.MinFreePercent > 0
+}
+
+// Check returns an error wrapping ErrLow if the free space is below the
+// threshold.
+func (t Threshold) Check(u Usage) error {
+	if t.MinFreeBytes > 0 && u.Free < t.MinFreeBytes {
+		return fmt.Errorf("%w: %s: %d bytes available, %d required", ErrLow, u.Path, u.Free, t.MinFreeBytes)
+	}
+
+	if t.MinFreePercent > 0 && u.FreePercent() < t.MinFreePercent {
+		return fmt.Errorf("%w: %s: %.1f%% available, %.1f%% required", ErrLow, u.Path, u.FreePercent(), t.MinFreePercent)
+	}
+
+	return nil
+}
@@ -0,0 +1,107 @@
+package diskspace
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestStat(t *testing.T) {
+	tmpdir := t.TempDir()
+
+	for _, tc := range []struct {
+		name     string
+		path     string
+		wantPath string
+	}{
+		{name: "existing", path: tmpdir, wantPath: tmpdir},
+		{name: "missing", path: filepath.Join(tmpdir, "a", "b"), wantPath: tmpdir},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Stat(tc.path)
+			if err != nil {
+				t.Fatalf("Stat(%q) failed: %v", tc.path, err)
+			}
+
+			if diff := cmp.Diff(tc.wantPath, got.Path); diff != "" {
+				t.Errorf("Path diff (-want +got):\n%s", diff)
+			}
+
+			if got.Free > got.Total {
+				t.Errorf("Free space larger than total: %+v", got)
+			}
+		})
+	}
+}
+
+func TestStatNotDir(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+
+	if err := os.WriteFile(path, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Stat(filepath.Join(path, "child")); err == nil {
+		t.Errorf("Stat() succeeded on child of file")
+	}
+}
+
+func TestThreshold(t *testing.T) {
+	usage := Usage{
+		Path:  "/test",
+		Free:  250,
+		Total: 1000,
+	}
+
+	for _, tc := range []struct {
+		name        string
+		threshold   Threshold
+		wantEnabled bool
+		wantErr     error
+	}{
+		{name: "disabled"},
+		{
+			name:        "bytes sufficient",
+			threshold:   Threshold{MinFreeBytes: 250},
+			wantEnabled: true,
+		},
+		{
+			name:        "bytes low",
+			threshold:   Threshold{MinFreeBytes: 251},
+			wantEnabled: true,
+			wantErr:     ErrLow,
+		},
+		{
+			name:        "percent sufficient",
+			threshold:   Threshold{MinFreePercent: 25},
+			wantEnabled: true,
+		},
+		{
+			name:        "percent low",
+			threshold:   Threshold{MinFreePercent: 25.5},
+			wantEnabled: true,
+			wantErr:     ErrLow,
+		},
+		{
+			name:        "both",
+			threshold:   Threshold{MinFreeBytes: 100, MinFreePercent: 50},
+			wantEnabled: true,
+			wantErr:     ErrLow,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if diff := cmp.Diff(tc.wantEnabled, tc.threshold.Enabled()); diff != "" {
+				t.Errorf("Enabled() diff (-want +got):\n%s", diff)
+			}
+
+			err := tc.threshold.Check(usage)
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("Error diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
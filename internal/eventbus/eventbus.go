@@ -0,0 +1,246 @@
+// Package eventbus distributes task lifecycle events to interested readers.
+package eventbus
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+var clock clockwork.Clock = clockwork.NewRealClock()
+
+// DefaultHistory is the number of events retained for readers by default.
+const DefaultHistory = 1024
+
+// Type identifies the kind of event.
+type Type string
+
+const (
+	// A file change was reported by Watchman.
+	FileReported Type = "file_reported"
+
+	// A task was added to the queue for a changed file.
+	TaskQueued Type = "task_queued"
+
+	// An attempt at running the handler command is starting.
+	AttemptStarted Type = "attempt_started"
+
+	// The handler command exited.
+	CommandExited Type = "command_exited"
+
+	// An attempt failed and another attempt will be made.
+	RetryScheduled Type = "retry_scheduled"
+
+	// The changed file was moved to the success or failure directory.
+	Archived Type = "archived"
+
+	// A task finished, either successfully or with a permanent failure.
+	TaskFinished Type = "task_finished"
+
+	// An old entry was removed from the journal or an archive directory.
+	Pruned Type = "pruned"
+)
+
+// Event describes a single occurrence. Fields not applicable to the type are
+// left empty.
+type Event struct {
+	// Sequence number assigned by the bus, starting at 1.
+	Seq uint64 `json:"seq"`
+
+	Time    time.Time `json:"time"`
+	Type    Type      `json:"type"`
+	Handler string    `json:"handler,omitempty"`
+
+	// Name of the changed file relative to the handler path.
+	Name string `json:"name,omitempty"`
+
+	// Attempt number, starting at 0.
+	Attempt *int `json:"attempt,omitempty"`
+
+	// Exit code of the handler command.
+	ExitCode *int `json:"exit_code,omitempty"`
+
+	// Whether the task succeeded.
+	Success *bool `json:"success,omitempty"`
+
+	// Signal which ended the handler command, e.g. "terminated".
+	Signal string `json:"signal,omitempty"`
+
+	// Earliest time for the next attempt.
+	RetryAfter *time.Time `json:"retry_after,omitempty"`
+
+	// Affected path, e.g. the destination of an archived file.
+	Path string `json:"path,omitempty"`
+
+	// Error message, if any.
+	Error string `json:"error,omitempty"`
+}
+
+// Filter reports whether an event should be delivered to a reader.
+type Filter func(Event) bool
+
+// HandlerFilter returns a filter accepting events from the given handlers.
+// All events are accepted if no handlers are given.
+func HandlerFilter(handlers []string) Filter {
+	if len(handlers) == 0 {
+		return nil
+	}
+
+	return func(e Event) bool {
+		return slices.Contains(handlers, e.Handler)
+	}
+}
+
+// Bus retains a bounded history of events. Readers poll for events after
+// a sequence number of their choice. Events are lost for readers falling
+// behind by more than the history size.
+type Bus struct {
+	history int
+
+	mu      sync.Mutex
+	events  []Event
+	lastSeq uint64
+	changed chan struct{}
+}
+
+// New creates a bus retaining the given number of events.
+func New(history int) *Bus {
+	if history < 1 {
+		history = DefaultHistory
+	}
+
+	return &Bus{
+		history: history,
+		changed: make(chan struct{}),
+	}
+}
+
+// Publish assigns a sequence number to the event and wakes up waiting readers.
+// The time is set if it's zero.
+func (b *Bus) Publish(e Event) {
+	if e.Time.IsZero() {
+		e.Time = clock.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastSeq++
+	e.Seq = b.lastSeq
+
+	if len(b.events) >= b.history {
+		b.events = slices.Delete(b.events, 0, len(b.events)-b.history+1)
+	}
+
+	b.events = append(b.events, e)
+
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// LastSeq returns the sequence number of the most recent event.
+func (b *Bus) LastSeq() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.lastSeq
+}
+
+func (b *Bus) collectLocked(after uint64, filter Filter) []Event {
+	var result []Event
+
+	for _, e := range b.events {
+		if e.Seq > after && (filter == nil || filter(e)) {
+			result = append(result, e)
+		}
+	}
+
+	return result
+}
+
+// Wait blocks until events with a sequence number larger than after and
+// accepted by the filter are available or the context is done. The returned
+// cursor is to be used as after in the next call.
+func (b *Bus) Wait(ctx context.Context, after uint64, filter Filter) ([]Event, uint64, error) {
+	for {
+		b.mu.Lock()
+		events := b.collectLocked(after, filter)
+		changed := b.changed
+
+		if after < b.lastSeq {
+			after = b.lastSeq
+		}
+		b.mu.Unlock()
+
+		if len(events) > 0 {
+			return events, after, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, after, ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// Emitter publishes events on behalf of a handler and, optionally, a single
+// changed file and attempt. The zero value discards all events.
+type Emitter struct {
+	Bus     *Bus
+	Handler string
+	Name    string
+	Attempt *int
+}
+
+// WithName returns a copy of the emitter for the given changed file.
+func (em Emitter) WithName(name string) Emitter {
+	em.Name = name
+	return em
+}
+
+// WithAttempt returns a copy of the emitter for the given attempt.
+func (em Emitter) WithAttempt(attempt int) Emitter {
+	em.Attempt = Int(attempt)
+	return em
+}
+
+// Emit fills in the handler, file name and attempt if they're not set and
+// publishes the event.
+func (em Emitter) Emit(e Event) {
+	if em.Bus == nil {
+		return
+	}
+
+	if e.Handler == "" {
+		e.Handler = em.Handler
+	}
+
+	if e.Name == "" {
+		e.Name = em.Name
+	}
+
+	if e.Attempt == nil {
+		e.Attempt = em.Attempt
+	}
+
+	em.Bus.Publish(e)
+}
+
+// Int returns a pointer to the given value for use in optional event fields.
+func Int(v int) *int {
+	return &v
+}
+
+// Bool returns a pointer to the given value for use in optional event fields.
+func Bool(v bool) *bool {
+	return &v
+}
+
+// Time returns a pointer to the given value for use in optional event fields.
+func Time(v time.Time) *time.Time {
+	return &v
+}
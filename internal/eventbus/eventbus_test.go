@@ -0,0 +1,136 @@
+package eventbus
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hansmi/baamhackl/internal/testutil"
+	"github.com/jonboulle/clockwork"
+)
+
+func seqs(events []Event) []uint64 {
+	var result []uint64
+
+	for _, e := range events {
+		result = append(result, e.Seq)
+	}
+
+	return result
+}
+
+func TestBus(t *testing.T) {
+	fc := clockwork.NewFakeClockAt(time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC))
+	testutil.ReplaceClock(t, &clock, fc)
+
+	b := New(3)
+
+	first := Emitter{Bus: b, Handler: "first"}
+	second := Emitter{Bus: b, Handler: "second"}
+
+	first.WithName("a.txt").Emit(Event{Type: FileReported})
+	second.Emit(Event{Type: Pruned, Path: "/tmp/x"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	events, cursor, err := b.Wait(ctx, 0, nil)
+	if err != nil {
+		t.Fatalf("Wait() failed: %v", err)
+	}
+
+	if diff := cmp.Diff([]Event{
+		{Seq: 1, Time: fc.Now(), Type: FileReported, Handler: "first", Name: "a.txt"},
+		{Seq: 2, Time: fc.Now(), Type: Pruned, Handler: "second", Path: "/tmp/x"},
+	}, events); diff != "" {
+		t.Errorf("Events diff (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(uint64(2), cursor); diff != "" {
+		t.Errorf("Cursor diff (-want +got):\n%s", diff)
+	}
+
+	// History is limited
+	for range 3 {
+		first.Emit(Event{Type: TaskQueued})
+	}
+
+	events, cursor, err = b.Wait(ctx, 0, nil)
+	if err != nil {
+		t.Errorf("Wait() failed: %v", err)
+	}
+
+	if diff := cmp.Diff([]uint64{3, 4, 5}, seqs(events)); diff != "" {
+		t.Errorf("Sequence number diff (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(uint64(5), cursor); diff != "" {
+		t.Errorf("Cursor diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestBusWaitFilter(t *testing.T) {
+	b := New(0)
+
+	first := Emitter{Bus: b, Handler: "first"}
+	second := Emitter{Bus: b, Handler: "second"}
+
+	filter := HandlerFilter([]string{"second"})
+
+	result := make(chan []Event, 1)
+
+	go func() {
+		events, _, err := b.Wait(context.Background(), 0, filter)
+		if err != nil {
+			t.Errorf("Wait() failed: %v", err)
+		}
+
+		result <- events
+	}()
+
+	first.Emit(Event{Type: TaskQueued})
+	second.Emit(Event{Type: TaskQueued})
+
+	select {
+	case events := <-result:
+		if diff := cmp.Diff([]uint64{2}, seqs(events)); diff != "" {
+			t.Errorf("Sequence number diff (-want +got):\n%s", diff)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Waiting for events timed out")
+	}
+
+	if got := b.LastSeq(); got != 2 {
+		t.Errorf("LastSeq() returned %d, want 2", got)
+	}
+}
+
+func TestBusWaitCancel(t *testing.T) {
+	b := New(0)
+
+	Emitter{Bus: b, Handler: "first"}.Emit(Event{Type: TaskQueued})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	events, cursor, err := b.Wait(ctx, 0, HandlerFilter([]string{"other"}))
+
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait() returned %v, want cancellation", err)
+	}
+
+	if len(events) != 0 {
+		t.Errorf("Wait() returned events: %+v", events)
+	}
+
+	// Events rejected by the filter are skipped.
+	if diff := cmp.Diff(uint64(1), cursor); diff != "" {
+		t.Errorf("Cursor diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestEmitterWithoutBus(t *testing.T) {
+	Emitter{Handler: "test"}.Emit(Event{Type: TaskQueued})
+}
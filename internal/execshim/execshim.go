@@ -0,0 +1,194 @@
+// Package execshim implements a small wrapper applying process attributes
+// which can't be configured via os/exec before replacing itself with the
+// actual handler command.
+package execshim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+
+	"github.com/google/subcommands"
+	"github.com/hansmi/baamhackl/internal/cmdutil"
+	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/exepath"
+)
+
+// Name of the subcommand implementing the shim.
+const CommandName = "exec-shim"
+
+var rlimitResources = map[string]int{
+	"as":     syscall.RLIMIT_AS,
+	"cpu":    syscall.RLIMIT_CPU,
+	"nofile": syscall.RLIMIT_NOFILE,
+	"fsize":  syscall.RLIMIT_FSIZE,
+	"core":   syscall.RLIMIT_CORE,
+}
+
+// Rlimit is a resource limit applied to both the soft and hard limit.
+type Rlimit struct {
+	Resource string `json:"resource"`
+	Value    uint64 `json:"value"`
+}
+
+// Spec describes the attributes applied by the shim.
+type Spec struct {
+	Rlimits   []Rlimit   `json:"rlimits,omitempty"`
+	Landlock  *Landlock  `json:"landlock,omitempty"`
+	Namespace *Namespace `json:"namespace,omitempty"`
+}
+
+// Empty reports whether the shim has nothing to do.
+func (s Spec) Empty() bool {
+	return len(s.Rlimits) == 0 && s.Landlock == nil && s.Namespace == nil
+}
+
+// RlimitsFromConfig converts configured resource limits.
+func RlimitsFromConfig(cfg config.Rlimits) []Rlimit {
+	var result []Rlimit
+
+	for _, i := range []struct {
+		resource string
+		value    *uint64
+	}{
+		{"as", cfg.AddressSpaceBytes},
+		{"cpu", cfg.CPUSeconds},
+		{"nofile", cfg.OpenFiles},
+		{"fsize", cfg.FileSizeBytes},
+		{"core", cfg.CoreSizeBytes},
+	} {
+		if i.value != nil {
+			result = append(result, Rlimit{i.resource, *i.value})
+		}
+	}
+
+	return result
+}
+
+// DefaultShim returns the arguments for invoking the shim from the current
+// executable.
+func DefaultShim() ([]string, error) {
+	exe, err := exepath.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{exe, CommandName}, nil
+}
+
+// Wrap returns the arguments for running a command via the shim.
+func Wrap(shim []string, spec Spec, command []string) ([]string, error) {
+	buf, err := json.Marshal(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	args := append([]string(nil), shim...)
+	args = append(args, "-spec", string(buf), "--")
+	args = append(args, command...)
+
+	return args, nil
+}
+
+func applyRlimits(limits []Rlimit) error {
+	for _, i := range limits {
+		resource, ok := rlimitResources[i.Resource]
+		if !ok {
+			return fmt.Errorf("unknown resource %q", i.Resource)
+		}
+
+		if err := syscall.Setrlimit(resource, &syscall.Rlimit{
+			Cur: i.Value,
+			Max: i.Value,
+		}); err != nil {
+			return fmt.Errorf("setting %s limit to %d failed: %w", i.Resource, i.Value, err)
+		}
+	}
+
+	return nil
+}
+
+// Run applies the attributes described by the JSON-encoded spec to the
+// current process and executes the command. It only returns on failure.
+func Run(specJSON string, command []string) error {
+	var spec Spec
+
+	if err := json.Unmarshal([]byte(specJSON), &spec); err != nil {
+		return fmt.Errorf("parsing spec failed: %w", err)
+	}
+
+	if len(command) < 1 {
+		return errors.New("missing command")
+	}
+
+	// Landlock restrictions and capabilities apply to the calling thread
+	// only. The command must be executed from the same thread.
+	runtime.LockOSThread()
+
+	if spec.Namespace != nil {
+		if err := spec.Namespace.setup(); err != nil {
+			return fmt.Errorf("setting up namespace failed: %w", err)
+		}
+	}
+
+	path, err := exec.LookPath(command[0])
+	if err != nil {
+		return err
+	}
+
+	if err := applyRlimits(spec.Rlimits); err != nil {
+		return err
+	}
+
+	if spec.Landlock != nil {
+		if err := spec.Landlock.restrict(); err != nil {
+			return err
+		}
+	}
+
+	if spec.Namespace != nil {
+		if err := dropCapabilities(); err != nil {
+			return err
+		}
+
+		return runInit(path, command)
+	}
+
+	return syscall.Exec(path, command, os.Environ())
+}
+
+// Command implements the "exec-shim" subcommand.
+type Command struct {
+	spec string
+}
+
+func (*Command) Name() string {
+	return CommandName
+}
+
+func (*Command) Synopsis() string {
+	return "Apply process attributes and execute a handler command."
+}
+
+func (c *Command) Usage() string {
+	return cmdutil.Usage(c, "-- <command> [args...]", `Used internally to apply resource limits, filesystem restrictions and namespace isolation before executing handler commands.`)
+}
+
+func (c *Command) SetFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.spec, "spec", "{}", "JSON-formatted description of process attributes.")
+}
+
+func (c *Command) Execute(ctx context.Context, fs *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return subcommands.ExitUsageError
+	}
+
+	return cmdutil.ExecuteStatus(Run(c.spec, fs.Args()))
+}
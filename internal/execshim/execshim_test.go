@@ -0,0 +1,361 @@
+package execshim
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hansmi/baamhackl/internal/cmdemu"
+	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/exepath"
+	"github.com/hansmi/baamhackl/internal/ref"
+	"github.com/hansmi/baamhackl/internal/testutil"
+)
+
+var fakeShim = cmdemu.Command{
+	Name: "shim",
+	Execute: func(args []string) error {
+		fs := flag.NewFlagSet("", flag.ContinueOnError)
+		spec := fs.String("spec", "{}", "")
+
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+
+		return Run(*spec, fs.Args())
+	},
+}
+
+var printLimits = cmdemu.Command{
+	Name: "print-limits",
+	Execute: func(args []string) error {
+		for _, resource := range []string{"core", "nofile"} {
+			var rlim syscall.Rlimit
+
+			if err := syscall.Getrlimit(rlimitResources[resource], &rlim); err != nil {
+				return err
+			}
+
+			fmt.Printf("%s=%d/%d\n", resource, rlim.Cur, rlim.Max)
+		}
+
+		return nil
+	},
+}
+
+// accessFiles tries to read ("r:<path>") or write ("w:<path>") files and
+// prints the outcome for each.
+var accessFiles = cmdemu.Command{
+	Name: "access-files",
+	Execute: func(args []string) error {
+		for _, arg := range args {
+			op, path, _ := strings.Cut(arg, ":")
+
+			var err error
+
+			if op == "w" {
+				err = os.WriteFile(path, []byte("content"), 0o644)
+			} else {
+				_, err = os.ReadFile(path)
+			}
+
+			if err == nil {
+				fmt.Println("ok")
+			} else if errors.Is(err, os.ErrPermission) || errors.Is(err, syscall.EROFS) {
+				fmt.Println("denied")
+			} else if errors.Is(err, os.ErrNotExist) {
+				fmt.Println("missing")
+			} else {
+				return err
+			}
+		}
+
+		return nil
+	},
+}
+
+func TestMain(m *testing.M) {
+	w := cmdemu.New(flag.CommandLine)
+	w.Register(fakeShim)
+	w.Register(printLimits)
+	w.Register(accessFiles)
+	os.Exit(w.Main(m))
+}
+
+func TestRlimitsFromConfig(t *testing.T) {
+	got := RlimitsFromConfig(config.Rlimits{
+		CPUSeconds:    ref.Ref[uint64](60),
+		CoreSizeBytes: ref.Ref[uint64](0),
+	})
+
+	if diff := cmp.Diff([]Rlimit{
+		{"cpu", 60},
+		{"core", 0},
+	}, got); diff != "" {
+		t.Errorf("RlimitsFromConfig() diff (-want +got):\n%s", diff)
+	}
+
+	if got := RlimitsFromConfig(config.Rlimits{}); len(got) != 0 {
+		t.Errorf("RlimitsFromConfig() returned %v for empty configuration", got)
+	}
+}
+
+func TestRun(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		spec    Spec
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "limits",
+			spec: Spec{
+				Rlimits: []Rlimit{
+					{"core", 0},
+					{"nofile", 64},
+				},
+			},
+			want: []string{"core=0/0", "nofile=64/64"},
+		},
+		{
+			name: "unknown resource",
+			spec: Spec{
+				Rlimits: []Rlimit{{"unknown", 1}},
+			},
+			wantErr: `unknown resource "unknown"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			args, err := Wrap(fakeShim.MakeArgs(), tc.spec, printLimits.MakeArgs())
+			if err != nil {
+				t.Fatalf("Wrap() failed: %v", err)
+			}
+
+			var stdout, stderr strings.Builder
+
+			cmd := exec.Command(args[0], args[1:]...)
+			cmd.Stdout = &stdout
+			cmd.Stderr = &stderr
+
+			err = cmd.Run()
+
+			if tc.wantErr != "" {
+				var exitErr *exec.ExitError
+
+				if !errors.As(err, &exitErr) {
+					t.Errorf("Run() returned %v, want exit error", err)
+				}
+
+				if !strings.Contains(stderr.String(), tc.wantErr) {
+					t.Errorf("Error output %q doesn't contain %q", stderr.String(), tc.wantErr)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("Run() failed: %v\n%s", err, stderr.String())
+			}
+
+			if diff := cmp.Diff(tc.want, strings.Fields(stdout.String())); diff != "" {
+				t.Errorf("Limits diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRunLandlock(t *testing.T) {
+	if _, err := landlockABI(); err != nil {
+		t.Skipf("Landlock unavailable: %v", err)
+	}
+
+	readDir := t.TempDir()
+	writeDir := t.TempDir()
+	otherDir := t.TempDir()
+
+	testutil.MustWriteFile(t, filepath.Join(readDir, "allowed.txt"), "")
+	testutil.MustWriteFile(t, filepath.Join(otherDir, "secret.txt"), "")
+
+	// Access to the test program and its shared libraries
+	readOnly := []string{filepath.Dir(exepath.MustGet()), readDir}
+
+	for _, i := range []string{"/lib", "/lib32", "/lib64", "/usr"} {
+		if _, err := os.Stat(i); err == nil {
+			readOnly = append(readOnly, i)
+		}
+	}
+
+	args, err := Wrap(fakeShim.MakeArgs(), Spec{
+		Landlock: &Landlock{
+			ReadOnly:  readOnly,
+			ReadWrite: []string{writeDir},
+		},
+	}, accessFiles.MakeArgs(
+		"r:"+filepath.Join(readDir, "allowed.txt"),
+		"w:"+filepath.Join(readDir, "new.txt"),
+		"r:"+filepath.Join(otherDir, "secret.txt"),
+		"w:"+filepath.Join(writeDir, "output.txt"),
+		"r:"+filepath.Join(writeDir, "output.txt"),
+	))
+	if err != nil {
+		t.Fatalf("Wrap() failed: %v", err)
+	}
+
+	var stdout, stderr strings.Builder
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Run() failed: %v\n%s", err, stderr.String())
+	}
+
+	if diff := cmp.Diff([]string{"ok", "denied", "denied", "ok", "ok"}, strings.Fields(stdout.String())); diff != "" {
+		t.Errorf("Access diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestRunLandlockMissingPath(t *testing.T) {
+	if _, err := landlockABI(); err != nil {
+		t.Skipf("Landlock unavailable: %v", err)
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	args, err := Wrap(fakeShim.MakeArgs(), Spec{
+		Landlock: &Landlock{
+			ReadOnly: []string{missing},
+		},
+	}, printLimits.MakeArgs())
+	if err != nil {
+		t.Fatalf("Wrap() failed: %v", err)
+	}
+
+	var stderr strings.Builder
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err == nil {
+		t.Errorf("Run() succeeded despite missing path")
+	}
+
+	if !strings.Contains(stderr.String(), missing) {
+		t.Errorf("Error output %q doesn't mention %q", stderr.String(), missing)
+	}
+}
+
+func TestCheckLandlock(t *testing.T) {
+	handlers := []*config.Handler{
+		{Name: "disabled"},
+		{Name: "ignore", Landlock: config.Landlock{Enabled: true, Unsupported: config.LandlockUnsupportedIgnore}},
+		{Name: "first", Landlock: config.Landlock{Enabled: true}},
+		{Name: "second", Landlock: config.Landlock{Enabled: true, Unsupported: config.LandlockUnsupportedError}},
+	}
+
+	t.Run("supported", func(t *testing.T) {
+		t.Cleanup(func() { landlockProbe = landlockABI })
+		landlockProbe = func() (int, error) { return 1, nil }
+
+		if err := CheckLandlock(handlers); err != nil {
+			t.Errorf("CheckLandlock() failed: %v", err)
+		}
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		t.Cleanup(func() { landlockProbe = landlockABI })
+		landlockProbe = func() (int, error) { return 0, ErrLandlockUnsupported }
+
+		err := CheckLandlock(handlers)
+
+		if !errors.Is(err, ErrLandlockUnsupported) {
+			t.Fatalf("CheckLandlock() returned %v, want %v", err, ErrLandlockUnsupported)
+		}
+
+		for _, want := range []string{`handler "first"`, `handler "second"`} {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("Error %q doesn't contain %q", err, want)
+			}
+		}
+
+		for _, unwanted := range []string{`"disabled"`, `"ignore"`} {
+			if strings.Contains(err.Error(), unwanted) {
+				t.Errorf("Error %q mentions %s", err, unwanted)
+			}
+		}
+	})
+}
+
+func TestNamespacesSupported(t *testing.T) {
+	if err := NamespacesSupported(); !(err == nil || errors.Is(err, ErrNamespacesUnsupported)) {
+		t.Errorf("NamespacesSupported() failed: %v", err)
+	}
+}
+
+func TestRunNamespace(t *testing.T) {
+	readDir := t.TempDir()
+	writeDir := t.TempDir()
+	otherDir := t.TempDir()
+
+	testutil.MustWriteFile(t, filepath.Join(readDir, "allowed.txt"), "")
+	testutil.MustWriteFile(t, filepath.Join(otherDir, "secret.txt"), "")
+
+	readOnly := []string{exepath.MustGet(), readDir}
+
+	for _, i := range []string{"/lib", "/lib32", "/lib64", "/usr"} {
+		if _, err := os.Stat(i); err == nil {
+			readOnly = append(readOnly, i)
+		}
+	}
+
+	ns := &Namespace{
+		ReadOnly:       readOnly,
+		ReadWrite:      []string{writeDir},
+		PrivateNetwork: true,
+	}
+
+	args, err := Wrap(fakeShim.MakeArgs(), Spec{Namespace: ns}, accessFiles.MakeArgs(
+		"r:"+filepath.Join(readDir, "allowed.txt"),
+		"w:"+filepath.Join(readDir, "new.txt"),
+		"r:"+filepath.Join(otherDir, "secret.txt"),
+		"w:"+filepath.Join(writeDir, "output.txt"),
+		"r:"+filepath.Join(writeDir, "output.txt"),
+		"w:/dev/null",
+	))
+	if err != nil {
+		t.Fatalf("Wrap() failed: %v", err)
+	}
+
+	var stdout, stderr strings.Builder
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.Dir = writeDir
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+
+	ns.SetupProcAttr(cmd.SysProcAttr)
+
+	if err := cmd.Start(); err != nil {
+		t.Skipf("Namespaces unavailable: %v", err)
+	}
+
+	if err := cmd.Wait(); err != nil {
+		t.Fatalf("Wait() failed: %v\n%s", err, stderr.String())
+	}
+
+	if diff := cmp.Diff([]string{"ok", "denied", "missing", "ok", "ok", "ok"}, strings.Fields(stdout.String())); diff != "" {
+		t.Errorf("Access diff (-want +got):\n%s", diff)
+	}
+
+	testutil.MustLstat(t, filepath.Join(writeDir, "output.txt"))
+	testutil.MustNotExist(t, filepath.Join(readDir, "new.txt"))
+}
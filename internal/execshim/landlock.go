@@ -0,0 +1,177 @@
+package execshim
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"unsafe"
+
+	"github.com/hansmi/baamhackl/internal/config"
+	"go.uber.org/multierr"
+	"golang.org/x/sys/unix"
+)
+
+// ErrLandlockUnsupported is returned if the kernel doesn't support Landlock.
+var ErrLandlockUnsupported = errors.New("Landlock is not supported by the kernel")
+
+// Landlock describes filesystem access permitted to the command. All other
+// filesystem access is denied.
+type Landlock struct {
+	// Paths to which read and execute access is granted.
+	ReadOnly []string `json:"read_only,omitempty"`
+
+	// Paths to which full access is granted.
+	ReadWrite []string `json:"read_write,omitempty"`
+}
+
+const landlockReadAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+	unix.LANDLOCK_ACCESS_FS_READ_FILE |
+	unix.LANDLOCK_ACCESS_FS_READ_DIR
+
+// Access rights applicable to files as opposed to directories.
+const landlockFileAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+	unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+	unix.LANDLOCK_ACCESS_FS_READ_FILE |
+	unix.LANDLOCK_ACCESS_FS_TRUNCATE |
+	unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+
+// landlockABI returns the Landlock ABI version supported by the kernel.
+func landlockABI() (int, error) {
+	version, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		if errno == unix.ENOSYS || errno == unix.EOPNOTSUPP {
+			return 0, ErrLandlockUnsupported
+		}
+
+		return 0, errno
+	}
+
+	return int(version), nil
+}
+
+// Function probing for Landlock support. Replaced in tests.
+var landlockProbe = landlockABI
+
+// LandlockSupported returns ErrLandlockUnsupported if the kernel doesn't
+// support Landlock.
+func LandlockSupported() error {
+	_, err := landlockProbe()
+
+	return err
+}
+
+// CheckLandlock verifies that Landlock is available for all handlers which
+// refuse to run commands without filesystem restrictions.
+func CheckLandlock(handlers []*config.Handler) error {
+	var result error
+
+	for _, h := range handlers {
+		if !h.Landlock.Enabled || h.Landlock.Unsupported == config.LandlockUnsupportedIgnore {
+			continue
+		}
+
+		if err := LandlockSupported(); err != nil {
+			multierr.AppendInto(&result, fmt.Errorf("handler %q: %w", h.Name, err))
+		}
+	}
+
+	return result
+}
+
+// landlockHandledAccess returns the filesystem access rights known to the
+// given ABI version.
+func landlockHandledAccess(abi int) uint64 {
+	access := uint64(unix.LANDLOCK_ACCESS_FS_MAKE_SYM<<1 - 1)
+
+	if abi >= 2 {
+		access |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+
+	if abi >= 3 {
+		access |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+
+	if abi >= 5 {
+		access |= unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+	}
+
+	return access
+}
+
+func landlockAddPath(rulesetFd int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return &os.PathError{Op: "open", Path: path, Err: err}
+	}
+
+	defer unix.Close(fd)
+
+	var st unix.Stat_t
+
+	if err := unix.Fstat(fd, &st); err != nil {
+		return &os.PathError{Op: "stat", Path: path, Err: err}
+	}
+
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= landlockFileAccess
+	}
+
+	attr := unix.LandlockPathBeneathAttr{
+		Allowed_access: access,
+		Parent_fd:      int32(fd),
+	}
+
+	if _, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(rulesetFd),
+		unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("adding Landlock rule for %q failed: %w", path, errno)
+	}
+
+	return nil
+}
+
+// restrict enforces the filesystem restrictions on the calling thread. The
+// caller must ensure that the command is executed from the same thread.
+func (l *Landlock) restrict() error {
+	abi, err := landlockABI()
+	if err != nil {
+		return err
+	}
+
+	handled := landlockHandledAccess(abi)
+
+	rulesetAttr := unix.LandlockRulesetAttr{
+		Access_fs: handled,
+	}
+
+	rulesetFd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET,
+		uintptr(unsafe.Pointer(&rulesetAttr)), unsafe.Sizeof(rulesetAttr), 0)
+	if errno != 0 {
+		return fmt.Errorf("creating Landlock ruleset failed: %w", errno)
+	}
+
+	defer unix.Close(int(rulesetFd))
+
+	for _, i := range []struct {
+		paths  []string
+		access uint64
+	}{
+		{l.ReadOnly, landlockReadAccess},
+		{l.ReadWrite, handled},
+	} {
+		for _, path := range i.paths {
+			if err := landlockAddPath(int(rulesetFd), path, i.access); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("setting no_new_privs failed: %w", err)
+	}
+
+	if _, _, errno := unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, rulesetFd, 0, 0); errno != 0 {
+		return fmt.Errorf("enforcing Landlock ruleset failed: %w", errno)
+	}
+
+	return nil
+}
@@ -0,0 +1,389 @@
+package execshim
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Namespace describes the filesystem view of a command running in new user,
+// mount, PID, IPC and optionally network namespaces. Paths are made available
+// at the same location as outside the namespaces. Everything else is hidden.
+type Namespace struct {
+	// Paths mounted read-only.
+	ReadOnly []string `json:"read_only,omitempty"`
+
+	// Paths mounted read-write.
+	ReadWrite []string `json:"read_write,omitempty"`
+
+	// Whether the command runs in a new network namespace with only
+	// a loopback interface.
+	PrivateNetwork bool `json:"private_network,omitempty"`
+}
+
+// ErrNamespacesUnsupported is returned if the system doesn't permit creating
+// user namespaces, e.g. due to kernel.unprivileged_userns_clone=0 or an
+// exhausted user.max_user_namespaces.
+var ErrNamespacesUnsupported = errors.New("user namespaces unavailable")
+
+// NamespacesSupported starts a trivial command in the namespaces used for
+// commands. ErrNamespacesUnsupported is returned if they can't be created.
+func NamespacesSupported() error {
+	path, err := exec.LookPath("true")
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(path)
+	cmd.SysProcAttr = &syscall.SysProcAttr{}
+
+	(&Namespace{}).SetupProcAttr(cmd.SysProcAttr)
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.ENOSPC) {
+			return fmt.Errorf("%w: %w", ErrNamespacesUnsupported, err)
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// Device nodes made available in the namespace.
+var namespaceDevices = []string{"null", "zero", "full", "random", "urandom"}
+
+// Capabilities required by the shim for setting up the namespace. They're
+// dropped before executing the command.
+var namespaceCaps = []uintptr{
+	unix.CAP_SYS_ADMIN,
+	unix.CAP_NET_ADMIN,
+	unix.CAP_SETPCAP,
+}
+
+// SetupProcAttr configures the attributes for starting the shim in new
+// namespaces. User and group IDs are mapped to themselves. The IDs of the
+// current process are used if attr has no credential.
+func (n *Namespace) SetupProcAttr(attr *syscall.SysProcAttr) {
+	uid := uint32(os.Geteuid())
+	gid := uint32(os.Getegid())
+	groups := []uint32(nil)
+
+	if cred := attr.Credential; cred != nil {
+		uid, gid, groups = cred.Uid, cred.Gid, cred.Groups
+	}
+
+	attr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS |
+		syscall.CLONE_NEWPID | syscall.CLONE_NEWIPC
+
+	if n.PrivateNetwork {
+		attr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+
+	attr.UidMappings = []syscall.SysProcIDMap{
+		{ContainerID: int(uid), HostID: int(uid), Size: 1},
+	}
+	attr.GidMappings = nil
+
+	for _, i := range append([]uint32{gid}, groups...) {
+		if !slices.ContainsFunc(attr.GidMappings, func(m syscall.SysProcIDMap) bool {
+			return m.HostID == int(i)
+		}) {
+			attr.GidMappings = append(attr.GidMappings, syscall.SysProcIDMap{
+				ContainerID: int(i), HostID: int(i), Size: 1,
+			})
+		}
+	}
+
+	// Mapping additional groups and calling setgroups(2) requires
+	// privileges. Unprivileged processes keep their supplementary groups.
+	attr.GidMappingsEnableSetgroups = attr.Credential != nil
+	attr.AmbientCaps = namespaceCaps
+}
+
+type bindMount struct {
+	path     string
+	readOnly bool
+	fd       int
+}
+
+// mountFlags returns the flags of the mount containing path which can't be
+// cleared when remounting from within a user namespace.
+func mountFlags(path string) (uintptr, error) {
+	var st unix.Statfs_t
+
+	if err := unix.Statfs(path, &st); err != nil {
+		return 0, &os.PathError{Op: "statfs", Path: path, Err: err}
+	}
+
+	// The ST_* constants share their values with the MS_* constants.
+	const mask = unix.ST_NOSUID | unix.ST_NODEV | unix.ST_NOEXEC |
+		unix.ST_NOATIME | unix.ST_NODIRATIME | unix.ST_RELATIME
+
+	return uintptr(st.Flags & mask), nil
+}
+
+// createMountPoint creates an empty file or directory for mounting unless the
+// path exists already, e.g. within a previously mounted directory.
+func createMountPoint(path string, dir bool) error {
+	if _, err := os.Lstat(path); err == nil {
+		return nil
+	}
+
+	if dir {
+		return os.MkdirAll(path, 0o755)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	fh, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	return fh.Close()
+}
+
+func (b bindMount) mount(root string) error {
+	var st unix.Stat_t
+
+	if err := unix.Fstat(b.fd, &st); err != nil {
+		return &os.PathError{Op: "stat", Path: b.path, Err: err}
+	}
+
+	target := filepath.Join(root, b.path)
+
+	if err := createMountPoint(target, st.Mode&unix.S_IFMT == unix.S_IFDIR); err != nil {
+		return fmt.Errorf("creating mount point for %q failed: %w", b.path, err)
+	}
+
+	// Open file descriptors remain usable after the original location is
+	// hidden by the new root.
+	source := fmt.Sprintf("/proc/self/fd/%d", b.fd)
+
+	if err := unix.Mount(source, target, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("mounting %q failed: %w", b.path, err)
+	}
+
+	if b.readOnly {
+		flags, err := mountFlags(target)
+		if err != nil {
+			return err
+		}
+
+		if err := unix.Mount("", target, "", flags|unix.MS_REMOUNT|unix.MS_BIND|unix.MS_RDONLY, ""); err != nil {
+			return fmt.Errorf("remounting %q read-only failed: %w", b.path, err)
+		}
+	}
+
+	return nil
+}
+
+func loopbackUp() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+
+	defer unix.Close(fd)
+
+	ifr, err := unix.NewIfreq("lo")
+	if err != nil {
+		return err
+	}
+
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return err
+	}
+
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+
+	return unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr)
+}
+
+// setup replaces the root directory of the mount namespace with a minimal
+// view containing only the configured paths, a private /tmp, /proc for the
+// PID namespace and a few device nodes. The working directory is retained.
+func (n *Namespace) setup() error {
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	var mounts []bindMount
+
+	defer func() {
+		for _, i := range mounts {
+			unix.Close(i.fd)
+		}
+	}()
+
+	for _, i := range []struct {
+		paths    []string
+		readOnly bool
+	}{
+		{n.ReadOnly, true},
+		{n.ReadWrite, false},
+	} {
+		for _, path := range i.paths {
+			fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+			if err != nil {
+				return &os.PathError{Op: "open", Path: path, Err: err}
+			}
+
+			mounts = append(mounts, bindMount{filepath.Clean(path), i.readOnly, fd})
+		}
+	}
+
+	for _, name := range namespaceDevices {
+		path := filepath.Join("/dev", name)
+
+		fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+		if err != nil {
+			return &os.PathError{Op: "open", Path: path, Err: err}
+		}
+
+		mounts = append(mounts, bindMount{path, false, fd})
+	}
+
+	// Parent directories must be mounted before their children.
+	slices.SortStableFunc(mounts, func(a, b bindMount) int {
+		return len(a.path) - len(b.path)
+	})
+
+	if n.PrivateNetwork {
+		if err := loopbackUp(); err != nil {
+			return fmt.Errorf("enabling loopback interface failed: %w", err)
+		}
+	}
+
+	// Keep mount changes from propagating to the parent namespace.
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("changing mount propagation failed: %w", err)
+	}
+
+	root := os.TempDir()
+
+	if err := unix.Mount("tmpfs", root, "tmpfs", unix.MS_NOSUID|unix.MS_NODEV, "mode=0755"); err != nil {
+		return fmt.Errorf("mounting new root failed: %w", err)
+	}
+
+	for _, i := range []struct {
+		path, fstype string
+		flags        uintptr
+		data         string
+	}{
+		{"/proc", "proc", unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC, ""},
+		{"/tmp", "tmpfs", unix.MS_NOSUID | unix.MS_NODEV, "mode=1777"},
+	} {
+		target := filepath.Join(root, i.path)
+
+		if err := createMountPoint(target, true); err != nil {
+			return err
+		}
+
+		if err := unix.Mount(i.fstype, target, i.fstype, i.flags, i.data); err != nil {
+			return fmt.Errorf("mounting %s failed: %w", i.path, err)
+		}
+	}
+
+	for _, i := range mounts {
+		if err := i.mount(root); err != nil {
+			return err
+		}
+	}
+
+	if err := unix.Mount("", root, "", unix.MS_REMOUNT|unix.MS_RDONLY|unix.MS_NOSUID|unix.MS_NODEV, ""); err != nil {
+		return fmt.Errorf("remounting new root read-only failed: %w", err)
+	}
+
+	if err := os.Chdir(root); err != nil {
+		return err
+	}
+
+	if err := unix.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("changing root failed: %w", err)
+	}
+
+	if err := unix.Unmount(".", unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("detaching old root failed: %w", err)
+	}
+
+	return os.Chdir(wd)
+}
+
+// dropCapabilities removes all capabilities from the calling thread.
+// Commands executed from the thread don't gain capabilities, not even when
+// running as root within the user namespace.
+func dropCapabilities() error {
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		return fmt.Errorf("clearing ambient capabilities failed: %w", err)
+	}
+
+	for c := uintptr(0); ; c++ {
+		if err := unix.Prctl(unix.PR_CAPBSET_DROP, c, 0, 0, 0); err == unix.EINVAL {
+			break
+		} else if err != nil {
+			return fmt.Errorf("dropping capability %d failed: %w", c, err)
+		}
+	}
+
+	hdr := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	data := [2]unix.CapUserData{}
+
+	if err := unix.Capset(&hdr, &data[0]); err != nil {
+		return fmt.Errorf("clearing capabilities failed: %w", err)
+	}
+
+	return nil
+}
+
+// runInit starts the command and waits for it to finish while acting as the
+// init process of the PID namespace. Orphaned processes are reaped. All
+// remaining processes in the namespace are killed by the kernel when the
+// shim exits with the status of the command.
+func runInit(path string, command []string) error {
+	// The init process of a PID namespace only receives signals for which
+	// it has a handler. The command receives signals sent to the process
+	// group directly.
+	signal.Notify(make(chan os.Signal, 1), unix.SIGTERM, unix.SIGINT,
+		unix.SIGHUP, unix.SIGQUIT, unix.SIGUSR1, unix.SIGUSR2)
+
+	pid, err := syscall.ForkExec(path, command, &syscall.ProcAttr{
+		Env:   os.Environ(),
+		Files: []uintptr{0, 1, 2},
+	})
+	if err != nil {
+		return fmt.Errorf("starting command failed: %w", err)
+	}
+
+	for {
+		var status unix.WaitStatus
+
+		wpid, err := unix.Wait4(-1, &status, 0, nil)
+		if err == unix.EINTR {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if wpid != pid {
+			continue
+		}
+
+		if status.Signaled() {
+			os.Exit(128 + int(status.Signal()))
+		}
+
+		os.Exit(status.ExitStatus())
+	}
+}
@@ -1,18 +1,53 @@
 package handlerattempt
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"os"
+	"time"
 
+	"github.com/hansmi/baamhackl/internal/cgroup"
 	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/credential"
+	"github.com/hansmi/baamhackl/internal/eventbus"
 	"github.com/hansmi/baamhackl/internal/handlercommand"
 	"github.com/hansmi/baamhackl/internal/journal"
+	"github.com/hansmi/baamhackl/internal/tracing"
 	"github.com/hansmi/baamhackl/internal/waryio"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/multierr"
 	"go.uber.org/zap"
 )
 
+var (
+	// ErrRetryRequested is returned if the command asked to be retried via
+	// its result file.
+	ErrRetryRequested = errors.New("command requested retry")
+
+	// ErrPermanentFailure is returned if the command reported a failure
+	// which can't be resolved by retrying.
+	ErrPermanentFailure = errors.New("command reported permanent failure")
+)
+
+// RetryDelayError requests a specific delay before the next attempt instead
+// of the configured one.
+type RetryDelayError struct {
+	Err   error
+	Delay time.Duration
+}
+
+func (e *RetryDelayError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryDelayError) Unwrap() error {
+	return e.Err
+}
+
 func validateChangedFile(path string) (os.FileInfo, error) {
 	fi, err := os.Lstat(path)
 	if err != nil {
@@ -45,21 +80,37 @@ type Options struct {
 	// Directory for storing execution-related files.
 	BaseDir string
 
+	// Journal entry of the task, recorded in the checksum index.
+	JournalEntry string
+
 	// Whether the attempt is the last one before giving up.
 	Final bool
 
+	// Information about the attempt made available to the command.
+	Metadata handlercommand.Metadata
+
 	// Function to acquire a lock preventing concurrent file changes by handler
 	// logic.
 	AcquireLock func()
 
 	// Interface for reporting metrics.
 	Metrics MetricsReporter
+
+	// Destination for lifecycle events.
+	Events eventbus.Emitter
+
+	// Manager for per-command cgroups. Nil if cgroups aren't available.
+	Cgroups *cgroup.Manager
 }
 
 type Attempt struct {
 	opts Options
 
-	run func(context.Context) error
+	prepare       func() error
+	run           func(context.Context) error
+	inputChecksum func() []byte
+	result        func() (*handlercommand.Result, error)
+	deliverOutput func() error
 }
 
 func New(opts Options) (*Attempt, error) {
@@ -67,34 +118,217 @@ func New(opts Options) (*Attempt, error) {
 		opts: opts,
 	}
 
+	cred, err := credential.Resolve(o.opts.Config)
+	if err != nil {
+		return nil, err
+	}
+
 	if cmd, err := handlercommand.New(handlercommand.Options{
-		Logger:     o.opts.Logger,
-		SourceFile: o.opts.ChangedFile,
-		BaseDir:    o.opts.BaseDir,
-		Command:    o.opts.Config.Command,
-		Metrics:    o.opts.Metrics,
+		Logger:       o.opts.Logger,
+		SourceFile:   o.opts.ChangedFile,
+		BaseDir:      o.opts.BaseDir,
+		Command:      o.opts.Config.Command,
+		Env:          o.opts.Config.Env,
+		EnvFiles:     o.opts.Config.EnvFiles,
+		ClearEnv:     o.opts.Config.ClearEnv,
+		EnvAllowlist: o.opts.Config.EnvAllowlist,
+		Checksum:     o.opts.Config.InputChecksum || o.opts.Config.DuplicateAction != "",
+		Metadata:     o.opts.Metadata,
+		Output:       o.opts.Config.Output,
+		Stdin:        o.opts.Config.Stdin,
+		HandlerPath:  o.opts.Config.Path,
+		Metrics:      o.opts.Metrics,
+		Events:       o.opts.Events,
+		KillGrace:    o.opts.Config.KillGrace,
+		Rlimits:      o.opts.Config.Rlimits,
+		Landlock:     o.opts.Config.Landlock,
+		Namespaces:   o.opts.Config.Namespaces,
+		Cgroup:       o.opts.Config.Cgroup,
+		Cgroups:      o.opts.Cgroups,
+		Credential:   cred,
 	}); err != nil {
 		return nil, err
 	} else {
+		o.prepare = cmd.Prepare
 		o.run = cmd.Run
+		o.inputChecksum = cmd.InputChecksum
+		o.result = cmd.Result
+		o.deliverOutput = cmd.DeliverOutput
 	}
 
 	return o, nil
 }
 
-func (o *Attempt) moveToArchive(success bool) error {
-	dest, err := o.opts.Journal.MoveToArchive(o.opts.ChangedFile, success)
+func (o *Attempt) moveToArchive(ctx context.Context, success bool) error {
+	dest, err := o.opts.Journal.MoveToArchive(ctx, o.opts.ChangedFile, success)
 	if err == nil && dest != "" {
 		o.opts.Logger.Info("Moved changed file",
 			zap.String("source", o.opts.ChangedFile),
 			zap.String("dest", dest),
 		)
+
+		o.opts.Events.Emit(eventbus.Event{
+			Type:    eventbus.Archived,
+			Success: eventbus.Bool(success),
+			Path:    dest,
+		})
+	}
+
+	return err
+}
+
+func (o *Attempt) moveToDuplicates() error {
+	dest, err := o.opts.Journal.MoveToDuplicates(o.opts.ChangedFile)
+	if err == nil && dest != "" {
+		o.opts.Logger.Info("Moved duplicate file",
+			zap.String("source", o.opts.ChangedFile),
+			zap.String("dest", dest),
+		)
 	}
 
 	return err
 }
 
-func (o *Attempt) Run(ctx context.Context) (bool, error) {
+func (o *Attempt) checksum() []byte {
+	if o.inputChecksum == nil {
+		return nil
+	}
+
+	return o.inputChecksum()
+}
+
+// checkDuplicate looks up the checksum of the changed file in the index of
+// previously processed files. The returned value reports whether running the
+// command should be skipped.
+func (o *Attempt) checkDuplicate() (bool, error) {
+	checksum := o.checksum()
+
+	if !o.opts.Journal.DedupEnabled() || checksum == nil {
+		return false, nil
+	}
+
+	entry, ok, err := o.opts.Journal.LookupDuplicate(checksum)
+	if err != nil {
+		return false, fmt.Errorf("checksum index lookup failed: %w", err)
+	}
+
+	if !ok {
+		return false, nil
+	}
+
+	action := o.opts.Config.DuplicateAction
+
+	o.opts.Logger.Info("Content was processed before",
+		zap.String("journal", entry.Journal),
+		zap.Time("time", entry.Time),
+		zap.String("action", action),
+	)
+
+	return action != config.DuplicateActionRun, nil
+}
+
+// recordOutcome stores the final outcome of processing in the checksum index.
+// Failures are logged, but don't affect the attempt.
+func (o *Attempt) recordOutcome(success bool) {
+	checksum := o.checksum()
+
+	if !o.opts.Journal.DedupEnabled() || checksum == nil {
+		return
+	}
+
+	if err := o.opts.Journal.RecordOutcome(checksum, o.opts.JournalEntry, success); err != nil {
+		o.opts.Logger.Error("Updating checksum index failed", zap.Error(err))
+	}
+}
+
+// verifyChecksum compares the checksum computed while copying the changed file
+// with the checksum of the original file.
+func (o *Attempt) verifyChecksum() error {
+	want := o.checksum()
+
+	if want == nil {
+		return nil
+	}
+
+	got, err := waryio.ChecksumFile(o.opts.ChangedFile, sha256.New())
+	if err != nil {
+		return fmt.Errorf("computing checksum failed: %w", err)
+	}
+
+	if !bytes.Equal(want, got) {
+		return fmt.Errorf("%w: content changed (SHA-256 %x != %x)", waryio.ErrFileChanged, want, got)
+	}
+
+	o.opts.Logger.Info("Checksum of changed file verified", zap.String("sha256", hex.EncodeToString(got)))
+
+	return nil
+}
+
+func resultError(base error, message string) error {
+	if message == "" {
+		return base
+	}
+
+	return fmt.Errorf("%w: %s", base, message)
+}
+
+// applyResult reads the result file written by the command and derives the
+// outcome of the command from it. The result is nil if the command didn't
+// write one or it was disregarded.
+func (o *Attempt) applyResult(commandErr error) (*handlercommand.Result, error) {
+	logger := o.opts.Logger
+
+	result, err := o.result()
+	if err != nil {
+		return nil, multierr.Append(commandErr, err)
+	}
+
+	if result == nil {
+		return nil, commandErr
+	}
+
+	logger.Info("Command result",
+		zap.String("outcome", result.Outcome),
+		zap.String("message", result.Message),
+		zap.Duration("retry_delay", result.RetryDelay),
+		zap.Strings("outputs", result.Outputs),
+		zap.Any("annotations", result.Annotations),
+	)
+
+	switch result.Outcome {
+	case handlercommand.ResultSuccess, handlercommand.ResultSkip:
+		if commandErr != nil {
+			logger.Warn("Ignoring result of failed command", zap.String("outcome", result.Outcome))
+			return nil, commandErr
+		}
+
+	case handlercommand.ResultRetry:
+		// Failures of the command remain visible.
+		err := multierr.Append(commandErr, resultError(ErrRetryRequested, result.Message))
+
+		if result.RetryDelay > 0 {
+			err = &RetryDelayError{Err: err, Delay: result.RetryDelay}
+		}
+
+		return result, err
+
+	case handlercommand.ResultPermanentFailure:
+		return result, multierr.Append(commandErr, resultError(ErrPermanentFailure, result.Message))
+	}
+
+	return result, commandErr
+}
+
+func (o *Attempt) Run(ctx context.Context) (permanent bool, err error) {
+	ctx, span := tracing.Start(ctx, "handlerattempt.Run",
+		tracing.PathKey.String(o.opts.ChangedFile),
+		attribute.Bool("baamhackl.final", o.opts.Final),
+	)
+	defer func() {
+		span.SetAttributes(attribute.Bool("baamhackl.permanent", permanent))
+		tracing.End(span, err)
+	}()
+
 	statBefore, err := validateChangedFile(o.opts.ChangedFile)
 	if err != nil {
 		return true, err
@@ -110,13 +344,30 @@ func (o *Attempt) Run(ctx context.Context) (bool, error) {
 	ctx, cancel := context.WithTimeout(ctx, o.opts.Config.Timeout)
 	defer cancel()
 
-	commandErr := o.run(ctx)
+	duplicate := false
+	commandErr := o.prepare()
+
+	if commandErr == nil {
+		duplicate, commandErr = o.checkDuplicate()
+	}
+
+	var result *handlercommand.Result
+
+	if commandErr == nil && !duplicate {
+		result, commandErr = o.applyResult(o.run(ctx))
+	}
+
+	// Commands may declare a failure as permanent or skip processing.
+	outcome := ""
+
+	if result != nil {
+		outcome = result.Outcome
+	}
 
 	if o.opts.AcquireLock != nil {
 		o.opts.AcquireLock()
 	}
 
-	permanent := false
 	combinedErr := commandErr
 
 	// The changed file is moved if and only it still exists and remains
@@ -131,8 +382,32 @@ func (o *Attempt) Run(ctx context.Context) (bool, error) {
 		permanent = os.IsNotExist(err)
 	} else if changes := waryio.DescribeChanges(statBefore, statAfter); !changes.Empty() {
 		multierr.AppendInto(&combinedErr, changes.Err())
-	} else if success := commandErr == nil; success || o.opts.Final {
-		multierr.AppendInto(&combinedErr, o.moveToArchive(success))
+	} else if err := o.verifyChecksum(); err != nil {
+		multierr.AppendInto(&combinedErr, err)
+	} else if duplicate && o.opts.Config.DuplicateAction == config.DuplicateActionMove {
+		multierr.AppendInto(&combinedErr, o.moveToDuplicates())
+	} else if success := commandErr == nil; success || o.opts.Final || outcome == handlercommand.ResultPermanentFailure {
+		archiveErr := o.moveToArchive(ctx, success)
+
+		multierr.AppendInto(&combinedErr, archiveErr)
+
+		permanent = outcome == handlercommand.ResultPermanentFailure
+
+		// Outputs of filter commands are only delivered once the changed file
+		// is archived. Otherwise a retry would deliver another copy.
+		if success && archiveErr == nil && !(duplicate || outcome == handlercommand.ResultSkip) {
+			if err := o.deliverOutput(); err != nil {
+				multierr.AppendInto(&combinedErr, err)
+
+				// The changed file is gone, retrying isn't possible.
+				success = false
+				permanent = true
+			}
+		}
+
+		if !(duplicate || outcome == handlercommand.ResultSkip) {
+			o.recordOutcome(success)
+		}
 	}
 
 	return permanent, combinedErr
@@ -2,10 +2,13 @@ package handlerattempt
 
 import (
 	"context"
+	"crypto/sha256"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -64,7 +67,10 @@ func TestAttempt(t *testing.T) {
 		name               string
 		opts               Options
 		run                func(context.Context) error
+		checksum           bool
+		result             *handlercommand.Result
 		wantErr            error
+		wantRetryDelay     time.Duration
 		wantPermanent      bool
 		changedFileRemains bool
 	}
@@ -179,6 +185,116 @@ func TestAttempt(t *testing.T) {
 		changedFileRemains: true,
 	})
 
+	sourceForRewrite := testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "src"), "rewrite1")
+	tests = append(tests, test{
+		name: "command rewrites input with same size and time",
+		opts: Options{
+			Config: func() *config.Handler {
+				o := config.HandlerDefaults
+				o.RetryCount = 2
+				return &o
+			}(),
+			ChangedFile: sourceForRewrite,
+		},
+		run: func(ctx context.Context) error {
+			st := testutil.MustLstat(t, sourceForRewrite)
+
+			testutil.MustWriteFile(t, sourceForRewrite, "rewrite2")
+
+			if err := os.Chtimes(sourceForRewrite, st.ModTime(), st.ModTime()); err != nil {
+				t.Errorf("Chtimes() failed: %v", err)
+			}
+
+			return nil
+		},
+		checksum:           true,
+		wantErr:            waryio.ErrFileChanged,
+		changedFileRemains: true,
+	})
+
+	tests = append(tests, test{
+		name: "checksum unchanged",
+		opts: Options{
+			Config: &config.Handler{},
+			Final:  true,
+		},
+		checksum: true,
+	})
+
+	tests = append(tests, test{
+		name: "result requests retry",
+		opts: Options{
+			Config: &config.Handler{},
+		},
+		result: &handlercommand.Result{
+			Outcome:    handlercommand.ResultRetry,
+			Message:    "service unavailable",
+			RetryDelay: 5 * time.Minute,
+		},
+		wantErr:            ErrRetryRequested,
+		wantRetryDelay:     5 * time.Minute,
+		changedFileRemains: true,
+	}, test{
+		name: "result reports permanent failure",
+		opts: Options{
+			Config: &config.Handler{},
+		},
+		result: &handlercommand.Result{
+			Outcome: handlercommand.ResultPermanentFailure,
+		},
+		wantErr:       ErrPermanentFailure,
+		wantPermanent: true,
+	}, test{
+		name: "result skips file",
+		opts: Options{
+			Config: &config.Handler{},
+		},
+		result: &handlercommand.Result{
+			Outcome: handlercommand.ResultSkip,
+		},
+	}, test{
+		name: "retry after command error",
+		opts: Options{
+			Config: &config.Handler{},
+		},
+		run: func(ctx context.Context) error {
+			return errCommand
+		},
+		result: &handlercommand.Result{
+			Outcome:    handlercommand.ResultRetry,
+			RetryDelay: time.Minute,
+		},
+		wantErr:            errCommand,
+		wantRetryDelay:     time.Minute,
+		changedFileRemains: true,
+	}, test{
+		name: "permanent failure after command error",
+		opts: Options{
+			Config: &config.Handler{},
+		},
+		run: func(ctx context.Context) error {
+			return errCommand
+		},
+		result: &handlercommand.Result{
+			Outcome: handlercommand.ResultPermanentFailure,
+		},
+		wantErr:       errCommand,
+		wantPermanent: true,
+	}, test{
+		name: "result ignored after command error",
+		opts: Options{
+			Config: &config.Handler{},
+		},
+		run: func(ctx context.Context) error {
+			return errCommand
+		},
+		result: &handlercommand.Result{
+			Outcome: handlercommand.ResultSuccess,
+		},
+		wantErr:            errCommand,
+		changedFileRemains: true,
+	})
+
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
 			ctx, cancel := context.WithCancel(context.Background())
@@ -208,6 +324,10 @@ func TestAttempt(t *testing.T) {
 				t.Fatalf("New() failed: %v", err)
 			}
 
+			h.prepare = func() error {
+				return nil
+			}
+
 			if tc.run == nil {
 				h.run = func(ctx context.Context) error {
 					return nil
@@ -216,6 +336,21 @@ func TestAttempt(t *testing.T) {
 				h.run = tc.run
 			}
 
+			h.result = func() (*handlercommand.Result, error) {
+				return tc.result, nil
+			}
+
+			if tc.checksum {
+				checksum, err := waryio.ChecksumFile(tc.opts.ChangedFile, sha256.New())
+				if err != nil {
+					t.Errorf("ChecksumFile() failed: %v", err)
+				}
+
+				h.inputChecksum = func() []byte {
+					return checksum
+				}
+			}
+
 			changedFileExistedBeforeRun := false
 
 			if _, err := os.Lstat(tc.opts.ChangedFile); err == nil {
@@ -234,6 +369,16 @@ func TestAttempt(t *testing.T) {
 				t.Errorf("Permanent error diff (-want +got):\n%s", diff)
 			}
 
+			var rde *RetryDelayError
+
+			if errors.As(err, &rde) {
+				if diff := cmp.Diff(tc.wantRetryDelay, rde.Delay); diff != "" {
+					t.Errorf("Retry delay diff (-want +got):\n%s", diff)
+				}
+			} else if tc.wantRetryDelay != 0 {
+				t.Errorf("Run() returned %v, want retry delay %v", err, tc.wantRetryDelay)
+			}
+
 			if changedFileExistedBeforeRun {
 				statAfter, err := os.Lstat(tc.opts.ChangedFile)
 
@@ -257,6 +402,197 @@ func TestAttempt(t *testing.T) {
 	}
 }
 
+func TestAttemptDuplicate(t *testing.T) {
+	for _, tc := range []struct {
+		action     string
+		previous   bool
+		wantRun    bool
+		wantDir    string
+		wantRecord bool
+	}{
+		{action: config.DuplicateActionSkip, wantRun: true, wantDir: "_/success", wantRecord: true},
+		{action: config.DuplicateActionSkip, previous: true, wantDir: "_/success"},
+		{action: config.DuplicateActionMove, previous: true, wantDir: "_/duplicates"},
+		{action: config.DuplicateActionRun, previous: true, wantRun: true, wantDir: "_/success"},
+	} {
+		t.Run(fmt.Sprintf("%s previous=%t", tc.action, tc.previous), func(t *testing.T) {
+			cfg := config.HandlerDefaults
+			cfg.Path = t.TempDir()
+			cfg.Command = []string{"placeholder"}
+			cfg.DuplicateAction = tc.action
+
+			j := journal.New(&cfg)
+			changedFile := testutil.MustWriteFile(t, filepath.Join(cfg.Path, "test.txt"), "content")
+
+			checksum, err := waryio.ChecksumFile(changedFile, sha256.New())
+			if err != nil {
+				t.Fatalf("ChecksumFile() failed: %v", err)
+			}
+
+			if tc.previous {
+				if err := j.RecordOutcome(checksum, "/previous", true); err != nil {
+					t.Errorf("RecordOutcome() failed: %v", err)
+				}
+			}
+
+			h, err := New(Options{
+				Logger:       zaptest.NewLogger(t),
+				Config:       &cfg,
+				Journal:      j,
+				ChangedFile:  changedFile,
+				BaseDir:      t.TempDir(),
+				JournalEntry: "/current",
+			})
+			if err != nil {
+				t.Fatalf("New() failed: %v", err)
+			}
+
+			h.prepare = func() error {
+				return nil
+			}
+			h.inputChecksum = func() []byte {
+				return checksum
+			}
+
+			ran := false
+
+			h.run = func(context.Context) error {
+				ran = true
+				return nil
+			}
+
+			if _, err := h.Run(context.Background()); err != nil {
+				t.Errorf("Run() failed: %v", err)
+			}
+
+			if diff := cmp.Diff(tc.wantRun, ran); diff != "" {
+				t.Errorf("Command invocation diff (-want +got):\n%s", diff)
+			}
+
+			testutil.MustNotExist(t, changedFile)
+
+			if entries, err := os.ReadDir(filepath.Join(cfg.Path, tc.wantDir)); err != nil {
+				t.Errorf("ReadDir() failed: %v", err)
+			} else if len(entries) != 1 {
+				t.Errorf("Destination has %d entries, want 1", len(entries))
+			}
+
+			want := "/previous"
+
+			if tc.wantRecord {
+				want = "/current"
+			}
+
+			if entry, ok, err := j.LookupDuplicate(checksum); err != nil {
+				t.Errorf("LookupDuplicate() failed: %v", err)
+			} else if !ok {
+				t.Errorf("LookupDuplicate() found no entry")
+			} else if diff := cmp.Diff(want, entry.Journal); diff != "" {
+				t.Errorf("Journal entry diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAttemptStdin(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		result        *handlercommand.Result
+		archiveFails  bool
+		wantErr       error
+		wantPermanent bool
+		wantOutput    []string
+	}{
+		{
+			name:       "success",
+			wantOutput: []string{"test.txt.out"},
+		},
+		{
+			name: "result requests retry",
+			result: &handlercommand.Result{
+				Outcome: handlercommand.ResultRetry,
+			},
+			wantErr: ErrRetryRequested,
+		},
+		{
+			name: "result skips file",
+			result: &handlercommand.Result{
+				Outcome: handlercommand.ResultSkip,
+			},
+		},
+		{
+			name:         "archiving fails",
+			archiveFails: true,
+			wantErr:      cmpopts.AnyError,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.HandlerDefaults
+			cfg.Path = t.TempDir()
+			cfg.Command = []string{"placeholder"}
+			cfg.Stdin = config.Stdin{
+				Enabled:      true,
+				OutputDir:    "_/out",
+				OutputSuffix: ".out",
+			}
+
+			baseDir := t.TempDir()
+
+			if tc.archiveFails {
+				// A file in place of the success directory.
+				testutil.MustMkdir(t, filepath.Join(cfg.Path, "_"))
+				testutil.MustWriteFile(t, filepath.Join(cfg.Path, cfg.SuccessDir), "")
+			}
+
+			h, err := New(Options{
+				Logger:      zaptest.NewLogger(t),
+				Config:      &cfg,
+				Journal:     journal.New(&cfg),
+				ChangedFile: testutil.MustWriteFile(t, filepath.Join(cfg.Path, "test.txt"), "content"),
+				BaseDir:     baseDir,
+			})
+			if err != nil {
+				t.Fatalf("New() failed: %v", err)
+			}
+
+			h.prepare = func() error {
+				return nil
+			}
+			h.run = func(context.Context) error {
+				testutil.MustWriteFile(t, filepath.Join(baseDir, "filter_output"), "filtered")
+				return nil
+			}
+			h.result = func() (*handlercommand.Result, error) {
+				return tc.result, nil
+			}
+
+			permanent, err := h.Run(context.Background())
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("Error diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.wantPermanent, permanent); diff != "" {
+				t.Errorf("Permanent error diff (-want +got):\n%s", diff)
+			}
+
+			var got []string
+
+			if entries, err := os.ReadDir(filepath.Join(cfg.Path, "_", "out")); err == nil {
+				for _, i := range entries {
+					got = append(got, i.Name())
+				}
+			} else if !os.IsNotExist(err) {
+				t.Error(err)
+			}
+
+			if diff := cmp.Diff(tc.wantOutput, got); diff != "" {
+				t.Errorf("Delivered output diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestNewNoCommand(t *testing.T) {
 	_, err := New(Options{
 		Config:      &config.HandlerDefaults,
@@ -2,16 +2,25 @@ package handlercommand
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"hash"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"syscall"
 	"time"
 
+	"github.com/hansmi/baamhackl/internal/cgroup"
+	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/eventbus"
 	"github.com/hansmi/baamhackl/internal/exepath"
+	"github.com/hansmi/baamhackl/internal/tracing"
 	"github.com/hansmi/baamhackl/internal/waryio"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/multierr"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -31,9 +40,11 @@ func createDirectories(paths []string) error {
 	return result
 }
 
-func copyInputFile(source, dest string) error {
+func copyInputFile(source, dest string, h hash.Hash) error {
 	var opts waryio.CopyOptions = waryio.DefaultCopyOptions
 
+	opts.Hash = h
+
 	opts.SourcePath = source
 	opts.SourceFlags |= syscall.O_NOFOLLOW
 
@@ -43,10 +54,46 @@ func copyInputFile(source, dest string) error {
 	return waryio.Copy(opts)
 }
 
-func runCommand(ctx context.Context, logger *zap.Logger, m MetricsReporter, cmd *exec.Cmd) error {
+// terminationSignal returns the signal which ended the command. A signal sent
+// by Baamhackl takes precedence as wrappers such as shells often exit with
+// a non-zero code instead of being terminated by the signal.
+func terminationSignal(ps *os.ProcessState, sent syscall.Signal) syscall.Signal {
+	if sent != 0 {
+		return sent
+	}
+
+	if ps != nil {
+		if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return ws.Signal()
+		}
+	}
+
+	return 0
+}
+
+// runCommand starts the command in its own process group and waits for it to
+// finish. On cancellation of the context the whole group is terminated,
+// allowing for a grace period between SIGTERM and SIGKILL.
+func runCommand(ctx context.Context, logger *zap.Logger, m MetricsReporter, events eventbus.Emitter, cmd *exec.Cmd, killGrace time.Duration) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+
+	cmd.SysProcAttr.Setpgid = true
+
 	start := time.Now()
 
-	err := cmd.Run()
+	var sent syscall.Signal
+
+	// Don't start the command at all when the context is done already.
+	err := ctx.Err()
+	if err == nil {
+		err = cmd.Start()
+	}
+
+	if err == nil {
+		sent, err = waitProcessGroup(ctx, logger, cmd, killGrace)
+	}
 
 	wallTime := time.Since(start)
 
@@ -55,6 +102,12 @@ func runCommand(ctx context.Context, logger *zap.Logger, m MetricsReporter, cmd
 		zap.Duration("wall_time", wallTime),
 	}
 
+	sig := terminationSignal(cmd.ProcessState, sent)
+
+	if sig != 0 {
+		logValues = append(logValues, zap.Stringer("signal", sig))
+	}
+
 	// ProcessState may be nil if starting the command failed, e.g. due to the
 	// executable not being found.
 	if ps := cmd.ProcessState; ps != nil {
@@ -67,10 +120,30 @@ func runCommand(ctx context.Context, logger *zap.Logger, m MetricsReporter, cmd
 		if m != nil {
 			m.ReportProcessState(ps.ExitCode(), wallTime, ps.UserTime(), ps.SystemTime())
 		}
+
+		trace.SpanFromContext(ctx).SetAttributes(tracing.ExitCodeKey.Int(ps.ExitCode()))
 	}
 
 	logger.Info("Command exited", logValues...)
 
+	exited := eventbus.Event{
+		Type: eventbus.CommandExited,
+	}
+
+	if ps := cmd.ProcessState; ps != nil {
+		exited.ExitCode = eventbus.Int(ps.ExitCode())
+	}
+
+	if sig != 0 {
+		exited.Signal = sig.String()
+	}
+
+	if err != nil {
+		exited.Error = err.Error()
+	}
+
+	events.Emit(exited)
+
 	if err != nil {
 		select {
 		case <-ctx.Done():
@@ -86,6 +159,7 @@ func runCommand(ctx context.Context, logger *zap.Logger, m MetricsReporter, cmd
 
 type MetricsReporter interface {
 	ReportProcessState(exitCode int, wallTime, userTime, systemTime time.Duration)
+	ReportCgroupStats(memoryPeak, oomKills uint64)
 }
 
 type Options struct {
@@ -100,17 +174,81 @@ type Options struct {
 	// Command arguments.
 	Command []string
 
+	// Additional environment variables.
+	Env map[string]string
+
+	// Environment variables whose values are read from files before starting
+	// the command. Values are redacted in logs.
+	EnvFiles map[string]string
+
+	// Pass only variables named in EnvAllowlist from the environment of the
+	// current process.
+	ClearEnv     bool
+	EnvAllowlist []string
+
+	// Whether to compute a checksum of the changed file.
+	Checksum bool
+
+	// Information about the attempt written to a file for the command.
+	Metadata Metadata
+
+	// Capturing of the command output.
+	Output config.CommandOutput
+
+	// Run the command as a filter from standard input to standard output.
+	Stdin config.Stdin
+
+	// Handler directory against which relative output directories are
+	// resolved.
+	HandlerPath string
+
 	// Interface for reporting command-specific metrics.
 	Metrics MetricsReporter
+
+	// Destination for lifecycle events.
+	Events eventbus.Emitter
+
+	// Amount of time between SIGTERM and SIGKILL when terminating the
+	// command. Zero sends SIGKILL immediately.
+	KillGrace time.Duration
+
+	// Resource limits applied via a shim process.
+	Rlimits config.Rlimits
+
+	// Filesystem restrictions applied via a shim process.
+	Landlock config.Landlock
+
+	// Namespace isolation set up by a shim process.
+	Namespaces config.Namespaces
+
+	// Limits for a dedicated cgroup. Only applied when Cgroups is set.
+	Cgroup config.CgroupLimits
+
+	// Manager for per-command cgroups. Nil if cgroups aren't available.
+	Cgroups *cgroup.Manager
+
+	// User and groups for running the command. Nil to keep those of the
+	// current process.
+	Credential *syscall.Credential
 }
 
 type Command struct {
 	opts Options
-
-	inputDir   string
-	inputFile  string
-	workDir    string
-	outputFile string
+	exe  string
+
+	inputDir     string
+	inputFile    string
+	workDir      string
+	resultDir    string
+	resultFile   string
+	outputFile   string
+	stdoutFile   string
+	stderrFile   string
+	filterFile   string
+	metadataFile string
+
+	prepared      bool
+	inputChecksum []byte
 
 	environ []string
 }
@@ -127,63 +265,180 @@ func New(opts Options) (*Command, error) {
 	}
 
 	c := &Command{
-		opts:       opts,
-		inputDir:   filepath.Join(opts.BaseDir, "input"),
-		workDir:    filepath.Join(opts.BaseDir, "work"),
-		outputFile: filepath.Join(opts.BaseDir, "command_output.txt"),
+		opts:         opts,
+		exe:          exe,
+		inputDir:     filepath.Join(opts.BaseDir, "input"),
+		workDir:      filepath.Join(opts.BaseDir, "work"),
+		outputFile:   filepath.Join(opts.BaseDir, "command_output.txt"),
+		stdoutFile:   filepath.Join(opts.BaseDir, "command_stdout.txt"),
+		stderrFile:   filepath.Join(opts.BaseDir, "command_stderr.txt"),
+		filterFile:   filepath.Join(opts.BaseDir, "filter_output"),
+		metadataFile: filepath.Join(opts.BaseDir, "metadata.json"),
+		resultDir:    filepath.Join(opts.BaseDir, "result"),
 	}
 
 	c.inputFile = filepath.Join(c.inputDir, filepath.Base(c.opts.SourceFile))
+	c.resultFile = filepath.Join(c.resultDir, "result.json")
 	c.environ = []string{
 		"BAAMHACKL_PROGRAM=" + exe,
 		"BAAMHACKL_ORIGINAL=" + c.opts.SourceFile,
 		"BAAMHACKL_WORKDIR=" + c.workDir,
 		"BAAMHACKL_INPUT=" + c.inputFile,
+		"BAAMHACKL_METADATA=" + c.metadataFile,
+		"BAAMHACKL_RESULT=" + c.resultFile,
 	}
 
 	return c, nil
 }
 
+// Prepare creates the directories used by the command and copies the changed
+// file. It's invoked by Run if necessary.
+func (c *Command) Prepare() error {
+	if c.prepared {
+		return nil
+	}
+
+	if err := c.prepare(); err != nil {
+		return err
+	}
+
+	c.prepared = true
+
+	return nil
+}
+
 func (c *Command) prepare() error {
 	if err := createDirectories([]string{
 		c.inputDir,
 		c.workDir,
+		c.resultDir,
 	}); err != nil {
 		return fmt.Errorf("creating directories failed: %w", err)
 	}
 
-	if err := copyInputFile(c.opts.SourceFile, c.inputFile); err != nil {
+	var h hash.Hash
+
+	if c.opts.Checksum {
+		h = sha256.New()
+	}
+
+	if err := copyInputFile(c.opts.SourceFile, c.inputFile, h); err != nil {
 		return fmt.Errorf("copying changed file failed: %w", err)
 	}
 
+	if cred := c.opts.Credential; cred != nil {
+		for _, path := range []string{c.inputDir, c.inputFile, c.workDir, c.resultDir} {
+			if err := os.Lchown(path, int(cred.Uid), int(cred.Gid)); err != nil {
+				return fmt.Errorf("changing ownership failed: %w", err)
+			}
+		}
+	}
+
+	if h != nil {
+		c.inputChecksum = h.Sum(nil)
+		c.environ = append(c.environ, "BAAMHACKL_INPUT_SHA256="+hex.EncodeToString(c.inputChecksum))
+
+		c.opts.Logger.Info("Input checksum",
+			zap.String("sha256", hex.EncodeToString(c.inputChecksum)))
+	}
+
 	return nil
 }
 
+// InputChecksum returns the SHA-256 checksum of the changed file computed
+// while making the copy. Nil is returned if computing a checksum wasn't
+// requested or the copy failed.
+func (c *Command) InputChecksum() []byte {
+	return c.inputChecksum
+}
+
 func (c *Command) Run(ctx context.Context) (err error) {
 	logger := c.opts.Logger
 
-	if err := c.prepare(); err != nil {
+	ctx, span := tracing.Start(ctx, "handlercommand.Run",
+		attribute.StringSlice("process.command_args", c.opts.Command),
+	)
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	if err := c.Prepare(); err != nil {
 		return err
 	}
 
-	outputHandle, err := os.OpenFile(c.outputFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND|os.O_EXCL, 0o666)
+	if err := c.writeMetadata(ctx); err != nil {
+		return fmt.Errorf("writing metadata failed: %w", err)
+	}
+
+	env, loggedEnv, err := c.configuredEnviron()
 	if err != nil {
-		return fmt.Errorf("opening output file failed: %w", err)
+		return err
 	}
 
-	defer multierr.AppendInvoke(&err, multierr.Close(outputHandle))
+	output, err := c.openOutput()
+	if err != nil {
+		return err
+	}
+
+	defer multierr.AppendInvoke(&err, multierr.Close(output))
+
+	args, err := c.wrapCommand()
+	if err != nil {
+		return err
+	}
 
-	cmd := exec.CommandContext(ctx, c.opts.Command[0], c.opts.Command[1:]...)
+	cmd := exec.Command(args[0], args[1:]...)
 	cmd.Stdin = nil
-	cmd.Stdout = outputHandle
-	cmd.Stderr = outputHandle
+	cmd.Stdout = output.stdout
+	cmd.Stderr = output.stderr
 	cmd.Dir = c.workDir
-	cmd.Env = append(append([]string(nil), os.Environ()...), c.environ...)
+
+	if output.piped() {
+		cmd.WaitDelay = outputWaitDelay
+	}
+
+	if c.opts.Stdin.Enabled {
+		var stdin *os.File
+
+		if stdin, err = os.Open(c.inputFile); err != nil {
+			return fmt.Errorf("opening input file failed: %w", err)
+		}
+
+		defer multierr.AppendInvoke(&err, multierr.Close(stdin))
+
+		cmd.Stdin = stdin
+	}
+
+	if c.opts.Credential != nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Credential: c.opts.Credential,
+		}
+	}
+
+	if ns := c.namespace(); ns != nil {
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+
+		ns.SetupProcAttr(cmd.SysProcAttr)
+	}
+	cmd.Env = append(append(c.inheritedEnviron(), env...), c.environ...)
+
+	// Later entries take precedence over inherited variables.
+	cmd.Env = append(cmd.Env, tracing.Environ(ctx)...)
 
 	logValues := []zapcore.Field{
 		zap.String("dir", cmd.Dir),
-		zap.Strings("env", c.environ),
-		zap.Strings("args", cmd.Args),
+		zap.Strings("env", append(loggedEnv, c.environ...)),
+		zap.Strings("args", c.opts.Command),
+	}
+
+	if !c.opts.Rlimits.Empty() {
+		logValues = append(logValues, zap.Reflect("rlimits", c.opts.Rlimits))
+	}
+
+	if c.opts.Namespaces.Enabled {
+		logValues = append(logValues, zap.Reflect("namespaces", c.opts.Namespaces))
 	}
 
 	if deadline, ok := ctx.Deadline(); ok {
@@ -193,7 +448,32 @@ func (c *Command) Run(ctx context.Context) (err error) {
 		)
 	}
 
+	if cred := c.opts.Credential; cred != nil {
+		logValues = append(logValues,
+			zap.Uint32("uid", cred.Uid),
+			zap.Uint32("gid", cred.Gid),
+			zap.Uint32s("groups", cred.Groups),
+		)
+	}
+
 	logger.Info("Run handler command", logValues...)
 
-	return runCommand(ctx, logger, c.opts.Metrics, cmd)
+	finishCgroup, err := c.setupCgroup(cmd)
+	if err != nil {
+		return err
+	}
+
+	defer finishCgroup()
+
+	if err := runCommand(ctx, logger, c.opts.Metrics, c.opts.Events, cmd, c.opts.KillGrace); err != nil {
+		return err
+	}
+
+	if output.filter != nil {
+		if err := output.filter.Sync(); err != nil {
+			return fmt.Errorf("syncing filter output failed: %w", err)
+		}
+	}
+
+	return nil
 }
@@ -2,12 +2,20 @@ package handlercommand
 
 import (
 	"context"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
@@ -15,9 +23,16 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/hansmi/baamhackl/internal/cmdemu"
+	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/eventbus"
+	"github.com/hansmi/baamhackl/internal/execshim"
 	"github.com/hansmi/baamhackl/internal/exepath"
 	"github.com/hansmi/baamhackl/internal/ref"
 	"github.com/hansmi/baamhackl/internal/testutil"
+	"github.com/hansmi/baamhackl/internal/uniquename"
+	"github.com/jonboulle/clockwork"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"go.uber.org/zap/zaptest/observer"
@@ -40,6 +55,97 @@ var fakeCommand = cmdemu.Command{
 
 			case "fatal-signal":
 				return testutil.Raise(syscall.SIGKILL)
+
+			case "print-traceparent":
+				fmt.Print(os.Getenv("TRACEPARENT"))
+				return nil
+
+			case "print-metadata":
+				content, err := os.ReadFile(os.Getenv("BAAMHACKL_METADATA"))
+				if err != nil {
+					return err
+				}
+
+				_, err = os.Stdout.Write(content)
+				return err
+
+			case "write-result":
+				return os.WriteFile(os.Getenv("BAAMHACKL_RESULT"),
+					[]byte(`{"outcome": "retry", "retry_delay": "90s", "outputs": ["out.txt"]}`), 0o644)
+
+			case "print-streams":
+				for i := range 10 {
+					fmt.Printf("stdout %d\n", i)
+				}
+				fmt.Fprint(os.Stderr, "stderr\npartial")
+				return nil
+
+			case "filter", "filter-fail":
+				content, err := io.ReadAll(os.Stdin)
+				if err != nil {
+					return err
+				}
+
+				fmt.Print(strings.ToUpper(string(content)))
+				fmt.Fprintln(os.Stderr, "filtering")
+
+				if fs.Arg(0) == "filter-fail" {
+					return cmdemu.ExitCodeError(1)
+				}
+
+				return nil
+
+			case "print-test-env":
+				for _, i := range os.Environ() {
+					if strings.HasPrefix(i, "TEST_") {
+						fmt.Println(i)
+					}
+				}
+				return nil
+
+			case "print-nofile":
+				var rlim syscall.Rlimit
+
+				if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rlim); err != nil {
+					return err
+				}
+
+				fmt.Print(rlim.Cur)
+				return nil
+
+			case "sleep":
+				return sleepUntilKilled()
+
+			case "ignore-sigterm":
+				signal.Ignore(syscall.SIGTERM)
+				return sleepUntilKilled()
+
+			case "spawn-orphan":
+				// The child ignores SIGTERM and outlives the group leader.
+				child := exec.Command(exepath.MustGet(), "-emulate", "handler", "--", "ignore-sigterm")
+				child.Dir = "orphan"
+
+				if err := os.Mkdir(child.Dir, 0o755); err != nil {
+					return err
+				}
+
+				if err := child.Start(); err != nil {
+					return err
+				}
+
+				if err := os.WriteFile("orphan.pid", []byte(strconv.Itoa(child.Process.Pid)), 0o644); err != nil {
+					return err
+				}
+
+				for {
+					if _, err := os.Stat(filepath.Join(child.Dir, "ready")); err == nil {
+						break
+					}
+
+					time.Sleep(10 * time.Millisecond)
+				}
+
+				return sleepUntilKilled()
 			}
 		}
 
@@ -47,9 +153,40 @@ var fakeCommand = cmdemu.Command{
 	},
 }
 
+// sleepUntilKilled signals readiness by creating a file named "ready" in the
+// working directory and then sleeps.
+func sleepUntilKilled() error {
+	if err := os.WriteFile("ready", nil, 0o644); err != nil {
+		return err
+	}
+
+	time.Sleep(time.Hour)
+
+	return errors.New("not killed")
+}
+
+var fakeShim = cmdemu.Command{
+	Name: "shim",
+	Execute: func(args []string) error {
+		fs := flag.NewFlagSet("", flag.ContinueOnError)
+		spec := fs.String("spec", "{}", "")
+
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+
+		return execshim.Run(*spec, fs.Args())
+	},
+}
+
 func TestMain(m *testing.M) {
+	shimCommand = func() ([]string, error) {
+		return fakeShim.MakeArgs(), nil
+	}
+
 	w := cmdemu.New(flag.CommandLine)
 	w.Register(fakeCommand)
+	w.Register(fakeShim)
 	os.Exit(w.Main(m))
 }
 
@@ -67,6 +204,9 @@ func (m *fakeMetrics) ReportProcessState(exitCode int, _, _, _ time.Duration) {
 	m.Count++
 }
 
+func (m *fakeMetrics) ReportCgroupStats(uint64, uint64) {
+}
+
 func TestCreateDirectories(t *testing.T) {
 	for _, tc := range []struct {
 		paths   []string
@@ -126,13 +266,15 @@ func TestRunCommand(t *testing.T) {
 			loggerCore, observed := observer.New(zapcore.DebugLevel)
 			logger := zap.New(loggerCore)
 
-			cmd := exec.CommandContext(ctx, tc.args[0], tc.args[1:]...)
+			cmd := exec.Command(tc.args[0], tc.args[1:]...)
 			cmd.Stdin = nil
 			cmd.Stdout = nil
 			cmd.Stderr = nil
 			cmd.Dir = t.TempDir()
 
-			err := runCommand(ctx, logger, nil, cmd)
+			bus := eventbus.New(0)
+
+			err := runCommand(ctx, logger, nil, eventbus.Emitter{Bus: bus}, cmd, time.Second)
 
 			var exitErr *exec.ExitError
 
@@ -150,6 +292,134 @@ func TestRunCommand(t *testing.T) {
 					t.Errorf("Logged exit code diff (-want +got):\n%s", diff)
 				}
 			}
+
+			if events, _, err := bus.Wait(ctx, 0, nil); err != nil {
+				t.Errorf("Wait() failed: %v", err)
+			} else if len(events) != 1 || events[0].Type != eventbus.CommandExited {
+				t.Errorf("Expected exactly one event about the command exiting: %+v", events)
+			} else if diff := cmp.Diff(&tc.wantCode, events[0].ExitCode); diff != "" {
+				t.Errorf("Event exit code diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// processGone reports whether a process has exited. Zombies count as exited
+// as nothing may be reaping orphans in containers.
+func processGone(pid int) bool {
+	content, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return os.IsNotExist(err)
+	}
+
+	if idx := strings.LastIndexByte(string(content), ')'); idx >= 0 {
+		if fields := strings.Fields(string(content[idx+1:])); len(fields) > 0 {
+			return fields[0] == "Z"
+		}
+	}
+
+	return false
+}
+
+func TestRunCommandTermination(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		mode       string
+		grace      time.Duration
+		readyFile  string
+		wantSignal syscall.Signal
+	}{
+		{
+			name:       "sigterm",
+			mode:       "sleep",
+			grace:      time.Minute,
+			readyFile:  "ready",
+			wantSignal: syscall.SIGTERM,
+		},
+		{
+			name:       "sigkill after grace",
+			mode:       "ignore-sigterm",
+			grace:      100 * time.Millisecond,
+			readyFile:  "ready",
+			wantSignal: syscall.SIGKILL,
+		},
+		{
+			name:       "no grace",
+			mode:       "sleep",
+			readyFile:  "ready",
+			wantSignal: syscall.SIGKILL,
+		},
+		{
+			name:       "orphaned child",
+			mode:       "spawn-orphan",
+			grace:      100 * time.Millisecond,
+			readyFile:  "orphan.pid",
+			wantSignal: syscall.SIGKILL,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			t.Cleanup(cancel)
+
+			loggerCore, observed := observer.New(zapcore.DebugLevel)
+
+			args := fakeCommand.MakeArgs(tc.mode)
+
+			cmd := exec.Command(args[0], args[1:]...)
+			cmd.Dir = t.TempDir()
+
+			go func() {
+				for {
+					if _, err := os.Stat(filepath.Join(cmd.Dir, tc.readyFile)); err == nil {
+						cancel()
+						return
+					}
+
+					select {
+					case <-ctx.Done():
+						return
+					case <-time.After(10 * time.Millisecond):
+					}
+				}
+			}()
+
+			bus := eventbus.New(0)
+
+			err := runCommand(ctx, zap.New(loggerCore), nil, eventbus.Emitter{Bus: bus}, cmd, tc.grace)
+
+			if !errors.Is(err, context.Canceled) {
+				t.Errorf("runCommand() returned %v, want cancellation", err)
+			}
+
+			if logs := observed.FilterMessage("Command exited").All(); len(logs) != 1 {
+				t.Errorf("Expected exactly one log message about the command exiting: %+v", observed.All())
+			} else if diff := cmp.Diff(tc.wantSignal.String(), logs[0].ContextMap()["signal"]); diff != "" {
+				t.Errorf("Logged signal diff (-want +got):\n%s", diff)
+			}
+
+			if events, _, err := bus.Wait(context.Background(), 0, nil); err != nil {
+				t.Errorf("Wait() failed: %v", err)
+			} else if diff := cmp.Diff(tc.wantSignal.String(), events[0].Signal); diff != "" {
+				t.Errorf("Event signal diff (-want +got):\n%s", diff)
+			}
+
+			if content, err := os.ReadFile(filepath.Join(cmd.Dir, "orphan.pid")); err == nil {
+				pid, err := strconv.Atoi(string(content))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				deadline := time.Now().Add(10 * time.Second)
+
+				for !processGone(pid) {
+					if time.Now().After(deadline) {
+						syscall.Kill(pid, syscall.SIGKILL)
+						t.Fatalf("Orphaned child %d is still running", pid)
+					}
+
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
 		})
 	}
 }
@@ -189,6 +459,8 @@ func TestNew(t *testing.T) {
 					"BAAMHACKL_ORIGINAL=" + tc.opts.SourceFile,
 					"BAAMHACKL_INPUT=" + filepath.Join(tc.opts.BaseDir, "input", tc.sourceName),
 					"BAAMHACKL_WORKDIR=" + filepath.Join(tc.opts.BaseDir, "work"),
+					"BAAMHACKL_METADATA=" + filepath.Join(tc.opts.BaseDir, "metadata.json"),
+					"BAAMHACKL_RESULT=" + filepath.Join(tc.opts.BaseDir, "result", "result.json"),
 				}
 
 				if diff := cmp.Diff(wantEnv, c.environ, cmpopts.SortSlices(func(a, b string) bool {
@@ -201,6 +473,37 @@ func TestNew(t *testing.T) {
 	}
 }
 
+func TestRunChecksum(t *testing.T) {
+	c, err := New(Options{
+		Logger:     zap.NewNop(),
+		SourceFile: testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "src"), "hello\n"),
+		BaseDir:    t.TempDir(),
+		Command:    fakeCommand.MakeArgs("success"),
+		Checksum:   true,
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	if got := c.InputChecksum(); got != nil {
+		t.Errorf("InputChecksum() before Run() returned %x, want nil", got)
+	}
+
+	if err := c.Run(context.Background()); err != nil {
+		t.Errorf("Run() failed: %v", err)
+	}
+
+	const want = "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+
+	if diff := cmp.Diff(want, hex.EncodeToString(c.InputChecksum())); diff != "" {
+		t.Errorf("InputChecksum() diff (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff("BAAMHACKL_INPUT_SHA256="+want, c.environ[len(c.environ)-1]); diff != "" {
+		t.Errorf("Environment diff (-want +got):\n%s", diff)
+	}
+}
+
 func TestRun(t *testing.T) {
 	for _, tc := range []struct {
 		name        string
@@ -327,3 +630,795 @@ func TestRun(t *testing.T) {
 		})
 	}
 }
+
+func TestRunTraceparent(t *testing.T) {
+	prev := otel.GetTracerProvider()
+	tp := sdktrace.NewTracerProvider()
+	otel.SetTracerProvider(tp)
+
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prev)
+		tp.Shutdown(context.Background())
+	})
+
+	ctx, span := tp.Tracer("test").Start(context.Background(), "test")
+	defer span.End()
+
+	baseDir := t.TempDir()
+
+	c, err := New(Options{
+		Logger:     zap.NewNop(),
+		SourceFile: testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "src"), "content"),
+		BaseDir:    baseDir,
+		Command:    fakeCommand.MakeArgs("print-traceparent"),
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	if err := c.Run(ctx); err != nil {
+		t.Errorf("Run() failed: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(baseDir, "command_output.txt"))
+	if err != nil {
+		t.Errorf("ReadFile() failed: %v", err)
+	}
+
+	// The command span is a child of the test span.
+	sc := span.SpanContext()
+	prefix := fmt.Sprintf("00-%s-", sc.TraceID())
+
+	if !strings.HasPrefix(string(got), prefix) || strings.Contains(string(got), sc.SpanID().String()) {
+		t.Errorf("TRACEPARENT is %q, want child of trace %s", got, sc.TraceID())
+	}
+}
+
+func TestRunRlimits(t *testing.T) {
+	baseDir := t.TempDir()
+
+	c, err := New(Options{
+		Logger:     zap.NewNop(),
+		SourceFile: testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "src"), "content"),
+		BaseDir:    baseDir,
+		Command:    fakeCommand.MakeArgs("print-nofile"),
+		Rlimits: config.Rlimits{
+			OpenFiles: ref.Ref[uint64](64),
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	if err := c.Run(context.Background()); err != nil {
+		t.Errorf("Run() failed: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(baseDir, "command_output.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff("64", string(content)); diff != "" {
+		t.Errorf("Open file limit diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestPrepareCredential(t *testing.T) {
+	// Only privileged processes can give away files.
+	uid, gid := os.Getuid(), os.Getgid()
+
+	if uid == 0 {
+		uid, gid = 65534, 65534
+	}
+
+	baseDir := t.TempDir()
+
+	c, err := New(Options{
+		Logger:     zap.NewNop(),
+		SourceFile: testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "src"), "content"),
+		BaseDir:    baseDir,
+		Command:    fakeCommand.MakeArgs("success"),
+		Credential: &syscall.Credential{
+			Uid: uint32(uid),
+			Gid: uint32(gid),
+		},
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	if err := c.Prepare(); err != nil {
+		t.Fatalf("Prepare() failed: %v", err)
+	}
+
+	for _, path := range []string{c.inputDir, c.inputFile, c.workDir, c.resultDir} {
+		st := testutil.MustLstat(t, path).Sys().(*syscall.Stat_t)
+
+		if int(st.Uid) != uid || int(st.Gid) != gid {
+			t.Errorf("Ownership of %q is %d:%d, want %d:%d", path, st.Uid, st.Gid, uid, gid)
+		}
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		cfg  config.Namespaces
+		want func(*Command) *execshim.Namespace
+	}{
+		{
+			name: "disabled",
+			cfg: config.Namespaces{
+				ReadOnly: []string{"/usr"},
+			},
+			want: func(*Command) *execshim.Namespace { return nil },
+		},
+		{
+			name: "enabled",
+			cfg: config.Namespaces{
+				Enabled:   true,
+				ReadOnly:  []string{"/usr"},
+				ReadWrite: []string{"/srv/output"},
+			},
+			want: func(c *Command) *execshim.Namespace {
+				return &execshim.Namespace{
+					ReadOnly:       []string{"/usr", exepath.MustGet(), c.inputDir, c.metadataFile},
+					ReadWrite:      []string{"/srv/output", c.workDir, c.resultDir},
+					PrivateNetwork: true,
+				}
+			},
+		},
+		{
+			name: "network",
+			cfg: config.Namespaces{
+				Enabled: true,
+				Network: true,
+			},
+			want: func(c *Command) *execshim.Namespace {
+				return &execshim.Namespace{
+					ReadOnly:  []string{exepath.MustGet(), c.inputDir, c.metadataFile},
+					ReadWrite: []string{c.workDir, c.resultDir},
+				}
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := New(Options{
+				Logger:     zap.NewNop(),
+				SourceFile: "/path/to/src",
+				BaseDir:    t.TempDir(),
+				Command:    []string{"/bin/true"},
+				Namespaces: tc.cfg,
+			})
+			if err != nil {
+				t.Fatalf("New() failed: %v", err)
+			}
+
+			if diff := cmp.Diff(tc.want(c), c.namespace()); diff != "" {
+				t.Errorf("namespace() diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRunEnvironment(t *testing.T) {
+	t.Setenv("TEST_INHERITED", "inherited")
+	t.Setenv("TEST_OTHER", "other")
+
+	secretFile := testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "secret"), "s3cret\n")
+
+	for _, tc := range []struct {
+		name    string
+		opts    Options
+		want    []string
+		wantErr error
+	}{
+		{
+			name: "inherited",
+			want: []string{"TEST_INHERITED=inherited", "TEST_OTHER=other"},
+		},
+		{
+			name: "configured",
+			opts: Options{
+				Env: map[string]string{
+					"TEST_STATIC": "value",
+					"TEST_OTHER":  "override",
+				},
+				EnvFiles: map[string]string{
+					"TEST_SECRET": secretFile,
+				},
+			},
+			want: []string{
+				"TEST_INHERITED=inherited",
+				"TEST_OTHER=override",
+				"TEST_SECRET=s3cret",
+				"TEST_STATIC=value",
+			},
+		},
+		{
+			name: "clear",
+			opts: Options{
+				ClearEnv:     true,
+				EnvAllowlist: []string{"TEST_INHERITED"},
+				Env: map[string]string{
+					"TEST_STATIC": "value",
+				},
+			},
+			want: []string{"TEST_INHERITED=inherited", "TEST_STATIC=value"},
+		},
+		{
+			name: "missing file",
+			opts: Options{
+				EnvFiles: map[string]string{
+					"TEST_SECRET": filepath.Join(t.TempDir(), "missing"),
+				},
+			},
+			wantErr: os.ErrNotExist,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			loggerCore, observed := observer.New(zapcore.DebugLevel)
+
+			baseDir := t.TempDir()
+
+			opts := tc.opts
+			opts.Logger = zap.New(loggerCore)
+			opts.SourceFile = testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "src"), "content")
+			opts.BaseDir = baseDir
+			opts.Command = fakeCommand.MakeArgs("print-test-env")
+
+			c, err := New(opts)
+			if err != nil {
+				t.Fatalf("New() failed: %v", err)
+			}
+
+			err = c.Run(context.Background())
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Fatalf("Run() error diff (-want +got):\n%s", diff)
+			}
+
+			if err != nil {
+				return
+			}
+
+			content, err := os.ReadFile(filepath.Join(baseDir, "command_output.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := strings.Fields(string(content))
+			slices.Sort(got)
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Environment diff (-want +got):\n%s", diff)
+			}
+
+			for _, entry := range observed.All() {
+				if logged := fmt.Sprint(entry.ContextMap()); strings.Contains(logged, "s3cret") {
+					t.Errorf("Secret value found in log entry %q: %s", entry.Message, logged)
+				}
+			}
+		})
+	}
+}
+
+func TestRunMetadata(t *testing.T) {
+	baseDir := t.TempDir()
+	deadline := time.Date(2030, time.January, 2, 3, 4, 5, 0, time.UTC)
+
+	metadata := Metadata{
+		Handler:        "handler",
+		Name:           "sub/file.txt",
+		Size:           123,
+		MTime:          time.Date(2020, time.March, 1, 2, 3, 4, 0, time.UTC),
+		Attempt:        2,
+		Final:          true,
+		PreviousErrors: []string{"first", "second"},
+		JournalDir:     "/path/to/journal",
+	}
+
+	c, err := New(Options{
+		Logger:     zap.NewNop(),
+		SourceFile: testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "src"), "content"),
+		BaseDir:    baseDir,
+		Command:    fakeCommand.MakeArgs("print-metadata"),
+		Metadata:   metadata,
+	})
+	if err != nil {
+		t.Fatalf("New() failed: %v", err)
+	}
+
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	if err := c.Run(ctx); err != nil {
+		t.Errorf("Run() failed: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(baseDir, "command_output.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got Metadata
+
+	if err := json.Unmarshal(content, &got); err != nil {
+		t.Fatalf("Unmarshal() failed: %v", err)
+	}
+
+	want := metadata
+	want.Deadline = &deadline
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Metadata diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestRunResult(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		args []string
+		want *Result
+	}{
+		{
+			name: "no result",
+			args: []string{"success"},
+		},
+		{
+			name: "retry",
+			args: []string{"write-result"},
+			want: &Result{
+				Outcome:    ResultRetry,
+				RetryDelay: 90 * time.Second,
+				Outputs:    []string{"out.txt"},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := New(Options{
+				Logger:     zap.NewNop(),
+				SourceFile: testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "src"), "content"),
+				BaseDir:    t.TempDir(),
+				Command:    fakeCommand.MakeArgs(tc.args...),
+			})
+			if err != nil {
+				t.Fatalf("New() failed: %v", err)
+			}
+
+			if err := c.Run(context.Background()); err != nil {
+				t.Errorf("Run() failed: %v", err)
+			}
+
+			got, err := c.Result()
+			if err != nil {
+				t.Errorf("Result() failed: %v", err)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Result diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestResultFile(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		setup   func(t *testing.T, path string)
+		want    *Result
+		wantErr error
+	}{
+		{
+			name: "regular file",
+			setup: func(t *testing.T, path string) {
+				testutil.MustWriteFile(t, path, `{"outcome": "skip"}`)
+			},
+			want: &Result{Outcome: ResultSkip},
+		},
+		{
+			name: "symlink",
+			setup: func(t *testing.T, path string) {
+				target := testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "target"), `{"outcome": "skip"}`)
+
+				if err := os.Symlink(target, path); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: syscall.ELOOP,
+		},
+		{
+			name: "fifo",
+			setup: func(t *testing.T, path string) {
+				if err := syscall.Mkfifo(path, 0o600); err != nil {
+					t.Fatal(err)
+				}
+			},
+			wantErr: os.ErrInvalid,
+		},
+		{
+			name: "oversized",
+			setup: func(t *testing.T, path string) {
+				testutil.MustWriteFile(t, path, `{"outcome": "skip"}`+strings.Repeat(" ", maxResultSize))
+			},
+			wantErr: os.ErrInvalid,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c, err := New(Options{
+				Logger:     zap.NewNop(),
+				SourceFile: testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "src"), "content"),
+				BaseDir:    t.TempDir(),
+				Command:    []string{"placeholder"},
+			})
+			if err != nil {
+				t.Fatalf("New() failed: %v", err)
+			}
+
+			testutil.MustMkdir(t, filepath.Dir(c.resultFile))
+
+			tc.setup(t, c.resultFile)
+
+			got, err := c.Result()
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("Result() error diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Result diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseResult(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		input   string
+		want    *Result
+		wantErr *regexp.Regexp
+	}{
+		{
+			name:  "success",
+			input: `{"outcome": "success", "message": "done", "annotations": {"pages": 3}}`,
+			want: &Result{
+				Outcome:     ResultSuccess,
+				Message:     "done",
+				Annotations: map[string]any{"pages": 3.0},
+			},
+		},
+		{
+			name:  "retry without delay",
+			input: `{"outcome": "retry"}`,
+			want:  &Result{Outcome: ResultRetry},
+		},
+		{
+			name:  "retry with delay",
+			input: `{"outcome": "retry", "retry_delay": "1h30m"}`,
+			want: &Result{
+				Outcome:    ResultRetry,
+				RetryDelay: 90 * time.Minute,
+			},
+		},
+		{
+			name:  "permanent failure",
+			input: `{"outcome": "permanent_failure", "message": "corrupt"}`,
+			want: &Result{
+				Outcome: ResultPermanentFailure,
+				Message: "corrupt",
+			},
+		},
+		{
+			name:  "skip",
+			input: `{"outcome": "skip"}`,
+			want:  &Result{Outcome: ResultSkip},
+		},
+		{
+			name:    "invalid JSON",
+			input:   `{`,
+			wantErr: regexp.MustCompile(`unexpected end`),
+		},
+		{
+			name:    "missing outcome",
+			input:   `{}`,
+			wantErr: regexp.MustCompile(`unknown outcome ""`),
+		},
+		{
+			name:    "unknown outcome",
+			input:   `{"outcome": "maybe"}`,
+			wantErr: regexp.MustCompile(`unknown outcome "maybe"`),
+		},
+		{
+			name:    "delay without retry",
+			input:   `{"outcome": "success", "retry_delay": "1m"}`,
+			wantErr: regexp.MustCompile(`only supported with outcome "retry"`),
+		},
+		{
+			name:    "invalid delay",
+			input:   `{"outcome": "retry", "retry_delay": "soon"}`,
+			wantErr: regexp.MustCompile(`invalid duration`),
+		},
+		{
+			name:    "negative delay",
+			input:   `{"outcome": "retry", "retry_delay": "-1s"}`,
+			wantErr: regexp.MustCompile(`must not be negative`),
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := parseResult([]byte(tc.input))
+
+			if tc.wantErr == nil {
+				if err != nil {
+					t.Errorf("parseResult() failed: %v", err)
+				}
+			} else if err == nil || !tc.wantErr.MatchString(err.Error()) {
+				t.Errorf("parseResult() error %v doesn't match %q", err, tc.wantErr)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Result diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRunOutput(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		output    config.CommandOutput
+		wantFiles map[string]string
+		wantLines []string
+
+		// Streams for which forwarding to the log was stopped.
+		wantStopped []string
+	}{
+		{
+			name: "combined",
+			wantFiles: map[string]string{
+				"command_output.txt": "stdout 0\nstdout 1\nstdout 2\nstdout 3\nstdout 4\n" +
+					"stdout 5\nstdout 6\nstdout 7\nstdout 8\nstdout 9\nstderr\npartial",
+			},
+		},
+		{
+			name: "limited",
+			output: config.CommandOutput{
+				MaxBytes: 30,
+			},
+			wantFiles: map[string]string{
+				"command_output.txt": "stdout 0\nstdout" +
+					"\n[... 74 bytes omitted ...]\n" +
+					"\nstderr\npartial",
+			},
+		},
+		{
+			name: "separate",
+			output: config.CommandOutput{
+				MaxBytes: 18,
+				Separate: true,
+			},
+			wantFiles: map[string]string{
+				"command_stdout.txt": "stdout 0\n\n[... 72 bytes omitted ...]\nstdout 9\n",
+				"command_stderr.txt": "stderr\npartial",
+			},
+		},
+		{
+			name: "log",
+			output: config.CommandOutput{
+				Separate: true,
+				Log:      true,
+			},
+			wantFiles: map[string]string{
+				"command_stdout.txt": "stdout 0\nstdout 1\nstdout 2\nstdout 3\nstdout 4\n" +
+					"stdout 5\nstdout 6\nstdout 7\nstdout 8\nstdout 9\n",
+				"command_stderr.txt": "stderr\npartial",
+			},
+			wantLines: []string{
+				"stderr: partial",
+				"stderr: stderr",
+				"stdout: stdout 0",
+				"stdout: stdout 1",
+				"stdout: stdout 2",
+				"stdout: stdout 3",
+				"stdout: stdout 4",
+				"stdout: stdout 5",
+				"stdout: stdout 6",
+				"stdout: stdout 7",
+				"stdout: stdout 8",
+				"stdout: stdout 9",
+			},
+		},
+		{
+			name: "limited log",
+			output: config.CommandOutput{
+				MaxBytes: 30,
+				Separate: true,
+				Log:      true,
+			},
+			wantFiles: map[string]string{
+				"command_stdout.txt": "stdout 0\nstdout\n[... 60 bytes omitted ...]\nout 8\nstdout 9\n",
+				"command_stderr.txt": "stderr\npartial",
+			},
+			wantLines: []string{
+				"stderr: partial",
+				"stderr: stderr",
+				"stdout: std",
+				"stdout: stdout 0",
+				"stdout: stdout 1",
+				"stdout: stdout 2",
+			},
+			wantStopped: []string{"stdout"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			baseDir := t.TempDir()
+			loggerCore, observed := observer.New(zapcore.DebugLevel)
+
+			c, err := New(Options{
+				Logger:     zap.New(loggerCore),
+				SourceFile: testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "src"), "content"),
+				BaseDir:    baseDir,
+				Command:    fakeCommand.MakeArgs("print-streams"),
+				Output:     tc.output,
+			})
+			if err != nil {
+				t.Fatalf("New() failed: %v", err)
+			}
+
+			if err := c.Run(context.Background()); err != nil {
+				t.Errorf("Run() failed: %v", err)
+			}
+
+			for _, name := range []string{"command_output.txt", "command_stdout.txt", "command_stderr.txt"} {
+				want, ok := tc.wantFiles[name]
+
+				if !ok {
+					testutil.MustNotExist(t, filepath.Join(baseDir, name))
+					continue
+				}
+
+				got, err := os.ReadFile(filepath.Join(baseDir, name))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if diff := cmp.Diff(want, string(got)); diff != "" {
+					t.Errorf("Content of %s diff (-want +got):\n%s", name, diff)
+				}
+			}
+
+			var gotLines []string
+
+			for _, e := range observed.FilterMessage("Command output").All() {
+				m := e.ContextMap()
+				gotLines = append(gotLines, fmt.Sprintf("%s: %s", m["stream"], m["line"]))
+			}
+
+			slices.Sort(gotLines)
+
+			if diff := cmp.Diff(tc.wantLines, gotLines); diff != "" {
+				t.Errorf("Logged lines diff (-want +got):\n%s", diff)
+			}
+
+			var gotStopped []string
+
+			for _, e := range observed.FilterMessage("Command output forwarding stopped").All() {
+				gotStopped = append(gotStopped, fmt.Sprint(e.ContextMap()["stream"]))
+			}
+
+			if diff := cmp.Diff(tc.wantStopped, gotStopped); diff != "" {
+				t.Errorf("Stopped streams diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRunStdin(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		mode       string
+		existing   bool
+		wantErr    error
+		wantOutput map[string]string
+	}{
+		{
+			name: "success",
+			mode: "filter",
+			wantOutput: map[string]string{
+				"src.up": "CONTENT",
+			},
+		},
+		{
+			name:     "existing output",
+			mode:     "filter",
+			existing: true,
+			wantOutput: map[string]string{
+				"src.up":                  "previous",
+				"src (20200102030405).up": "CONTENT",
+			},
+		},
+		{
+			name:    "failure",
+			mode:    "filter-fail",
+			wantErr: cmpopts.AnyError,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			handlerPath := t.TempDir()
+			baseDir := t.TempDir()
+			outputDir := filepath.Join(handlerPath, "_", "out")
+
+			// Names of conflicting outputs contain the current time.
+			t.Cleanup(uniquename.SetRuntime(uniquename.Runtime{
+				Clock:   clockwork.NewFakeClockAt(time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC)),
+				Loc:     time.UTC,
+				RandInt: uniquename.DefaultRuntime.RandInt,
+			}))
+
+			if tc.existing {
+				testutil.MustMkdir(t, filepath.Dir(outputDir))
+				testutil.MustMkdir(t, outputDir)
+				testutil.MustWriteFile(t, filepath.Join(outputDir, "src.up"), "previous")
+			}
+
+			c, err := New(Options{
+				Logger:     zap.NewNop(),
+				SourceFile: testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "src"), "content"),
+				BaseDir:    baseDir,
+				Command:    fakeCommand.MakeArgs(tc.mode),
+				Stdin: config.Stdin{
+					Enabled:      true,
+					OutputDir:    "_/out",
+					OutputSuffix: ".up",
+				},
+				HandlerPath: handlerPath,
+			})
+			if err != nil {
+				t.Fatalf("New() failed: %v", err)
+			}
+
+			err = c.Run(context.Background())
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("Run() error diff (-want +got):\n%s", diff)
+			}
+
+			if got, err := os.ReadFile(filepath.Join(baseDir, "command_output.txt")); err != nil {
+				t.Error(err)
+			} else if diff := cmp.Diff("filtering\n", string(got)); diff != "" {
+				t.Errorf("Command output diff (-want +got):\n%s", diff)
+			}
+
+			// Output is only delivered on request.
+			testutil.MustLstat(t, filepath.Join(baseDir, "filter_output"))
+
+			if tc.wantErr == nil {
+				if err := c.DeliverOutput(); err != nil {
+					t.Errorf("DeliverOutput() failed: %v", err)
+				}
+
+				testutil.MustNotExist(t, filepath.Join(baseDir, "filter_output"))
+			}
+
+			got := map[string]string{}
+
+			if entries, err := os.ReadDir(outputDir); err == nil {
+				for _, i := range entries {
+					content, err := os.ReadFile(filepath.Join(outputDir, i.Name()))
+					if err != nil {
+						t.Fatal(err)
+					}
+
+					got[i.Name()] = string(content)
+				}
+			} else if !os.IsNotExist(err) {
+				t.Error(err)
+			}
+
+			if diff := cmp.Diff(tc.wantOutput, got, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("Delivered output diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package handlercommand
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// Placeholder logged instead of values read from files.
+const redactedValue = "[redacted]"
+
+// inheritedEnviron returns the variables of the current process passed to the
+// command.
+func (c *Command) inheritedEnviron() []string {
+	env := os.Environ()
+
+	if !c.opts.ClearEnv {
+		return env
+	}
+
+	var result []string
+
+	for _, i := range env {
+		if name, _, _ := strings.Cut(i, "="); slices.Contains(c.opts.EnvAllowlist, name) {
+			result = append(result, i)
+		}
+	}
+
+	return result
+}
+
+// configuredEnviron returns the variables configured for the handler. Values
+// read from files are replaced with a placeholder in the second slice, making
+// it suitable for logging.
+func (c *Command) configuredEnviron() (env, logged []string, err error) {
+	for _, name := range slices.Sorted(maps.Keys(c.opts.Env)) {
+		entry := name + "=" + c.opts.Env[name]
+
+		env = append(env, entry)
+		logged = append(logged, entry)
+	}
+
+	for _, name := range slices.Sorted(maps.Keys(c.opts.EnvFiles)) {
+		content, err := os.ReadFile(c.opts.EnvFiles[name])
+		if err != nil {
+			return nil, nil, fmt.Errorf("reading value for environment variable %s failed: %w", name, err)
+		}
+
+		env = append(env, name+"="+strings.TrimSuffix(string(content), "\n"))
+		logged = append(logged, name+"="+redactedValue)
+	}
+
+	return env, logged, nil
+}
@@ -0,0 +1,113 @@
+package handlercommand
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+
+	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/execshim"
+	"go.uber.org/zap"
+)
+
+// Function returning the arguments for invoking the shim. Replaced in tests.
+var shimCommand = execshim.DefaultShim
+
+// namespace returns the filesystem view for a command running in new
+// namespaces or nil if namespace isolation isn't enabled. The program itself
+// is included for commands using BAAMHACKL_PROGRAM.
+func (c *Command) namespace() *execshim.Namespace {
+	ns := c.opts.Namespaces
+	if !ns.Enabled {
+		return nil
+	}
+
+	return &execshim.Namespace{
+		ReadOnly:       append(append([]string(nil), ns.ReadOnly...), c.exe, c.inputDir, c.metadataFile),
+		ReadWrite:      append(append([]string(nil), ns.ReadWrite...), c.workDir, c.resultDir),
+		PrivateNetwork: !ns.Network,
+	}
+}
+
+// wrapCommand returns the command arguments, invoking the shim if resource
+// limits, filesystem restrictions or namespaces are configured. The program
+// itself is readable for commands using BAAMHACKL_PROGRAM.
+func (c *Command) wrapCommand() ([]string, error) {
+	spec := execshim.Spec{
+		Rlimits:   execshim.RlimitsFromConfig(c.opts.Rlimits),
+		Namespace: c.namespace(),
+	}
+
+	if ll := c.opts.Landlock; ll.Enabled {
+		if err := execshim.LandlockSupported(); err == nil {
+			spec.Landlock = &execshim.Landlock{
+				ReadOnly:  append(append([]string(nil), ll.ReadOnly...), c.exe, c.inputDir, c.metadataFile),
+				ReadWrite: append(append([]string(nil), ll.ReadWrite...), c.workDir, c.resultDir),
+			}
+		} else if errors.Is(err, execshim.ErrLandlockUnsupported) && ll.Unsupported == config.LandlockUnsupportedIgnore {
+			c.opts.Logger.Warn("Running command without filesystem restrictions", zap.Error(err))
+		} else {
+			return nil, err
+		}
+	}
+
+	if spec.Empty() {
+		return c.opts.Command, nil
+	}
+
+	shim, err := shimCommand()
+	if err != nil {
+		return nil, err
+	}
+
+	return execshim.Wrap(shim, spec, c.opts.Command)
+}
+
+// setupCgroup creates a dedicated cgroup for the command if configured. The
+// returned function reports the resource usage and removes the group after
+// the command finished.
+func (c *Command) setupCgroup(cmd *exec.Cmd) (func(), error) {
+	if c.opts.Cgroups == nil || !c.opts.Cgroup.Enabled() {
+		return func() {}, nil
+	}
+
+	logger := c.opts.Logger
+
+	g, err := c.opts.Cgroups.Create(c.opts.Cgroup)
+	if err != nil {
+		return nil, fmt.Errorf("creating cgroup failed: %w", err)
+	}
+
+	logger.Debug("Created cgroup", zap.String("path", g.Path()))
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = g.FD()
+
+	return func() {
+		if stats, err := g.Stats(); err != nil {
+			logger.Error("Reading cgroup statistics failed", zap.Error(err))
+		} else {
+			logger.Info("Cgroup resource usage",
+				zap.Uint64("memory_peak_bytes", stats.MemoryPeak),
+				zap.Uint64("oom_kills", stats.OOMKills))
+
+			if stats.OOMKills > 0 {
+				logger.Warn("Command was killed for exceeding the memory limit",
+					zap.Uint64("memory_max_bytes", c.opts.Cgroup.MemoryMaxBytes))
+			}
+
+			if c.opts.Metrics != nil {
+				c.opts.Metrics.ReportCgroupStats(stats.MemoryPeak, stats.OOMKills)
+			}
+		}
+
+		if err := g.Close(); err != nil {
+			logger.Error("Removing cgroup failed", zap.Error(err))
+		}
+	}, nil
+}
@@ -0,0 +1,59 @@
+package handlercommand
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/google/renameio/v2"
+)
+
+// Metadata describes the circumstances of an attempt. It's made available to
+// the command as a JSON file.
+type Metadata struct {
+	// Name of the handler.
+	Handler string `json:"handler"`
+
+	// Name of the changed file relative to the handler directory.
+	Name string `json:"name"`
+
+	// Size and modification time of the changed file as reported by
+	// Watchman.
+	Size  int64     `json:"size"`
+	MTime time.Time `json:"mtime"`
+
+	// Zero-based number of the attempt, matching the journal subdirectory.
+	Attempt int `json:"attempt"`
+
+	// Whether the attempt is the last one before giving up.
+	Final bool `json:"final"`
+
+	// Errors of previous attempts, oldest first.
+	PreviousErrors []string `json:"previous_errors"`
+
+	// Time at which the command is terminated. Set by the command.
+	Deadline *time.Time `json:"deadline,omitempty"`
+
+	// Journal directory of the task.
+	JournalDir string `json:"journal_dir"`
+}
+
+// writeMetadata stores the metadata in a file for the command.
+func (c *Command) writeMetadata(ctx context.Context) error {
+	m := c.opts.Metadata
+
+	if m.PreviousErrors == nil {
+		m.PreviousErrors = []string{}
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		m.Deadline = &deadline
+	}
+
+	buf, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return renameio.WriteFile(c.metadataFile, append(buf, '\n'), 0o644)
+}
@@ -0,0 +1,290 @@
+package handlercommand
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/hansmi/baamhackl/internal/headtail"
+	"github.com/hansmi/baamhackl/internal/uniquename"
+	"github.com/hansmi/baamhackl/internal/waryio"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+)
+
+// Lines longer than this are split when forwarded to the log.
+const maxLogLineLength = 4096
+
+// Amount of time to wait for output pipes to be closed after the command
+// exited. Processes outliving the command may keep them open.
+const outputWaitDelay = 5 * time.Second
+
+// lineLogger forwards complete lines written to it as debug messages. At most
+// limit bytes are forwarded if the limit is positive.
+type lineLogger struct {
+	logger *zap.Logger
+	stream string
+	buf    []byte
+
+	limit     int64
+	forwarded int64
+	stopped   bool
+}
+
+func (l *lineLogger) log(line []byte) {
+	l.logger.Debug("Command output",
+		zap.String("stream", l.stream),
+		zap.ByteString("line", line))
+}
+
+func (l *lineLogger) Write(p []byte) (int, error) {
+	total := len(p)
+
+	if l.stopped {
+		return total, nil
+	}
+
+	if l.limit > 0 {
+		if remaining := l.limit - l.forwarded; int64(len(p)) > remaining {
+			p = p[:remaining]
+			l.stopped = true
+		}
+
+		l.forwarded += int64(len(p))
+	}
+
+	l.buf = append(l.buf, p...)
+
+	for {
+		line, rest, found := bytes.Cut(l.buf, []byte{'\n'})
+		if !found {
+			break
+		}
+
+		l.log(line)
+		l.buf = rest
+	}
+
+	for len(l.buf) >= maxLogLineLength {
+		l.log(l.buf[:maxLogLineLength])
+		l.buf = l.buf[maxLogLineLength:]
+	}
+
+	if l.stopped {
+		l.Flush()
+		l.logger.Warn("Command output forwarding stopped",
+			zap.String("stream", l.stream),
+			zap.Int64("max_bytes", l.limit))
+	}
+
+	return total, nil
+}
+
+// Flush logs an incomplete last line, if any.
+func (l *lineLogger) Flush() {
+	if len(l.buf) > 0 {
+		l.log(l.buf)
+		l.buf = nil
+	}
+}
+
+// outputStream captures one output stream of a command in a file.
+type outputStream struct {
+	logger  *zap.Logger
+	fh      *os.File
+	limited *headtail.Writer
+	lines   *lineLogger
+	w       io.Writer
+}
+
+// openOutputStream creates the file at path. The file is given to the command
+// directly unless the output size is limited or forwarded to the log.
+func (c *Command) openOutputStream(path, stream string) (*outputStream, error) {
+	fh, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND|os.O_EXCL, 0o666)
+	if err != nil {
+		return nil, fmt.Errorf("opening output file failed: %w", err)
+	}
+
+	s := &outputStream{logger: c.opts.Logger, fh: fh, w: fh}
+
+	if limit := c.opts.Output.MaxBytes; limit > 0 {
+		s.limited = headtail.New(fh, int64(limit))
+		s.w = s.limited
+	}
+
+	if c.opts.Output.Log {
+		s.lines = &lineLogger{
+			logger: c.opts.Logger,
+			stream: stream,
+			limit:  int64(c.opts.Output.MaxBytes),
+		}
+		s.w = io.MultiWriter(s.w, s.lines)
+	}
+
+	return s, nil
+}
+
+// piped reports whether the output is copied by the current process.
+func (s *outputStream) piped() bool {
+	return s.w != io.Writer(s.fh)
+}
+
+func (s *outputStream) Close() (err error) {
+	if s.lines != nil {
+		s.lines.Flush()
+	}
+
+	if s.limited != nil {
+		err = s.limited.Close()
+
+		if omitted := s.limited.Omitted(); omitted > 0 {
+			s.logger.Warn("Command output was truncated",
+				zap.String("path", s.fh.Name()),
+				zap.Int64("omitted_bytes", omitted))
+		}
+	}
+
+	return multierr.Append(err, s.fh.Close())
+}
+
+// commandOutput holds the files receiving the output of a command.
+type commandOutput struct {
+	streams []*outputStream
+
+	// Standard output of filter commands.
+	filter *os.File
+
+	stdout, stderr io.Writer
+}
+
+// openOutput creates the files for capturing the command output, either
+// a single file for standard output and error or one per stream.
+func (c *Command) openOutput() (_ *commandOutput, err error) {
+	o := &commandOutput{}
+
+	defer func() {
+		if err != nil {
+			multierr.AppendInto(&err, o.Close())
+		}
+	}()
+
+	if c.opts.Stdin.Enabled {
+		// Standard output is the result of filter commands and written
+		// verbatim. Only standard error is captured in the usual way.
+		o.filter, err = os.OpenFile(c.filterFile, os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o666)
+		if err != nil {
+			return nil, fmt.Errorf("opening filter output file failed: %w", err)
+		}
+
+		path := c.outputFile
+
+		if c.opts.Output.Separate {
+			path = c.stderrFile
+		}
+
+		s, err := c.openOutputStream(path, "stderr")
+		if err != nil {
+			return nil, err
+		}
+
+		o.streams = append(o.streams, s)
+		o.stdout = o.filter
+		o.stderr = s.w
+
+		return o, nil
+	}
+
+	if !c.opts.Output.Separate {
+		s, err := c.openOutputStream(c.outputFile, "output")
+		if err != nil {
+			return nil, err
+		}
+
+		o.streams = append(o.streams, s)
+
+		// The same writer for both streams makes the command share a single
+		// pipe, retaining the order of messages.
+		o.stdout = s.w
+		o.stderr = s.w
+
+		return o, nil
+	}
+
+	for _, i := range []struct {
+		path, stream string
+		dest         *io.Writer
+	}{
+		{c.stdoutFile, "stdout", &o.stdout},
+		{c.stderrFile, "stderr", &o.stderr},
+	} {
+		s, err := c.openOutputStream(i.path, i.stream)
+		if err != nil {
+			return nil, err
+		}
+
+		o.streams = append(o.streams, s)
+
+		*i.dest = s.w
+	}
+
+	return o, nil
+}
+
+// piped reports whether any output is copied by the current process.
+func (o *commandOutput) piped() bool {
+	for _, s := range o.streams {
+		if s.piped() {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (o *commandOutput) Close() error {
+	var err error
+
+	for _, s := range o.streams {
+		multierr.AppendInto(&err, s.Close())
+	}
+
+	if o.filter != nil {
+		multierr.AppendInto(&err, o.filter.Close())
+	}
+
+	return err
+}
+
+// DeliverOutput moves the standard output of a filter command into the output
+// directory without replacing existing files. It must only be called once the
+// attempt is known to have succeeded. Nothing is done for other commands.
+func (c *Command) DeliverOutput() error {
+	if !c.opts.Stdin.Enabled {
+		return nil
+	}
+
+	dir, err := waryio.EnsureRelDir(c.opts.HandlerPath, c.opts.Stdin.OutputDir, os.ModePerm)
+	if err != nil {
+		return fmt.Errorf("creating output directory failed: %w", err)
+	}
+
+	opts := uniquename.DefaultOptions
+	opts.TimePrefixEnabled = false
+
+	g, err := uniquename.New(filepath.Join(dir, filepath.Base(c.opts.SourceFile)+c.opts.Stdin.OutputSuffix), opts)
+	if err != nil {
+		return err
+	}
+
+	dest, err := waryio.MoveToAvailableName(c.filterFile, g)
+	if err != nil {
+		return fmt.Errorf("moving filter output failed: %w", err)
+	}
+
+	c.opts.Logger.Info("Moved filter output", zap.String("dest", dest))
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package handlercommand
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// How often to check whether members of a process group remain after the
+// group leader exited.
+const processGroupPollInterval = 100 * time.Millisecond
+
+func signalProcessGroup(pgid int, sig syscall.Signal) error {
+	if err := syscall.Kill(-pgid, sig); !(err == nil || errors.Is(err, syscall.ESRCH)) {
+		return err
+	}
+
+	return nil
+}
+
+func processGroupExists(pgid int) bool {
+	err := syscall.Kill(-pgid, 0)
+
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// waitProcessGroup waits for a command started in its own process group. When
+// the context is cancelled the whole group receives SIGTERM. Members still
+// running after the grace period, including those outliving the group leader,
+// are killed using SIGKILL. The last signal sent is returned, or zero if the
+// command exited by itself.
+func waitProcessGroup(ctx context.Context, logger *zap.Logger, cmd *exec.Cmd, grace time.Duration) (syscall.Signal, error) {
+	waitErr := make(chan error, 1)
+
+	go func() {
+		err := cmd.Wait()
+
+		// Processes outliving the command may keep the output pipes open.
+		if errors.Is(err, exec.ErrWaitDelay) {
+			logger.Warn("Output remained open after command exited")
+			err = nil
+		}
+
+		waitErr <- err
+	}()
+
+	select {
+	case err := <-waitErr:
+		return 0, err
+	case <-ctx.Done():
+	}
+
+	pgid := cmd.Process.Pid
+
+	sendSignal := func(sig syscall.Signal) syscall.Signal {
+		logger.Info("Sending signal to process group",
+			zap.Int("pgid", pgid),
+			zap.Stringer("signal", sig))
+
+		if err := signalProcessGroup(pgid, sig); err != nil {
+			logger.Error("Sending signal failed", zap.Error(err))
+		}
+
+		return sig
+	}
+
+	if grace <= 0 {
+		sent := sendSignal(syscall.SIGKILL)
+
+		return sent, <-waitErr
+	}
+
+	sent := sendSignal(syscall.SIGTERM)
+
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	var err error
+
+	select {
+	case err = <-waitErr:
+	case <-timer.C:
+		sent = sendSignal(syscall.SIGKILL)
+
+		return sent, <-waitErr
+	}
+
+	// The group leader exited, but other members may still be running for
+	// the remainder of the grace period.
+	ticker := time.NewTicker(processGroupPollInterval)
+	defer ticker.Stop()
+
+	for processGroupExists(pgid) {
+		select {
+		case <-ticker.C:
+		case <-timer.C:
+			sent = sendSignal(syscall.SIGKILL)
+
+			return sent, err
+		}
+	}
+
+	return sent, err
+}
@@ -0,0 +1,139 @@
+package handlercommand
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Outcomes a command can declare in its result file.
+const (
+	// Processing succeeded.
+	ResultSuccess = "success"
+
+	// Processing failed and should be retried, optionally after a custom
+	// delay.
+	ResultRetry = "retry"
+
+	// Processing failed and retrying won't help.
+	ResultPermanentFailure = "permanent_failure"
+
+	// The file doesn't need processing. It's archived like a successfully
+	// processed file.
+	ResultSkip = "skip"
+)
+
+// Result is the structured outcome optionally written by a command.
+type Result struct {
+	// One of the Result* constants.
+	Outcome string `json:"outcome"`
+
+	// Human-readable description of the outcome.
+	Message string `json:"message,omitempty"`
+
+	// Delay before the next attempt. Only used with ResultRetry. Zero uses
+	// the configured retry delay.
+	RetryDelay time.Duration `json:"-"`
+
+	// Files produced by the command.
+	Outputs []string `json:"outputs,omitempty"`
+
+	// Arbitrary values recorded in the journal.
+	Annotations map[string]any `json:"annotations,omitempty"`
+}
+
+func parseResult(data []byte) (*Result, error) {
+	var raw struct {
+		Result
+		RetryDelay string `json:"retry_delay"`
+	}
+
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+
+	r := raw.Result
+
+	switch r.Outcome {
+	case ResultSuccess, ResultRetry, ResultPermanentFailure, ResultSkip:
+	default:
+		return nil, fmt.Errorf("unknown outcome %q", r.Outcome)
+	}
+
+	if raw.RetryDelay != "" {
+		if r.Outcome != ResultRetry {
+			return nil, fmt.Errorf("retry delay is only supported with outcome %q", ResultRetry)
+		}
+
+		delay, err := time.ParseDuration(raw.RetryDelay)
+		if err != nil {
+			return nil, err
+		}
+
+		if delay < 0 {
+			return nil, fmt.Errorf("retry delay must not be negative: %v", delay)
+		}
+
+		r.RetryDelay = delay
+	}
+
+	return &r, nil
+}
+
+// Maximum size of a result file.
+const maxResultSize = 1024 * 1024
+
+// readResultFile reads the result file without following symlinks or blocking
+// on special files. The file is under control of the command.
+func readResultFile(path string) ([]byte, error) {
+	fh, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fh.Close()
+
+	fi, err := fh.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("%w: result is not a regular file: %s", os.ErrInvalid, fi.Mode().Type())
+	}
+
+	data, err := io.ReadAll(io.LimitReader(fh, maxResultSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) > maxResultSize {
+		return nil, fmt.Errorf("%w: result file exceeds %d bytes", os.ErrInvalid, maxResultSize)
+	}
+
+	return data, nil
+}
+
+// Result reads the result written by the command. Nil is returned if the
+// command didn't write a result.
+func (c *Command) Result() (*Result, error) {
+	data, err := readResultFile(c.resultFile)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+
+		return nil, err
+	}
+
+	r, err := parseResult(data)
+	if err != nil {
+		return nil, fmt.Errorf("parsing command result failed: %w", err)
+	}
+
+	return r, nil
+}
@@ -2,19 +2,27 @@ package handlertask
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
+	"sync"
+	"time"
 
+	"github.com/hansmi/baamhackl/internal/cgroup"
 	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/eventbus"
 	"github.com/hansmi/baamhackl/internal/fuzzduration"
 	"github.com/hansmi/baamhackl/internal/handlerattempt"
+	"github.com/hansmi/baamhackl/internal/handlercommand"
 	"github.com/hansmi/baamhackl/internal/handlerretrystrategy"
 	"github.com/hansmi/baamhackl/internal/journal"
 	"github.com/hansmi/baamhackl/internal/scheduler"
 	"github.com/hansmi/baamhackl/internal/teelog"
+	"github.com/hansmi/baamhackl/internal/tracing"
 	"github.com/hansmi/baamhackl/internal/waryio"
+	"github.com/hansmi/baamhackl/internal/watchman"
 	"go.uber.org/zap"
 )
 
@@ -31,6 +39,12 @@ type Options struct {
 
 	// Interface for reporting metrics.
 	Metrics MetricsReporter
+
+	// Destination for lifecycle events.
+	Events eventbus.Emitter
+
+	// Manager for per-command cgroups. Nil if cgroups aren't available.
+	Cgroups *cgroup.Manager
 }
 
 type Task struct {
@@ -38,9 +52,15 @@ type Task struct {
 
 	retry          *handlerretrystrategy.Strategy
 	currentAttempt int
-	journalDir     string
 	fuzzFactor     float32
 
+	mu         sync.Mutex
+	journalDir string
+	change     watchman.FileChange
+
+	// Errors of previous attempts. Guarded by mu like the fields above.
+	previousErrors []string
+
 	invoke func(context.Context, handlerattempt.Options) (bool, error)
 }
 
@@ -55,7 +75,27 @@ func (t *Task) Name() string {
 	return t.opts.Name
 }
 
+// ReportChange records the most recently reported state of the changed file.
+func (t *Task) ReportChange(change watchman.FileChange) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.change = change
+}
+
+// JournalDir returns the path to the journal directory used by the task. The
+// directory is only created when the task runs for the first time.
+func (t *Task) JournalDir() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.journalDir
+}
+
 func (t *Task) ensureJournalDir() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
 	if t.journalDir == "" {
 		path, err := t.opts.Journal.CreateTaskDir(t.opts.Name)
 		if err != nil {
@@ -67,7 +107,15 @@ func (t *Task) ensureJournalDir() error {
 	return nil
 }
 
-func (t *Task) Run(ctx context.Context, acquireLock func()) error {
+func (t *Task) Run(ctx context.Context, acquireLock func()) (err error) {
+	ctx, span := tracing.Start(ctx, "handlertask.Run",
+		tracing.FileKey.String(t.opts.Name),
+		tracing.AttemptKey.Int(t.currentAttempt),
+	)
+	defer func() {
+		tracing.End(span, err)
+	}()
+
 	logger := zap.L().With(
 		zap.String("root", t.opts.Config.Path),
 		zap.String("name", t.opts.Name),
@@ -105,9 +153,29 @@ func (t *Task) Run(ctx context.Context, acquireLock func()) error {
 	}
 
 	retryDelay := t.retry.Current()
+
+	events := t.opts.Events.WithName(t.opts.Name).WithAttempt(t.currentAttempt)
+	events.Emit(eventbus.Event{
+		Type: eventbus.AttemptStarted,
+		Path: t.journalDir,
+	})
+
+	t.mu.Lock()
+	metadata := handlercommand.Metadata{
+		Handler:        t.opts.Config.Name,
+		Name:           t.opts.Name,
+		Size:           t.change.Size,
+		MTime:          t.change.MTime,
+		Attempt:        t.currentAttempt,
+		Final:          retryDelay == scheduler.Stop,
+		PreviousErrors: append([]string(nil), t.previousErrors...),
+		JournalDir:     t.journalDir,
+	}
+	t.mu.Unlock()
+
 	var permanent bool
 
-	err := taskLogger.Wrap(func(inner *zap.Logger) error {
+	err = taskLogger.Wrap(func(inner *zap.Logger) error {
 		taskDir, err := waryio.EnsureRelDir(t.journalDir, strconv.Itoa(t.currentAttempt), os.ModePerm)
 		if err != nil {
 			return err
@@ -116,14 +184,18 @@ func (t *Task) Run(ctx context.Context, acquireLock func()) error {
 		permanent, err = t.invoke(ctx, handlerattempt.Options{
 			Logger:  inner,
 			Metrics: t.opts.Metrics,
+			Events:  events,
+			Cgroups: t.opts.Cgroups,
 
-			Config:      t.opts.Config,
-			Journal:     t.opts.Journal,
-			ChangedFile: filepath.Join(t.opts.Config.Path, t.opts.Name),
-			BaseDir:     taskDir,
+			Config:       t.opts.Config,
+			Journal:      t.opts.Journal,
+			ChangedFile:  filepath.Join(t.opts.Config.Path, t.opts.Name),
+			BaseDir:      taskDir,
+			JournalEntry: t.journalDir,
 
 			// Is this the last attempt?
-			Final: retryDelay == scheduler.Stop,
+			Final:    metadata.Final,
+			Metadata: metadata,
 
 			AcquireLock: acquireLock,
 		})
@@ -134,14 +206,37 @@ func (t *Task) Run(ctx context.Context, acquireLock func()) error {
 		return err
 	})
 
+	if err != nil {
+		t.mu.Lock()
+		t.previousErrors = append(t.previousErrors, err.Error())
+		t.mu.Unlock()
+	}
+
 	if permanent || err == nil {
 		return err
 	}
 
 	t.retry.Advance()
 
-	return &scheduler.TaskError{
+	te := &scheduler.TaskError{
 		Err:        err,
 		RetryDelay: fuzzduration.Random(retryDelay, t.fuzzFactor),
 	}
+
+	// Commands may request a custom delay unless no retries are left.
+	var rde *handlerattempt.RetryDelayError
+
+	if retryDelay != scheduler.Stop && errors.As(err, &rde) {
+		te.RetryDelay = rde.Delay
+	}
+
+	if !te.Permanent() {
+		events.Emit(eventbus.Event{
+			Type:       eventbus.RetryScheduled,
+			RetryAfter: eventbus.Time(time.Now().Add(te.RetryDelay)),
+			Error:      err.Error(),
+		})
+	}
+
+	return te
 }
@@ -11,10 +11,13 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/eventbus"
 	"github.com/hansmi/baamhackl/internal/handlerattempt"
+	"github.com/hansmi/baamhackl/internal/handlercommand"
 	"github.com/hansmi/baamhackl/internal/journal"
 	"github.com/hansmi/baamhackl/internal/scheduler"
 	"github.com/hansmi/baamhackl/internal/testutil"
+	"github.com/hansmi/baamhackl/internal/watchman"
 )
 
 var errTest = errors.New("test error")
@@ -92,6 +95,15 @@ func TestHandlerTaskRun(t *testing.T) {
 			wantErr:   errTest,
 			wantDelay: config.HandlerDefaults.RetryDelayInitial,
 		},
+		{
+			name:       "retry with requested delay",
+			retryCount: 2,
+			invoke: func() (bool, error) {
+				return false, &handlerattempt.RetryDelayError{Err: errTest, Delay: time.Hour}
+			},
+			wantErr:   errTest,
+			wantDelay: time.Hour,
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			cfg := config.HandlerDefaults
@@ -101,10 +113,13 @@ func TestHandlerTaskRun(t *testing.T) {
 
 			testutil.MustWriteFile(t, filepath.Join(cfg.Path, "test.txt"), "content")
 
+			bus := eventbus.New(0)
+
 			task := New(Options{
 				Config:  &cfg,
 				Journal: journal.New(&cfg),
 				Name:    "test.txt",
+				Events:  eventbus.Emitter{Bus: bus, Handler: "test"},
 			})
 			task.fuzzFactor = 0
 			task.invoke = func(ctx context.Context, opts handlerattempt.Options) (bool, error) {
@@ -145,7 +160,88 @@ func TestHandlerTaskRun(t *testing.T) {
 					t.Errorf("Not a directory: %+v", st)
 				}
 				testutil.MustNotExist(t, filepath.Join(task.journalDir, fmt.Sprint(attempt+1)))
+
+				wantEvents := []eventbus.Type{eventbus.AttemptStarted}
+
+				if !done && err != nil {
+					wantEvents = append(wantEvents, eventbus.RetryScheduled)
+				}
+
+				events, _, err := bus.Wait(ctx, bus.LastSeq()-uint64(len(wantEvents)), nil)
+				if err != nil {
+					t.Errorf("Wait() failed: %v", err)
+				}
+
+				var gotEvents []eventbus.Type
+
+				for _, e := range events {
+					gotEvents = append(gotEvents, e.Type)
+
+					if e.Name != "test.txt" || e.Attempt == nil || *e.Attempt != attempt {
+						t.Errorf("Event lacks task details: %+v", e)
+					}
+				}
+
+				if diff := cmp.Diff(wantEvents, gotEvents); diff != "" {
+					t.Errorf("Event diff (-want +got):\n%s", diff)
+				}
 			}
 		})
 	}
 }
+
+func TestHandlerTaskMetadata(t *testing.T) {
+	cfg := config.HandlerDefaults
+	cfg.Name = "handler"
+	cfg.RetryCount = 2
+	cfg.Path = t.TempDir()
+
+	testutil.MustWriteFile(t, filepath.Join(cfg.Path, "test.txt"), "content")
+
+	mtime := time.Date(2020, time.March, 1, 2, 3, 4, 0, time.UTC)
+
+	task := New(Options{
+		Config:  &cfg,
+		Journal: journal.New(&cfg),
+		Name:    "test.txt",
+	})
+	task.ReportChange(watchman.FileChange{Name: "test.txt", Size: 1})
+	task.ReportChange(watchman.FileChange{Name: "test.txt", Size: 7, MTime: mtime})
+
+	var got []handlercommand.Metadata
+
+	task.invoke = func(ctx context.Context, opts handlerattempt.Options) (bool, error) {
+		got = append(got, opts.Metadata)
+
+		return false, fmt.Errorf("attempt %d failed", len(got))
+	}
+
+	for attempt := 0; attempt <= cfg.RetryCount; attempt++ {
+		if err := task.Run(context.Background(), nil); err == nil {
+			t.Errorf("Run() succeeded")
+		}
+	}
+
+	var want []handlercommand.Metadata
+
+	for attempt, previousErrors := range [][]string{
+		nil,
+		{"attempt 1 failed"},
+		{"attempt 1 failed", "attempt 2 failed"},
+	} {
+		want = append(want, handlercommand.Metadata{
+			Handler:        "handler",
+			Name:           "test.txt",
+			Size:           7,
+			MTime:          mtime,
+			Attempt:        attempt,
+			Final:          attempt == cfg.RetryCount,
+			PreviousErrors: previousErrors,
+			JournalDir:     task.journalDir,
+		})
+	}
+
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Metadata diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,117 @@
+// Package headtail implements a writer retaining only the beginning and end
+// of its input.
+package headtail
+
+import (
+	"fmt"
+	"io"
+)
+
+// Writer passes the first half of the configured limit to the underlying
+// writer immediately. The last half is buffered in memory and written by
+// Close, preceded by a marker if anything was omitted in between.
+type Writer struct {
+	w io.Writer
+
+	// Number of bytes still passed through directly.
+	headRemaining int64
+
+	tailSize int
+	tail     []byte
+	tailPos  int
+
+	omitted int64
+}
+
+// New returns a writer retaining at most limit bytes of its input, not
+// counting the truncation marker. A limit of zero disables truncation.
+func New(w io.Writer, limit int64) *Writer {
+	if limit <= 0 {
+		return &Writer{w: w, headRemaining: -1}
+	}
+
+	head := limit / 2
+
+	return &Writer{
+		w:             w,
+		headRemaining: head,
+		tailSize:      int(limit - head),
+	}
+}
+
+// Omitted returns the number of bytes dropped so far.
+func (w *Writer) Omitted() int64 {
+	return w.omitted
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	total := len(p)
+
+	if w.headRemaining < 0 {
+		return w.w.Write(p)
+	}
+
+	if w.headRemaining > 0 {
+		n := int(min(w.headRemaining, int64(len(p))))
+
+		if _, err := w.w.Write(p[:n]); err != nil {
+			return 0, err
+		}
+
+		w.headRemaining -= int64(n)
+		p = p[n:]
+	}
+
+	w.writeTail(p)
+
+	return total, nil
+}
+
+// writeTail stores data in the ring buffer holding the end of the input.
+func (w *Writer) writeTail(p []byte) {
+	if excess := len(p) - w.tailSize; excess > 0 {
+		w.omitted += int64(excess)
+		p = p[excess:]
+	}
+
+	// Grow the buffer until it reaches its final size.
+	if room := w.tailSize - len(w.tail); room > 0 {
+		n := min(room, len(p))
+
+		w.tail = append(w.tail, p[:n]...)
+		p = p[n:]
+	}
+
+	for len(p) > 0 {
+		n := copy(w.tail[w.tailPos:], p)
+
+		w.omitted += int64(n)
+		w.tailPos = (w.tailPos + n) % len(w.tail)
+		p = p[n:]
+	}
+}
+
+// Close writes the buffered end of the input. The underlying writer is not
+// closed.
+func (w *Writer) Close() error {
+	if w.omitted > 0 {
+		if _, err := fmt.Fprintf(w.w, "\n[... %d bytes omitted ...]\n", w.omitted); err != nil {
+			return err
+		}
+	}
+
+	for _, part := range [][]byte{w.tail[w.tailPos:], w.tail[:w.tailPos]} {
+		if len(part) == 0 {
+			continue
+		}
+
+		if _, err := w.w.Write(part); err != nil {
+			return err
+		}
+	}
+
+	w.tail = nil
+	w.tailPos = 0
+
+	return nil
+}
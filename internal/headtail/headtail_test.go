@@ -0,0 +1,98 @@
+package headtail
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestWriter(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		limit       int64
+		writes      []string
+		want        string
+		wantOmitted int64
+	}{
+		{name: "empty", limit: 10},
+		{
+			name:   "unlimited",
+			writes: []string{"hello", " ", "world"},
+			want:   "hello world",
+		},
+		{
+			name:   "below limit",
+			limit:  20,
+			writes: []string{"hello", " ", "world"},
+			want:   "hello world",
+		},
+		{
+			name:   "exactly at limit",
+			limit:  11,
+			writes: []string{"hello world"},
+			want:   "hello world",
+		},
+		{
+			name:        "single large write",
+			limit:       6,
+			writes:      []string{"abcdefghijklmnopqrstuvwxyz"},
+			want:        "abc\n[... 20 bytes omitted ...]\nxyz",
+			wantOmitted: 20,
+		},
+		{
+			name:        "many small writes",
+			limit:       6,
+			writes:      strings.Split("abcdefghijklmnopqrstuvwxyz", ""),
+			want:        "abc\n[... 20 bytes omitted ...]\nxyz",
+			wantOmitted: 20,
+		},
+		{
+			name:        "writes spanning ring end",
+			limit:       8,
+			writes:      []string{"abcde", "fgh", "ijklm", "nopq", "rs"},
+			want:        "abcd\n[... 11 bytes omitted ...]\npqrs",
+			wantOmitted: 11,
+		},
+		{
+			name:        "odd limit",
+			limit:       5,
+			writes:      []string{"0123456789"},
+			want:        "01\n[... 5 bytes omitted ...]\n789",
+			wantOmitted: 5,
+		},
+		{
+			name:        "limit of one",
+			limit:       1,
+			writes:      []string{"abc"},
+			want:        "\n[... 2 bytes omitted ...]\nc",
+			wantOmitted: 2,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+
+			w := New(&buf, tc.limit)
+
+			for _, i := range tc.writes {
+				if n, err := w.Write([]byte(i)); err != nil {
+					t.Errorf("Write() failed: %v", err)
+				} else if n != len(i) {
+					t.Errorf("Write() returned %d, want %d", n, len(i))
+				}
+			}
+
+			if err := w.Close(); err != nil {
+				t.Errorf("Close() failed: %v", err)
+			}
+
+			if diff := cmp.Diff(tc.want, buf.String()); diff != "" {
+				t.Errorf("Output diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.wantOmitted, w.Omitted()); diff != "" {
+				t.Errorf("Omitted diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
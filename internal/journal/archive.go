@@ -0,0 +1,230 @@
+package journal
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/hansmi/baamhackl/internal/waryio"
+	"go.uber.org/multierr"
+)
+
+// ArchiveSuffix is appended to the name of compressed journal entries.
+const ArchiveSuffix = ".tar.gz"
+
+// IsArchive reports whether the given journal entry is a compressed archive.
+func IsArchive(entry string) bool {
+	return strings.HasSuffix(entry, ArchiveSuffix)
+}
+
+func writeTarEntry(tw *tar.Writer, base, p string, d fs.DirEntry) error {
+	fi, err := d.Info()
+	if err != nil {
+		return err
+	}
+
+	var link string
+
+	switch fi.Mode().Type() {
+	case 0, fs.ModeDir:
+	case fs.ModeSymlink:
+		if link, err = os.Readlink(p); err != nil {
+			return err
+		}
+	default:
+		// Sockets, named pipes and devices have no content worth keeping.
+		return nil
+	}
+
+	rel, err := filepath.Rel(base, p)
+	if err != nil {
+		return err
+	}
+
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+
+	hdr.Name = filepath.ToSlash(rel)
+	hdr.Uname = ""
+	hdr.Gname = ""
+
+	if fi.IsDir() {
+		hdr.Name += "/"
+	}
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	if !fi.Mode().IsRegular() {
+		return nil
+	}
+
+	fh, err := os.OpenFile(p, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return err
+	}
+
+	defer fh.Close()
+
+	if n, err := io.Copy(tw, fh); err != nil {
+		return err
+	} else if n != hdr.Size {
+		return fmt.Errorf("%w: %s: copied %d bytes while file has %d bytes", waryio.ErrFileChanged, p, n, hdr.Size)
+	}
+
+	return nil
+}
+
+// writeArchive stores the contents of dir in a gzip-compressed tar archive.
+func writeArchive(w io.Writer, dir string) (err error) {
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+
+	if err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if p == dir {
+			return nil
+		}
+
+		return writeTarEntry(tw, dir, p, d)
+	}); err != nil {
+		return err
+	}
+
+	return multierr.Combine(tw.Close(), zw.Close())
+}
+
+// compressEntry replaces the journal entry directory at path with an archive
+// containing all of its files. The archive retains the modification time of
+// the directory. The path to the archive is returned.
+func compressEntry(dir string) (_ string, err error) {
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return "", err
+	}
+
+	if !fi.IsDir() {
+		return "", fmt.Errorf("%w: not a directory: %s", os.ErrInvalid, dir)
+	}
+
+	dest := dir + ArchiveSuffix
+
+	tmpfile, err := os.CreateTemp(filepath.Dir(dir), ".compress*")
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		if err != nil {
+			multierr.AppendInto(&err, os.Remove(tmpfile.Name()))
+		}
+	}()
+
+	if err := func() (err error) {
+		defer multierr.AppendInvoke(&err, multierr.Close(tmpfile))
+
+		if err := writeArchive(tmpfile, dir); err != nil {
+			return err
+		}
+
+		return tmpfile.Sync()
+	}(); err != nil {
+		return "", err
+	}
+
+	if err := os.Chtimes(tmpfile.Name(), fi.ModTime(), fi.ModTime()); err != nil {
+		return "", err
+	}
+
+	// The directory must not have been modified while writing the archive.
+	if after, err := os.Lstat(dir); err != nil {
+		return "", err
+	} else if err := waryio.DescribeChanges(fi, after).Err(); err != nil {
+		return "", err
+	}
+
+	if err := waryio.RenameWithoutReplace(tmpfile.Name(), dest); err != nil {
+		return "", err
+	}
+
+	if err := os.RemoveAll(dir); err != nil {
+		return dest, fmt.Errorf("removing compressed directory failed: %w", err)
+	}
+
+	return dest, nil
+}
+
+type entryFile struct {
+	io.Reader
+	close func() error
+}
+
+func (f *entryFile) Close() error {
+	return f.close()
+}
+
+func openArchiveFile(archive, name string) (_ io.ReadCloser, err error) {
+	fh, err := os.Open(archive)
+	if err != nil {
+		return nil, err
+	}
+
+	defer func() {
+		if err != nil {
+			fh.Close()
+		}
+	}()
+
+	zr, err := gzip.NewReader(fh)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", archive, err)
+	}
+
+	tr := tar.NewReader(zr)
+
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = &os.PathError{Op: "open", Path: filepath.Join(archive, name), Err: os.ErrNotExist}
+			}
+
+			return nil, err
+		}
+
+		if path.Clean(hdr.Name) == name && hdr.Typeflag == tar.TypeReg {
+			return &entryFile{
+				Reader: tr,
+				close:  fh.Close,
+			}, nil
+		}
+	}
+}
+
+// OpenEntryFile opens a file within a journal entry, e.g. the task log
+// ("log.txt"). Entries may be plain directories or compressed archives.
+func OpenEntryFile(entry, name string) (io.ReadCloser, error) {
+	if !filepath.IsLocal(name) {
+		return nil, fmt.Errorf("%w: name must be local: %s", os.ErrInvalid, name)
+	}
+
+	if IsArchive(entry) {
+		return openArchiveFile(entry, filepath.ToSlash(filepath.Clean(name)))
+	}
+
+	return os.OpenFile(filepath.Join(entry, name), os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+}
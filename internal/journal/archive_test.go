@@ -0,0 +1,191 @@
+package journal
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/testutil"
+	"github.com/hansmi/baamhackl/internal/uniquename"
+	"github.com/jonboulle/clockwork"
+	"go.uber.org/zap/zaptest"
+)
+
+func readEntryFile(t *testing.T, entry, name string) (string, error) {
+	t.Helper()
+
+	fh, err := OpenEntryFile(entry, name)
+	if err != nil {
+		return "", err
+	}
+
+	defer fh.Close()
+
+	buf, err := io.ReadAll(fh)
+
+	return string(buf), err
+}
+
+func makeEntry(t *testing.T, dir string, mtime time.Time) string {
+	t.Helper()
+
+	testutil.MustWriteFile(t, filepath.Join(dir, "log.txt"), "log content\n")
+	testutil.MustMkdir(t, filepath.Join(dir, "0"))
+	testutil.MustWriteFile(t, filepath.Join(dir, "0", "command_output.txt"), "output\n")
+
+	if err := os.Symlink("log.txt", filepath.Join(dir, "link")); err != nil {
+		t.Errorf("Symlink() failed: %v", err)
+	}
+
+	if err := os.Chtimes(dir, mtime, mtime); err != nil {
+		t.Errorf("Chtimes() failed: %v", err)
+	}
+
+	return dir
+}
+
+func TestCompressEntry(t *testing.T) {
+	mtime := time.Date(2020, time.January, 2, 3, 4, 5, 0, time.UTC)
+	dir := makeEntry(t, testutil.MustMkdir(t, filepath.Join(t.TempDir(), "entry")), mtime)
+
+	archive, err := compressEntry(dir)
+	if err != nil {
+		t.Fatalf("compressEntry() failed: %v", err)
+	}
+
+	testutil.MustNotExist(t, dir)
+
+	if diff := cmp.Diff(dir+ArchiveSuffix, archive); diff != "" {
+		t.Errorf("Archive path diff (-want +got):\n%s", diff)
+	}
+
+	if st := testutil.MustLstat(t, archive); !st.ModTime().Equal(mtime) {
+		t.Errorf("Archive modification time is %v, want %v", st.ModTime(), mtime)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		want    string
+		wantErr error
+	}{
+		{name: "log.txt", want: "log content\n"},
+		{name: "0/command_output.txt", want: "output\n"},
+		{name: "./0/../log.txt", want: "log content\n"},
+		{name: "missing", wantErr: os.ErrNotExist},
+		{name: "0", wantErr: os.ErrNotExist},
+		{name: "link", wantErr: os.ErrNotExist},
+		{name: "../log.txt", wantErr: os.ErrInvalid},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := readEntryFile(t, archive, tc.name)
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("Error diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Content diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestCompressEntryNotDir(t *testing.T) {
+	path := testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "file"), "")
+
+	if _, err := compressEntry(path); !cmp.Equal(err, os.ErrInvalid, cmpopts.EquateErrors()) {
+		t.Errorf("compressEntry() failed with %v, want %v", err, os.ErrInvalid)
+	}
+}
+
+func TestOpenEntryFileDir(t *testing.T) {
+	dir := makeEntry(t, t.TempDir(), time.Now())
+
+	if got, err := readEntryFile(t, dir, "0/command_output.txt"); err != nil {
+		t.Errorf("OpenEntryFile() failed: %v", err)
+	} else if diff := cmp.Diff("output\n", got); diff != "" {
+		t.Errorf("Content diff (-want +got):\n%s", diff)
+	}
+
+	if _, err := readEntryFile(t, dir, "link"); err == nil {
+		t.Errorf("OpenEntryFile() followed symlink")
+	}
+}
+
+func TestJournalCompress(t *testing.T) {
+	cfg := config.HandlerDefaults
+	cfg.Path = t.TempDir()
+	cfg.JournalCompressAfter = time.Hour
+
+	j := New(&cfg)
+
+	old := time.Now().Add(-2 * cfg.JournalRetention)
+
+	var entries []string
+
+	for _, hint := range []string{"first", "second", "active", "recent"} {
+		mtime := old
+
+		if hint == "recent" {
+			mtime = time.Now()
+		}
+
+		// Entry names contain the creation time.
+		restore := uniquename.SetRuntime(uniquename.Runtime{
+			Clock:   clockwork.NewFakeClockAt(mtime),
+			Loc:     uniquename.DefaultRuntime.Loc,
+			RandInt: uniquename.DefaultRuntime.RandInt,
+		})
+
+		path, err := j.CreateTaskDir(hint)
+
+		restore()
+
+		if err != nil {
+			t.Fatalf("CreateTaskDir() failed: %v", err)
+		}
+
+		entries = append(entries, makeEntry(t, path, mtime))
+	}
+
+	if err := j.Compress(context.Background(), zaptest.NewLogger(t), []string{entries[2]}); err != nil {
+		t.Errorf("Compress() failed: %v", err)
+	}
+
+	for idx, i := range entries {
+		if idx < 2 {
+			testutil.MustNotExist(t, i)
+			testutil.MustLstat(t, i+ArchiveSuffix)
+		} else {
+			testutil.MustLstat(t, i)
+			testutil.MustNotExist(t, i+ArchiveSuffix)
+		}
+	}
+
+	if err := j.Prune(context.Background(), zaptest.NewLogger(t)); err != nil {
+		t.Errorf("Prune() failed: %v", err)
+	}
+
+	for _, i := range entries[:2] {
+		testutil.MustNotExist(t, i+ArchiveSuffix)
+	}
+
+	testutil.MustLstat(t, entries[3])
+}
+
+func TestJournalCompressDisabled(t *testing.T) {
+	cfg := config.HandlerDefaults
+	cfg.Path = t.TempDir()
+
+	if err := New(&cfg).Compress(context.Background(), zaptest.NewLogger(t), nil); err != nil {
+		t.Errorf("Compress() failed: %v", err)
+	}
+
+	testutil.MustNotExist(t, filepath.Join(cfg.Path, cfg.JournalDir))
+}
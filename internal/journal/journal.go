@@ -5,16 +5,25 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/dedupindex"
+	"github.com/hansmi/baamhackl/internal/eventbus"
 	"github.com/hansmi/baamhackl/internal/prune"
+	"github.com/hansmi/baamhackl/internal/tracing"
 	"github.com/hansmi/baamhackl/internal/uniquename"
 	"github.com/hansmi/baamhackl/internal/waryio"
+	"go.opentelemetry.io/otel/attribute"
 	"go.uber.org/multierr"
 	"go.uber.org/zap"
 )
 
+// Name of the file within the journal directory storing checksums of
+// processed files.
+const dedupIndexName = ".dedup.json"
+
 type dirOptions struct {
 	path string
 	uniquename.Options
@@ -23,9 +32,15 @@ type dirOptions struct {
 type Journal struct {
 	cfg *config.Handler
 
-	journalDir dirOptions
-	successDir dirOptions
-	failureDir dirOptions
+	journalDir    dirOptions
+	successDir    dirOptions
+	failureDir    dirOptions
+	duplicatesDir dirOptions
+
+	dedupMu    sync.Mutex
+	dedupIndex *dedupindex.Index
+
+	events eventbus.Emitter
 }
 
 func New(cfg *config.Handler) *Journal {
@@ -43,6 +58,10 @@ func New(cfg *config.Handler) *Journal {
 			Options: uniquename.DefaultOptions,
 			path:    cfg.FailureDir,
 		},
+		duplicatesDir: dirOptions{
+			Options: uniquename.DefaultOptions,
+			path:    cfg.DuplicatesDir,
+		},
 	}
 
 	j.journalDir.BeforeExtension = false
@@ -50,6 +69,12 @@ func New(cfg *config.Handler) *Journal {
 	return j
 }
 
+// SetEvents configures the destination for lifecycle events, e.g. about
+// pruned entries.
+func (j *Journal) SetEvents(events eventbus.Emitter) {
+	j.events = events
+}
+
 func (j *Journal) ensureDir(path string) (string, error) {
 	return waryio.EnsureRelDir(j.cfg.Path, path, os.ModePerm)
 }
@@ -78,7 +103,15 @@ func (j *Journal) CreateTaskDir(hint string) (string, error) {
 	return waryio.MakeAvailableDir(g)
 }
 
-func (j *Journal) MoveToArchive(path string, success bool) (string, error) {
+func (j *Journal) MoveToArchive(ctx context.Context, path string, success bool) (_ string, err error) {
+	_, span := tracing.Start(ctx, "journal.MoveToArchive",
+		tracing.PathKey.String(path),
+		attribute.Bool("baamhackl.success", success),
+	)
+	defer func() {
+		tracing.End(span, err)
+	}()
+
 	destDir := j.failureDir
 
 	if success {
@@ -93,6 +126,144 @@ func (j *Journal) MoveToArchive(path string, success bool) (string, error) {
 	return waryio.RenameToAvailableName(path, g)
 }
 
+// MoveToDuplicates moves a file whose content was processed before into the
+// directory for duplicates.
+func (j *Journal) MoveToDuplicates(path string) (string, error) {
+	g, err := j.ensureDirForName(j.duplicatesDir, filepath.Base(path))
+	if err != nil {
+		return "", err
+	}
+
+	return waryio.RenameToAvailableName(path, g)
+}
+
+// DedupEnabled reports whether duplicate files are detected.
+func (j *Journal) DedupEnabled() bool {
+	return j.cfg.DuplicateAction != ""
+}
+
+func (j *Journal) getDedupIndex() (*dedupindex.Index, error) {
+	j.dedupMu.Lock()
+	defer j.dedupMu.Unlock()
+
+	if j.dedupIndex == nil {
+		dir, err := j.ensureDir(j.journalDir.path)
+		if err != nil {
+			return nil, err
+		}
+
+		j.dedupIndex = dedupindex.New(filepath.Join(dir, dedupIndexName), j.cfg.JournalRetention)
+	}
+
+	return j.dedupIndex, nil
+}
+
+// LookupDuplicate returns the journal entry of a previous successful
+// processing of the same content.
+func (j *Journal) LookupDuplicate(checksum []byte) (dedupindex.Entry, bool, error) {
+	idx, err := j.getDedupIndex()
+	if err != nil {
+		return dedupindex.Entry{}, false, err
+	}
+
+	e, ok, err := idx.Lookup(checksum)
+	if err != nil || !ok || !e.Success {
+		return dedupindex.Entry{}, false, err
+	}
+
+	return e, true, nil
+}
+
+// RecordOutcome stores the final outcome of processing content with the given
+// checksum for detecting duplicates.
+func (j *Journal) RecordOutcome(checksum []byte, entry string, success bool) error {
+	idx, err := j.getDedupIndex()
+	if err != nil {
+		return err
+	}
+
+	return idx.Record(checksum, dedupindex.Entry{
+		Journal: entry,
+		Success: success,
+	})
+}
+
+// Compress replaces journal entries older than the configured threshold with
+// compressed archives. Entries listed in active are skipped.
+func (j *Journal) Compress(ctx context.Context, logger *zap.Logger, active []string) error {
+	if j.cfg.JournalCompressAfter <= 0 {
+		return nil
+	}
+
+	deadline := time.Now().Add(-j.cfg.JournalCompressAfter).Truncate(time.Minute)
+
+	dir, err := j.ensureDir(j.journalDir.path)
+	if err != nil {
+		return err
+	}
+
+	skip := map[string]struct{}{}
+
+	for _, i := range active {
+		skip[filepath.Clean(i)] = struct{}{}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	accept := prune.MakeAgeFilter(deadline, j.journalDir.Options)
+
+	logger.Info("Compressing journal",
+		zap.Time("deadline", deadline),
+		zap.String("dir", dir))
+
+	var allErrors error
+
+	for _, entry := range entries {
+		select {
+		case <-ctx.Done():
+			return multierr.Append(allErrors, ctx.Err())
+		default:
+		}
+
+		path := filepath.Join(dir, entry.Name())
+
+		if _, ok := skip[path]; ok || !entry.IsDir() {
+			continue
+		}
+
+		if fi, err := entry.Info(); err != nil {
+			if !os.IsNotExist(err) {
+				multierr.AppendInto(&allErrors, err)
+			}
+			continue
+		} else if !accept(entry.Name(), fi) {
+			continue
+		}
+
+		dest, err := compressEntry(path)
+		if err != nil {
+			multierr.AppendInto(&allErrors, fmt.Errorf("compressing %q failed: %w", path, err))
+			continue
+		}
+
+		logger.Info(fmt.Sprintf("Compressed entry %q", entry.Name()),
+			zap.String("archive", dest))
+	}
+
+	return allErrors
+}
+
+// skipDedupIndex wraps an acceptor function to retain the checksum index. Its
+// entries are pruned individually.
+func skipDedupIndex(accept prune.AcceptFunc) prune.AcceptFunc {
+	return func(name string, fi os.FileInfo) bool {
+		return name != dedupIndexName && accept(name, fi)
+	}
+}
+
 func (j *Journal) Prune(ctx context.Context, logger *zap.Logger) error {
 	deadline := time.Now().Add(-j.cfg.JournalRetention).Truncate(time.Minute)
 
@@ -102,6 +273,10 @@ func (j *Journal) Prune(ctx context.Context, logger *zap.Logger) error {
 		j.failureDir,
 	}
 
+	if j.cfg.DuplicateAction == config.DuplicateActionMove {
+		all = append(all, j.duplicatesDir)
+	}
+
 	var pruners []prune.Pruner
 	var allPaths []string
 
@@ -111,10 +286,22 @@ func (j *Journal) Prune(ctx context.Context, logger *zap.Logger) error {
 			return err
 		}
 
+		accept := prune.MakeAgeFilter(deadline, i.Options)
+
+		if i.path == j.journalDir.path {
+			accept = skipDedupIndex(accept)
+		}
+
 		pruners = append(pruners, prune.Pruner{
 			Dir:    dir,
-			Accept: prune.MakeAgeFilter(deadline, i.Options),
+			Accept: accept,
 			Logger: logger.With(zap.String("dir", dir)),
+			Removed: func(path string) {
+				j.events.Emit(eventbus.Event{
+					Type: eventbus.Pruned,
+					Path: path,
+				})
+			},
 		})
 
 		allPaths = append(allPaths, dir)
@@ -130,5 +317,15 @@ func (j *Journal) Prune(ctx context.Context, logger *zap.Logger) error {
 		multierr.AppendInto(&allErrors, i.Run(ctx))
 	}
 
+	if j.DedupEnabled() {
+		if idx, err := j.getDedupIndex(); err != nil {
+			multierr.AppendInto(&allErrors, err)
+		} else if count, err := idx.Prune(); err != nil {
+			multierr.AppendInto(&allErrors, fmt.Errorf("pruning checksum index failed: %w", err))
+		} else if count > 0 {
+			logger.Info("Pruned checksum index", zap.Int("count", count))
+		}
+	}
+
 	return allErrors
 }
@@ -3,9 +3,15 @@ package journal
 import (
 	"context"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/eventbus"
 	"github.com/hansmi/baamhackl/internal/testutil"
 	"go.uber.org/zap/zaptest"
 )
@@ -23,6 +29,15 @@ func TestJournal(t *testing.T) {
 				return cfg
 			}(),
 		},
+		{
+			name: "duplicates",
+			cfg: func() config.Handler {
+				cfg := config.HandlerDefaults
+				cfg.Path = t.TempDir()
+				cfg.DuplicateAction = config.DuplicateActionMove
+				return cfg
+			}(),
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
 			j := New(&tc.cfg)
@@ -43,3 +58,107 @@ func TestJournal(t *testing.T) {
 		})
 	}
 }
+
+func TestJournalPruneEvents(t *testing.T) {
+	cfg := config.HandlerDefaults
+	cfg.Name = "test"
+	cfg.Path = t.TempDir()
+
+	bus := eventbus.New(0)
+
+	j := New(&cfg)
+	j.SetEvents(eventbus.Emitter{Bus: bus, Handler: cfg.Name})
+
+	if _, err := j.CreateTaskDir("current"); err != nil {
+		t.Fatalf("CreateTaskDir() failed: %v", err)
+	}
+
+	// Names without a timestamp are only subject to the modification time.
+	entry := filepath.Join(cfg.Path, cfg.JournalDir, "old")
+	testutil.MustMkdir(t, entry)
+
+	old := time.Now().Add(-2 * cfg.JournalRetention)
+
+	if err := os.Chtimes(entry, old, old); err != nil {
+		t.Errorf("Chtimes() failed: %v", err)
+	}
+
+	if err := j.Prune(context.Background(), zaptest.NewLogger(t)); err != nil {
+		t.Errorf("Prune() failed: %v", err)
+	}
+
+	testutil.MustNotExist(t, entry)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	events, _, err := bus.Wait(ctx, 0, nil)
+	if err != nil {
+		t.Fatalf("Wait() failed: %v", err)
+	}
+
+	if diff := cmp.Diff([]eventbus.Event{{
+		Seq:     1,
+		Type:    eventbus.Pruned,
+		Handler: cfg.Name,
+		Path:    entry,
+	}}, events, cmpopts.IgnoreFields(eventbus.Event{}, "Time")); diff != "" {
+		t.Errorf("Events diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestJournalDuplicates(t *testing.T) {
+	cfg := config.HandlerDefaults
+	cfg.Path = t.TempDir()
+	cfg.DuplicateAction = config.DuplicateActionSkip
+
+	j := New(&cfg)
+	checksum := []byte{0xab, 0xcd}
+
+	for _, tc := range []struct {
+		entry   string
+		success bool
+		want    bool
+	}{
+		{entry: "first", want: false},
+		{entry: "second", success: true, want: true},
+		{entry: "third", success: true, want: true},
+	} {
+		if err := j.RecordOutcome(checksum, tc.entry, tc.success); err != nil {
+			t.Errorf("RecordOutcome() failed: %v", err)
+		}
+
+		if _, ok, err := j.LookupDuplicate(checksum); err != nil {
+			t.Errorf("LookupDuplicate() failed: %v", err)
+		} else if ok != tc.want {
+			t.Errorf("LookupDuplicate() after %q returned %t, want %t", tc.entry, ok, tc.want)
+		}
+	}
+
+	index := filepath.Join(cfg.Path, cfg.JournalDir, dedupIndexName)
+	old := time.Now().Add(-2 * cfg.JournalRetention)
+
+	if err := os.Chtimes(index, old, old); err != nil {
+		t.Errorf("Chtimes() failed: %v", err)
+	}
+
+	if err := j.Prune(context.Background(), zaptest.NewLogger(t)); err != nil {
+		t.Errorf("Prune() failed: %v", err)
+	}
+
+	if entry, ok, err := New(&cfg).LookupDuplicate(checksum); err != nil {
+		t.Errorf("LookupDuplicate() failed: %v", err)
+	} else if !ok || entry.Journal != "second" {
+		t.Errorf("LookupDuplicate() returned %+v, %t; want first successful entry", entry, ok)
+	}
+
+	src := testutil.MustWriteFile(t, filepath.Join(cfg.Path, "file.txt"), "content")
+
+	if dest, err := j.MoveToDuplicates(src); err != nil {
+		t.Errorf("MoveToDuplicates() failed: %v", err)
+	} else if !strings.HasPrefix(dest, filepath.Join(cfg.Path, cfg.DuplicatesDir)+string(filepath.Separator)) {
+		t.Errorf("MoveToDuplicates() returned %q, want path in duplicates directory", dest)
+	}
+
+	testutil.MustNotExist(t, src)
+}
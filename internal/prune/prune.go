@@ -44,6 +44,9 @@ type Pruner struct {
 	Dir    string
 	Accept AcceptFunc
 
+	// Invoked with the path of each removed entry.
+	Removed func(string)
+
 	fs afero.Fs
 }
 
@@ -81,6 +84,8 @@ loop:
 
 			if err := fs.RemoveAll(path); !(err == nil || os.IsNotExist(err)) {
 				multierr.AppendInto(&resultErr, err)
+			} else if p.Removed != nil {
+				p.Removed(path)
 			}
 		}
 	}
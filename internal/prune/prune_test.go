@@ -159,6 +159,7 @@ func TestPruner(t *testing.T) {
 
 	names := []string{}
 	keep := map[string]bool{}
+	removed := map[string]bool{}
 
 	for i := 0; i < 10; i++ {
 		name := fmt.Sprintf("dir%d", i)
@@ -176,6 +177,9 @@ func TestPruner(t *testing.T) {
 		Accept: func(name string, fi os.FileInfo) bool {
 			return !keep[name]
 		},
+		Removed: func(path string) {
+			removed[filepath.Base(path)] = true
+		},
 
 		fs: fs,
 	}
@@ -185,6 +189,10 @@ func TestPruner(t *testing.T) {
 	}
 
 	for _, name := range names {
+		if diff := cmp.Diff(!keep[name], removed[name]); diff != "" {
+			t.Errorf("Removal report diff for %q (-want +got):\n%s", name, diff)
+		}
+
 		exists, err := afero.DirExists(fs, filepath.Join(tmpdir, name))
 		if err != nil {
 			t.Errorf("DirExists() failed: %v", err)
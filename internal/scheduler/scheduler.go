@@ -273,6 +273,15 @@ func (s *Scheduler) Start() {
 	}()
 }
 
+// Running reports whether the scheduler loop has been started and not yet
+// been asked to stop.
+func (s *Scheduler) Running() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.loopActiveCount > 0 && !s.loopStopRequested
+}
+
 // Quiesce waits until all tasks have been run or the context is cancelled.
 // Tasks are not affected on context cancellation. If tasks are added
 // concurrently the behaviour is unspecified.
@@ -21,8 +21,17 @@ func TestEmpty(t *testing.T) {
 			t.Cleanup(cancel)
 
 			s := New()
+
+			if s.Running() {
+				t.Errorf("Running() returned true before start")
+			}
+
 			s.Start()
 
+			if !s.Running() {
+				t.Errorf("Running() returned false after start")
+			}
+
 			if quiesce {
 				if err := s.Quiesce(ctx); err != nil {
 					t.Errorf("Quiesce() failed: %v", err)
@@ -32,6 +41,10 @@ func TestEmpty(t *testing.T) {
 			if err := s.Stop(ctx); err != nil {
 				t.Errorf("Stop() failed: %v", err)
 			}
+
+			if s.Running() {
+				t.Errorf("Running() returned true after stop")
+			}
 		})
 	}
 }
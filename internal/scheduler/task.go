@@ -66,7 +66,12 @@ func (t *Task) run(ctx context.Context) bool {
 				zap.Duration("retry_delay", te.RetryDelay),
 				zap.Time("retry_after", t.nextAfter),
 			)
-			logger.Error("Task failed and will be attempted again", logFields...)
+			if te.Deferred {
+				t.attemptCount--
+				logger.Debug("Task deferred", logFields...)
+			} else {
+				logger.Error("Task failed and will be attempted again", logFields...)
+			}
 			return false
 		}
 	}
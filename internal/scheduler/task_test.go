@@ -39,10 +39,12 @@ func TestTaskRun(t *testing.T) {
 		err          error
 		wantFinished bool
 		wantAfter    time.Time
+		wantAttempts int
 	}{
 		{
 			name:         "success",
 			wantFinished: true,
+			wantAttempts: 1,
 		},
 		{
 			name: "no backoff",
@@ -50,7 +52,8 @@ func TestTaskRun(t *testing.T) {
 				Err:        errTest,
 				RetryDelay: 0,
 			},
-			wantAfter: fc.Now(),
+			wantAfter:    fc.Now(),
+			wantAttempts: 1,
 		},
 		{
 			name: "one minute",
@@ -58,6 +61,16 @@ func TestTaskRun(t *testing.T) {
 				Err:        errTest,
 				RetryDelay: time.Minute,
 			},
+			wantAfter:    fc.Now().Add(time.Minute),
+			wantAttempts: 1,
+		},
+		{
+			name: "deferred",
+			err: &TaskError{
+				Err:        errTest,
+				RetryDelay: time.Minute,
+				Deferred:   true,
+			},
 			wantAfter: fc.Now().Add(time.Minute),
 		},
 		{
@@ -67,11 +80,13 @@ func TestTaskRun(t *testing.T) {
 				RetryDelay: Stop,
 			},
 			wantFinished: true,
+			wantAttempts: 1,
 		},
 		{
 			name:         "permanent, plain error",
 			err:          errTest,
 			wantFinished: true,
+			wantAttempts: 1,
 		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
@@ -84,6 +99,10 @@ func TestTaskRun(t *testing.T) {
 			if diff := cmp.Diff(tc.wantAfter, task.nextAfter, cmpopts.EquateApproxTime(time.Millisecond)); diff != "" {
 				t.Errorf("NextAfter diff (-want +got):\n%s", diff)
 			}
+
+			if diff := cmp.Diff(tc.wantAttempts, task.attemptCount); diff != "" {
+				t.Errorf("Attempt count diff (-want +got):\n%s", diff)
+			}
 		})
 	}
 }
@@ -15,6 +15,10 @@ type TaskError struct {
 	// Delay before re-running the task. Use a negative value to make the error
 	// permanent (i.e. don't schedule a retry).
 	RetryDelay time.Duration
+
+	// Whether the task was deferred without doing any work, e.g. due to
+	// a temporary resource shortage. Deferrals don't count as attempts.
+	Deferred bool
 }
 
 var _ error = (*TaskError)(nil)
@@ -4,7 +4,9 @@ import (
 	"io"
 	"net"
 	"net/rpc"
+	"time"
 
+	"github.com/hansmi/baamhackl/internal/eventbus"
 	"github.com/hansmi/baamhackl/internal/unixserver"
 	"github.com/hansmi/baamhackl/internal/watchman"
 )
@@ -18,8 +20,32 @@ type FileChangedRequest struct {
 type FileChangedResponse struct {
 }
 
+// EventsRequest asks for lifecycle events. The call returns once at least one
+// matching event is available or the timeout expires.
+type EventsRequest struct {
+	// Return events with a larger sequence number.
+	After uint64
+
+	// Ignore After and only return events published after the request.
+	Tail bool
+
+	// Restrict events to the given handlers. Empty for all handlers.
+	Handlers []string
+
+	// Maximum amount of time to wait for events.
+	Timeout time.Duration
+}
+
+type EventsResponse struct {
+	Events []eventbus.Event
+
+	// Value for EventsRequest.After in the next request.
+	Next uint64
+}
+
 type Callbacks interface {
 	FileChanged(FileChangedRequest) error
+	Events(EventsRequest) (EventsResponse, error)
 }
 
 type serviceFunctions struct {
@@ -30,6 +56,11 @@ func (s *serviceFunctions) FileChanged(req FileChangedRequest, _ *FileChangedRes
 	return s.cb.FileChanged(req)
 }
 
+func (s *serviceFunctions) Events(req EventsRequest, resp *EventsResponse) (err error) {
+	*resp, err = s.cb.Events(req)
+	return err
+}
+
 func newService(cb Callbacks) (*rpc.Server, error) {
 	srv := rpc.NewServer()
 
@@ -0,0 +1,152 @@
+// Package tracing configures OpenTelemetry tracing and provides helpers for
+// instrumenting the processing of changed files.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/multierr"
+)
+
+const instrumentationName = "github.com/hansmi/baamhackl"
+
+// Attribute keys used on spans.
+const (
+	HandlerKey  = attribute.Key("baamhackl.handler")
+	FileKey     = attribute.Key("baamhackl.file")
+	AttemptKey  = attribute.Key("baamhackl.attempt")
+	ExitCodeKey = attribute.Key("process.exit.code")
+	PathKey     = attribute.Key("baamhackl.path")
+)
+
+var propagator = propagation.TraceContext{}
+
+// Start creates a span as a child of the span in the context, if any.
+// Without a configured exporter the span doesn't record anything.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End marks the span as failed if err is not nil and ends it.
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// Environ returns environment variables propagating the span in the context
+// to child processes, i.e. TRACEPARENT and, if set, TRACESTATE. Nothing is
+// returned if the context has no valid span.
+func Environ(ctx context.Context) []string {
+	carrier := propagation.MapCarrier{}
+
+	propagator.Inject(ctx, carrier)
+
+	var result []string
+
+	for _, key := range []string{"traceparent", "tracestate"} {
+		if value := carrier.Get(key); value != "" {
+			result = append(result, strings.ToUpper(key)+"="+value)
+		}
+	}
+
+	return result
+}
+
+// Options configures the destination for spans.
+type Options struct {
+	// URL of an OTLP/HTTP collector, e.g. "http://localhost:4318".
+	OTLPEndpoint string
+
+	// Path to a file receiving spans as newline-delimited JSON.
+	File string
+}
+
+// Setup installs a global tracer provider exporting spans to the configured
+// destinations. Tracing stays disabled if none are configured. The returned
+// function flushes outstanding spans and releases resources.
+func Setup(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	var exporters []sdktrace.SpanExporter
+	var closers []func() error
+
+	cleanup := func() {
+		for _, exp := range exporters {
+			exp.Shutdown(ctx)
+		}
+
+		for _, fn := range closers {
+			fn()
+		}
+	}
+
+	if opts.OTLPEndpoint != "" {
+		exp, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(opts.OTLPEndpoint))
+		if err != nil {
+			return nil, fmt.Errorf("OTLP exporter: %w", err)
+		}
+
+		exporters = append(exporters, exp)
+	}
+
+	if opts.File != "" {
+		fh, err := os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o666)
+		if err != nil {
+			cleanup()
+			return nil, err
+		}
+
+		closers = append(closers, fh.Close)
+
+		exp, err := stdouttrace.New(stdouttrace.WithWriter(fh))
+		if err != nil {
+			cleanup()
+			return nil, fmt.Errorf("file exporter: %w", err)
+		}
+
+		exporters = append(exporters, exp)
+	}
+
+	if len(exporters) == 0 {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	providerOpts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", "baamhackl"),
+		)),
+	}
+
+	for _, exp := range exporters {
+		providerOpts = append(providerOpts, sdktrace.WithBatcher(exp))
+	}
+
+	tp := sdktrace.NewTracerProvider(providerOpts...)
+
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagator)
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+
+		for _, fn := range closers {
+			multierr.AppendInto(&err, fn())
+		}
+
+		return err
+	}, nil
+}
@@ -0,0 +1,149 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var errTest = errors.New("test error")
+
+// replaceTracerProvider installs a tracer provider for the duration of a test.
+func replaceTracerProvider(t *testing.T, tp trace.TracerProvider) {
+	t.Helper()
+
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prev)
+	})
+}
+
+func TestEnviron(t *testing.T) {
+	if got := Environ(context.Background()); len(got) != 0 {
+		t.Errorf("Environ() without span returned %q", got)
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	replaceTracerProvider(t, sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	ctx, span := Start(context.Background(), "test")
+	defer span.End()
+
+	sc := span.SpanContext()
+
+	want := []string{
+		"TRACEPARENT=00-" + sc.TraceID().String() + "-" + sc.SpanID().String() + "-01",
+	}
+
+	if diff := cmp.Diff(want, Environ(ctx)); diff != "" {
+		t.Errorf("Environ() diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestStartEnd(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	replaceTracerProvider(t, sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	ctx, parent := Start(context.Background(), "parent", FileKey.String("file.txt"))
+	_, child := Start(ctx, "child")
+
+	End(child, errTest)
+	End(parent, nil)
+
+	spans := recorder.Ended()
+
+	if len(spans) != 2 {
+		t.Fatalf("Expected two spans, got %d", len(spans))
+	}
+
+	if diff := cmp.Diff(parent.SpanContext().SpanID(), spans[0].Parent().SpanID()); diff != "" {
+		t.Errorf("Parent span diff (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(codes.Error, spans[0].Status().Code); diff != "" {
+		t.Errorf("Child status diff (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(codes.Unset, spans[1].Status().Code); diff != "" {
+		t.Errorf("Parent status diff (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff("file.txt", spans[1].Attributes()[0].Value.AsString()); diff != "" {
+		t.Errorf("Attribute diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestSetupDisabled(t *testing.T) {
+	replaceTracerProvider(t, otel.GetTracerProvider())
+
+	shutdown, err := Setup(context.Background(), Options{})
+	if err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown() failed: %v", err)
+	}
+}
+
+func TestSetupFile(t *testing.T) {
+	replaceTracerProvider(t, otel.GetTracerProvider())
+
+	path := filepath.Join(t.TempDir(), "spans.json")
+
+	shutdown, err := Setup(context.Background(), Options{File: path})
+	if err != nil {
+		t.Fatalf("Setup() failed: %v", err)
+	}
+
+	_, span := Start(context.Background(), "test span")
+	span.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Errorf("shutdown() failed: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile() failed: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+
+	if len(lines) != 1 {
+		t.Fatalf("Expected one line, got %q", content)
+	}
+
+	var got struct {
+		Name string
+	}
+
+	if err := json.Unmarshal([]byte(lines[0]), &got); err != nil {
+		t.Errorf("Unmarshal() failed: %v", err)
+	}
+
+	if diff := cmp.Diff("test span", got.Name); diff != "" {
+		t.Errorf("Span name diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestSetupBadFile(t *testing.T) {
+	if _, err := Setup(context.Background(), Options{
+		File: filepath.Join(t.TempDir(), "missing", "spans.json"),
+	}); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Setup() returned %v, want ErrNotExist", err)
+	}
+}
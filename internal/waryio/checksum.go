@@ -0,0 +1,25 @@
+package waryio
+
+import (
+	"hash"
+	"io"
+	"os"
+	"syscall"
+)
+
+// ChecksumFile writes the content of the file at path to h and returns the
+// resulting checksum. Symlinks are not followed.
+func ChecksumFile(path string, h hash.Hash) ([]byte, error) {
+	fh, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fh.Close()
+
+	if _, err := io.Copy(h, fh); err != nil {
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
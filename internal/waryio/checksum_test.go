@@ -0,0 +1,52 @@
+package waryio
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hansmi/baamhackl/internal/testutil"
+)
+
+func TestChecksumFile(t *testing.T) {
+	tmpdir := t.TempDir()
+
+	for _, tc := range []struct {
+		name    string
+		path    string
+		want    string
+		wantErr error
+	}{
+		{
+			name: "empty",
+			path: testutil.MustWriteFile(t, filepath.Join(tmpdir, "empty"), ""),
+			want: "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		},
+		{
+			name: "content",
+			path: testutil.MustWriteFile(t, filepath.Join(tmpdir, "content"), "hello\n"),
+			want: "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03",
+		},
+		{
+			name:    "missing",
+			path:    filepath.Join(tmpdir, "missing"),
+			wantErr: os.ErrNotExist,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := ChecksumFile(tc.path, sha256.New())
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("Error diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want, hex.EncodeToString(got)); err == nil && diff != "" {
+				t.Errorf("Checksum diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
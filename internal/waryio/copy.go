@@ -2,9 +2,12 @@ package waryio
 
 import (
 	"fmt"
+	"hash"
 	"io"
 	"os"
 	"syscall"
+
+	"go.uber.org/multierr"
 )
 
 type sourceReader interface {
@@ -18,7 +21,7 @@ func copyInner(src sourceReader, dest io.Writer) (os.FileMode, error) {
 		return 0, err
 	}
 
-	copiedBytes, err := io.Copy(dest, src)
+	copiedBytes, err := copyData(dest, src)
 	if err != nil {
 		return 0, err
 	}
@@ -46,6 +49,14 @@ type CopyOptions struct {
 	DestFlags int
 	DestMode  os.FileMode
 	DestSync  bool
+
+	// If set, all copied data is also written to the hash. Accelerated
+	// copies are not used as the data needs to pass through user space.
+	Hash hash.Hash
+
+	// Always copy data through user space instead of using reflinks or
+	// in-kernel copies. Used by benchmarks.
+	streamOnly bool
 }
 
 var DefaultCopyOptions = CopyOptions{
@@ -57,10 +68,16 @@ var DefaultCopyOptions = CopyOptions{
 }
 
 // Copy creates an exact file copy. The operation fails if the source file is
-// modified concurrently.
+// modified concurrently. Where supported by the filesystem the copy shares
+// data blocks with the source (reflink) or is made within the kernel.
+//
+// If the destination flags contain O_CREATE and O_EXCL an incomplete copy is
+// removed on failure.
 func Copy(opts CopyOptions) (err error) {
 	var src, dest *os.File
 
+	exclusive := opts.DestFlags&(os.O_CREATE|os.O_EXCL) == os.O_CREATE|os.O_EXCL
+
 	defer func() {
 		for _, fh := range []*os.File{src, dest} {
 			if fh == nil {
@@ -71,6 +88,10 @@ func Copy(opts CopyOptions) (err error) {
 				err = closeErr
 			}
 		}
+
+		if err != nil && exclusive && dest != nil {
+			multierr.AppendInto(&err, os.Remove(opts.DestPath))
+		}
 	}()
 
 	src, err = os.OpenFile(opts.SourcePath, opts.SourceFlags, opts.SourceMode)
@@ -83,7 +104,20 @@ func Copy(opts CopyOptions) (err error) {
 		return err
 	}
 
-	if sourcePerm, err := copyInner(src, dest); err != nil {
+	var srcReader sourceReader = src
+	var destWriter io.Writer = dest
+
+	if opts.streamOnly {
+		// Hide the concrete types to prevent accelerated copies.
+		srcReader = struct{ sourceReader }{src}
+		destWriter = struct{ io.Writer }{dest}
+	}
+
+	if opts.Hash != nil {
+		destWriter = io.MultiWriter(destWriter, opts.Hash)
+	}
+
+	if sourcePerm, err := copyInner(srcReader, destWriter); err != nil {
 		return err
 	} else if opts.SourcePermPreserve {
 		if err := dest.Chmod(sourcePerm); err != nil {
@@ -1,6 +1,7 @@
 package waryio
 
 import (
+	"crypto/sha256"
 	"io"
 	"os"
 	"path/filepath"
@@ -9,6 +10,7 @@ import (
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/hansmi/baamhackl/internal/testutil"
 )
 
 type fakeSourceReader struct {
@@ -115,3 +117,58 @@ func TestCopyInner(t *testing.T) {
 		})
 	}
 }
+
+func TestCopyHash(t *testing.T) {
+	content := strings.Repeat("Hash content\n", 1024)
+
+	opts := DefaultCopyOptions
+	opts.SourcePath = testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "src"), content)
+	opts.DestPath = filepath.Join(t.TempDir(), "dest")
+	opts.Hash = sha256.New()
+
+	if err := Copy(opts); err != nil {
+		t.Errorf("Copy() failed: %v", err)
+	}
+
+	want := sha256.Sum256([]byte(content))
+
+	if diff := cmp.Diff(want[:], opts.Hash.Sum(nil)); diff != "" {
+		t.Errorf("Checksum diff (-want +got):\n%s", diff)
+	}
+
+	if got, err := os.ReadFile(opts.DestPath); err != nil {
+		t.Errorf("ReadFile() failed: %v", err)
+	} else if diff := cmp.Diff(content, string(got)); diff != "" {
+		t.Errorf("Content diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestCopyExclusiveRemovesIncomplete(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		flags      int
+		wantRemain bool
+	}{
+		{name: "exclusive", flags: os.O_CREATE | os.O_EXCL},
+		{name: "truncate", flags: os.O_CREATE | os.O_TRUNC, wantRemain: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			opts := DefaultCopyOptions
+			opts.SourcePath = t.TempDir()
+			opts.DestPath = filepath.Join(t.TempDir(), "dest")
+			opts.DestFlags = os.O_WRONLY | tc.flags
+
+			// Reading from a directory fails after the destination was
+			// created.
+			if err := Copy(opts); err == nil {
+				t.Errorf("Copy() succeeded")
+			}
+
+			if tc.wantRemain {
+				testutil.MustLstat(t, opts.DestPath)
+			} else {
+				testutil.MustNotExist(t, opts.DestPath)
+			}
+		})
+	}
+}
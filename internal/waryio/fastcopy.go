@@ -0,0 +1,98 @@
+package waryio
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Maximum number of bytes to copy per copy_file_range(2) call.
+const copyFileRangeChunkSize = 1 << 30
+
+// fastCopyUnsupported returns whether an error indicates that a file or
+// filesystem doesn't support an accelerated copy operation.
+func fastCopyUnsupported(err error) bool {
+	for _, i := range []error{
+		unix.EBADF,
+		unix.EINVAL,
+		unix.ENOSYS,
+		unix.ENOTTY,
+		unix.EOPNOTSUPP,
+		unix.EPERM,
+		unix.EXDEV,
+	} {
+		if errors.Is(err, i) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cloneFile makes dest share the data blocks of src (also known as
+// a "reflink"). Only some filesystems, e.g. Btrfs and XFS, support cloning.
+func cloneFile(dest, src *os.File) (int64, error) {
+	if err := unix.IoctlFileClone(int(dest.Fd()), int(src.Fd())); err != nil {
+		return 0, &os.LinkError{Op: "ficlone", Old: src.Name(), New: dest.Name(), Err: err}
+	}
+
+	fi, err := dest.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	return fi.Size(), nil
+}
+
+// copyFileRange copies the data from the current offset of src to dest
+// within the kernel.
+func copyFileRange(dest, src *os.File) (int64, error) {
+	var total int64
+
+	for {
+		n, err := unix.CopyFileRange(int(src.Fd()), nil, int(dest.Fd()), nil, copyFileRangeChunkSize, 0)
+		if err != nil {
+			if errors.Is(err, unix.EINTR) {
+				continue
+			}
+
+			return total, &os.LinkError{Op: "copy_file_range", Old: src.Name(), New: dest.Name(), Err: err}
+		}
+
+		if n == 0 {
+			return total, nil
+		}
+
+		total += int64(n)
+	}
+}
+
+// copyData copies all data from src to dest. If both are files a reflink or
+// an in-kernel copy is attempted first before falling back to copying through
+// user space.
+func copyData(dest io.Writer, src io.Reader) (int64, error) {
+	destFile, destOK := dest.(*os.File)
+	srcFile, srcOK := src.(*os.File)
+
+	if !(destOK && srcOK) {
+		return io.Copy(dest, src)
+	}
+
+	if n, err := cloneFile(destFile, srcFile); err == nil {
+		return n, nil
+	} else if !fastCopyUnsupported(err) {
+		return 0, err
+	}
+
+	n, err := copyFileRange(destFile, srcFile)
+	if err == nil || !fastCopyUnsupported(err) {
+		return n, err
+	}
+
+	// Continue where the in-kernel copy stopped.
+	rest, err := io.Copy(dest, src)
+
+	return n + rest, err
+}
@@ -0,0 +1,189 @@
+package waryio
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hansmi/baamhackl/internal/testutil"
+	"golang.org/x/sys/unix"
+)
+
+// copyTestDirs returns directories on different filesystems, if available.
+func copyTestDirs(tb testing.TB) map[string]string {
+	tb.Helper()
+
+	dirs := map[string]string{
+		"tempdir": tb.TempDir(),
+	}
+
+	if st, err := os.Stat("/dev/shm"); err == nil && st.IsDir() && unix.Access("/dev/shm", unix.W_OK) == nil {
+		if dir, err := os.MkdirTemp("/dev/shm", "waryio*"); err == nil {
+			tb.Cleanup(func() {
+				os.RemoveAll(dir)
+			})
+
+			dirs["shm"] = dir
+		}
+	}
+
+	return dirs
+}
+
+func TestFastCopyUnsupported(t *testing.T) {
+	for _, tc := range []struct {
+		err  error
+		want bool
+	}{
+		{err: io.EOF},
+		{err: unix.EIO},
+		{err: unix.EXDEV, want: true},
+		{err: unix.EOPNOTSUPP, want: true},
+		{err: &os.LinkError{Err: unix.EINVAL}, want: true},
+	} {
+		t.Run(fmt.Sprint(tc.err), func(t *testing.T) {
+			if got := fastCopyUnsupported(tc.err); got != tc.want {
+				t.Errorf("fastCopyUnsupported(%v) returned %t, want %t", tc.err, got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCopyData(t *testing.T) {
+	content := strings.Repeat("Test content\n", 64*1024)
+
+	for name, dir := range copyTestDirs(t) {
+		t.Run(name, func(t *testing.T) {
+			src, err := os.Open(testutil.MustWriteFile(t, filepath.Join(dir, "src"), content))
+			if err != nil {
+				t.Fatalf("Open() failed: %v", err)
+			}
+
+			defer src.Close()
+
+			for _, partial := range []int64{0, 7, 4096} {
+				t.Run(fmt.Sprint(partial), func(t *testing.T) {
+					if _, err := src.Seek(partial, io.SeekStart); err != nil {
+						t.Errorf("Seek() failed: %v", err)
+					}
+
+					dest, err := os.CreateTemp(t.TempDir(), "")
+					if err != nil {
+						t.Fatalf("CreateTemp() failed: %v", err)
+					}
+
+					defer dest.Close()
+
+					if partial != 0 {
+						// Reflinks always cover the whole file.
+						if _, err := copyFileRange(dest, src); err != nil && !fastCopyUnsupported(err) {
+							t.Errorf("copyFileRange() failed: %v", err)
+						}
+
+						if _, err := io.Copy(dest, src); err != nil {
+							t.Errorf("Copy() failed: %v", err)
+						}
+					} else if n, err := copyData(dest, src); err != nil {
+						t.Errorf("copyData() failed: %v", err)
+					} else if n != int64(len(content)) {
+						t.Errorf("copyData() copied %d bytes, want %d", n, len(content))
+					}
+
+					got, err := os.ReadFile(dest.Name())
+					if err != nil {
+						t.Errorf("ReadFile() failed: %v", err)
+					}
+
+					if diff := cmp.Diff(content[partial:], string(got)); diff != "" {
+						t.Errorf("Content diff (-want +got):\n%s", diff)
+					}
+				})
+			}
+		})
+	}
+}
+
+func TestCopy(t *testing.T) {
+	content := strings.Repeat("abc\n", 1024*1024)
+
+	for name, dir := range copyTestDirs(t) {
+		for _, streamOnly := range []bool{false, true} {
+			t.Run(fmt.Sprintf("%s/%t", name, streamOnly), func(t *testing.T) {
+				opts := DefaultCopyOptions
+				opts.SourcePath = testutil.MustWriteFile(t, filepath.Join(dir, fmt.Sprintf("src%t", streamOnly)), content)
+				opts.DestPath = filepath.Join(t.TempDir(), "dest")
+				opts.DestFlags |= os.O_EXCL
+				opts.DestSync = true
+				opts.streamOnly = streamOnly
+
+				if err := os.Chmod(opts.SourcePath, 0o604); err != nil {
+					t.Errorf("Chmod() failed: %v", err)
+				}
+
+				if err := Copy(opts); err != nil {
+					t.Errorf("Copy() failed: %v", err)
+				}
+
+				if got, err := os.ReadFile(opts.DestPath); err != nil {
+					t.Errorf("ReadFile() failed: %v", err)
+				} else if !bytes.Equal([]byte(content), got) {
+					t.Errorf("Copied content differs")
+				}
+
+				if st := testutil.MustLstat(t, opts.DestPath); st.Mode().Perm() != 0o604 {
+					t.Errorf("Got permissions %04o, want %04o", st.Mode().Perm(), 0o604)
+				}
+
+				if err := Copy(opts); !os.IsExist(err) {
+					t.Errorf("Copy() onto existing file returned %v, want %v", err, os.ErrExist)
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkCopy(b *testing.B) {
+	const size = 64 * 1024 * 1024
+
+	content := make([]byte, size)
+	rand.New(rand.NewSource(0)).Read(content)
+
+	for name, dir := range copyTestDirs(b) {
+		srcPath := filepath.Join(dir, "src")
+
+		if err := os.WriteFile(srcPath, content, 0o600); err != nil {
+			b.Fatalf("WriteFile() failed: %v", err)
+		}
+
+		for _, streamOnly := range []bool{false, true} {
+			method := "auto"
+			if streamOnly {
+				method = "stream"
+			}
+
+			b.Run(name+"/"+method, func(b *testing.B) {
+				destDir := b.TempDir()
+
+				opts := DefaultCopyOptions
+				opts.SourcePath = srcPath
+				opts.DestPath = filepath.Join(destDir, "dest")
+				opts.streamOnly = streamOnly
+
+				b.SetBytes(size)
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					if err := Copy(opts); err != nil {
+						b.Fatalf("Copy() failed: %v", err)
+					}
+				}
+			})
+		}
+	}
+}
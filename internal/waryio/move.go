@@ -0,0 +1,132 @@
+package waryio
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"go.uber.org/multierr"
+	"golang.org/x/sys/unix"
+)
+
+// MoveToAvailableName works like RenameToAvailableName, but if oldpath is
+// a regular file on another filesystem than the destination it's copied
+// instead. See copyToAvailableName for details.
+func MoveToAvailableName(oldpath string, g StringIter) (string, error) {
+	first, ok := g.Next()
+	if !ok {
+		return "", ErrIterExhausted
+	}
+
+	err := RenameWithoutReplace(oldpath, first)
+
+	switch {
+	case err == nil:
+		return first, nil
+
+	case errors.Is(err, unix.EXDEV):
+		if fi, statErr := os.Lstat(oldpath); statErr != nil || !fi.Mode().IsRegular() {
+			return "", err
+		}
+
+		return copyToAvailableName(oldpath, first, g)
+
+	case os.IsExist(err):
+		return RenameToAvailableName(oldpath, g)
+	}
+
+	return "", err
+}
+
+func syncDir(path string) error {
+	fh, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	return multierr.Combine(fh.Sync(), fh.Close())
+}
+
+// copyToAvailableName copies the regular file at oldpath to a temporary file
+// in the directory of first and verifies its content. The copy is then
+// renamed to first or another path produced by g without replacing existing
+// files. The source file is removed only after the copy is in place.
+func copyToAvailableName(oldpath, first string, g StringIter) (dest string, err error) {
+	fi, err := os.Lstat(oldpath)
+	if err != nil {
+		return "", err
+	}
+
+	tmpfile, err := os.CreateTemp(filepath.Dir(first), ".move*")
+	if err != nil {
+		return "", err
+	}
+
+	tmpname := tmpfile.Name()
+
+	if err := tmpfile.Close(); err != nil {
+		return "", err
+	}
+
+	defer func() {
+		if dest == "" {
+			multierr.AppendInto(&err, os.Remove(tmpname))
+		}
+	}()
+
+	h := sha256.New()
+
+	opts := DefaultCopyOptions
+	opts.SourcePath = oldpath
+	opts.DestPath = tmpname
+	opts.DestFlags &^= os.O_CREATE
+	opts.DestSync = true
+	opts.Hash = h
+
+	if err := Copy(opts); err != nil {
+		return "", err
+	}
+
+	if got, err := ChecksumFile(tmpname, sha256.New()); err != nil {
+		return "", err
+	} else if want := h.Sum(nil); !bytes.Equal(want, got) {
+		return "", fmt.Errorf("%w: copy differs from source (SHA-256 %x != %x)", ErrFileChanged, want, got)
+	}
+
+	if err := os.Chtimes(tmpname, fi.ModTime(), fi.ModTime()); err != nil {
+		return "", err
+	}
+
+	if after, err := os.Lstat(oldpath); err != nil {
+		return "", err
+	} else if err := DescribeChanges(fi, after).Err(); err != nil {
+		return "", fmt.Errorf("source was modified: %w", err)
+	}
+
+	for path, ok := first, true; ok; path, ok = g.Next() {
+		if err = RenameWithoutReplace(tmpname, path); err == nil {
+			dest = path
+			break
+		} else if !os.IsExist(err) {
+			return "", err
+		}
+	}
+
+	if dest == "" {
+		// All names are taken.
+		return "", err
+	}
+
+	if err := syncDir(filepath.Dir(dest)); err != nil {
+		return dest, fmt.Errorf("syncing directory failed: %w", err)
+	}
+
+	if err := os.Remove(oldpath); err != nil {
+		return dest, fmt.Errorf("removing source after copy failed: %w", err)
+	}
+
+	return dest, nil
+}
@@ -0,0 +1,143 @@
+package waryio
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hansmi/baamhackl/internal/testutil"
+	"golang.org/x/sys/unix"
+)
+
+func TestCopyToAvailableName(t *testing.T) {
+	tmpdir := t.TempDir()
+	mtime := time.Date(2020, time.March, 4, 5, 6, 7, 0, time.UTC)
+
+	source := testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "source"), "content")
+
+	if err := os.Chmod(source, 0o640); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.Chtimes(source, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	first := testutil.MustWriteFile(t, filepath.Join(tmpdir, "first"), "existing")
+
+	got, err := copyToAvailableName(source, first, &iterSlice{filepath.Join(tmpdir, "second")})
+	if err != nil {
+		t.Fatalf("copyToAvailableName() failed: %v", err)
+	}
+
+	if diff := cmp.Diff(filepath.Join(tmpdir, "second"), got); diff != "" {
+		t.Errorf("Destination diff (-want +got):\n%s", diff)
+	}
+
+	testutil.MustNotExist(t, source)
+
+	if st := testutil.MustLstat(t, got); !st.ModTime().Equal(mtime) {
+		t.Errorf("Modification time is %v, want %v", st.ModTime(), mtime)
+	} else if diff := cmp.Diff(os.FileMode(0o640), st.Mode()); diff != "" {
+		t.Errorf("Mode diff (-want +got):\n%s", diff)
+	}
+
+	for path, want := range map[string]string{
+		first: "existing",
+		got:   "content",
+	} {
+		if content, err := os.ReadFile(path); err != nil {
+			t.Errorf("ReadFile() failed: %v", err)
+		} else if diff := cmp.Diff(want, string(content)); diff != "" {
+			t.Errorf("Content of %q diff (-want +got):\n%s", path, diff)
+		}
+	}
+
+	if entries, err := os.ReadDir(tmpdir); err != nil {
+		t.Errorf("ReadDir() failed: %v", err)
+	} else if len(entries) != 2 {
+		t.Errorf("Temporary file was not removed: %v", entries)
+	}
+}
+
+func TestCopyToAvailableNameExists(t *testing.T) {
+	tmpdir := t.TempDir()
+	source := testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "source"), "content")
+	first := testutil.MustWriteFile(t, filepath.Join(tmpdir, "first"), "")
+
+	_, err := copyToAvailableName(source, first, &iterSlice{first})
+
+	if diff := cmp.Diff(os.ErrExist, err, cmpopts.EquateErrors()); diff != "" {
+		t.Errorf("Error diff (-want +got):\n%s", diff)
+	}
+
+	testutil.MustLstat(t, source)
+
+	if entries, err := os.ReadDir(tmpdir); err != nil {
+		t.Errorf("ReadDir() failed: %v", err)
+	} else if len(entries) != 1 {
+		t.Errorf("Temporary file was not removed: %v", entries)
+	}
+}
+
+func TestMoveToAvailableNameCrossDevice(t *testing.T) {
+	dirs := copyTestDirs(t)
+
+	if _, ok := dirs["shm"]; !ok {
+		t.Skip("No second filesystem available")
+	}
+
+	var srcStat, destStat unix.Stat_t
+
+	if err := unix.Stat(dirs["tempdir"], &srcStat); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := unix.Stat(dirs["shm"], &destStat); err != nil {
+		t.Fatal(err)
+	}
+
+	if srcStat.Dev == destStat.Dev {
+		t.Skip("Directories are on the same filesystem")
+	}
+
+	source := testutil.MustWriteFile(t, filepath.Join(dirs["tempdir"], "file"), "content")
+	want := filepath.Join(dirs["shm"], "file")
+
+	if got, err := MoveToAvailableName(source, &iterSlice{want}); err != nil {
+		t.Errorf("MoveToAvailableName() failed: %v", err)
+	} else if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Destination diff (-want +got):\n%s", diff)
+	}
+
+	testutil.MustNotExist(t, source)
+
+	// Directories are not copied.
+	sourceDir := testutil.MustMkdir(t, filepath.Join(dirs["tempdir"], "dir"))
+
+	_, err := MoveToAvailableName(sourceDir, &iterSlice{filepath.Join(dirs["shm"], "dir")})
+
+	if diff := cmp.Diff(unix.EXDEV, err, cmpopts.EquateErrors()); diff != "" {
+		t.Errorf("Error diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestMoveToAvailableName(t *testing.T) {
+	tmpdir := t.TempDir()
+	source := testutil.MustWriteFile(t, filepath.Join(tmpdir, "source"), "")
+	existing := testutil.MustWriteFile(t, filepath.Join(tmpdir, "existing"), "")
+	want := filepath.Join(tmpdir, "dest")
+
+	if got, err := MoveToAvailableName(source, &iterSlice{existing, want}); err != nil {
+		t.Errorf("MoveToAvailableName() failed: %v", err)
+	} else if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("Destination diff (-want +got):\n%s", diff)
+	}
+
+	if _, err := MoveToAvailableName(want, &iterSlice{}); !cmp.Equal(err, ErrIterExhausted, cmpopts.EquateErrors()) {
+		t.Errorf("MoveToAvailableName() failed with %v, want %v", err, ErrIterExhausted)
+	}
+}
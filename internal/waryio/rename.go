@@ -6,9 +6,9 @@ import (
 	"golang.org/x/sys/unix"
 )
 
-// renameWithoutReplace renames oldpath to newpath without replacing a file
+// RenameWithoutReplace renames oldpath to newpath without replacing a file
 // which may already exist at newpath.
-func renameWithoutReplace(oldpath, newpath string) error {
+func RenameWithoutReplace(oldpath, newpath string) error {
 	return unix.Renameat2(unix.AT_FDCWD, oldpath, unix.AT_FDCWD, newpath, unix.RENAME_NOREPLACE)
 }
 
@@ -16,7 +16,7 @@ func renameWithoutReplace(oldpath, newpath string) error {
 // The used destination path is returned.
 func RenameToAvailableName(oldpath string, g StringIter) (string, error) {
 	for path, ok := g.Next(); ok; {
-		err := renameWithoutReplace(oldpath, path)
+		err := RenameWithoutReplace(oldpath, path)
 		if err == nil {
 			return path, nil
 		}
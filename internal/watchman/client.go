@@ -24,6 +24,7 @@ type Client interface {
 	Recrawl(ctx context.Context, root string) error
 	TriggerSet(ctx context.Context, root string, args any) error
 	TriggerDel(ctx context.Context, root, name string) error
+	TriggerList(ctx context.Context, root string) ([]map[string]any, error)
 	ShutdownServer(ctx context.Context) error
 }
 
@@ -40,7 +41,9 @@ func defaultClientCommand(base []string) []string {
 	)
 }
 
-func runClient(ctx context.Context, args []string, input any) (err error) {
+// runClient executes a Watchman client command. If output is not nil the JSON
+// response is decoded into it.
+func runClient(ctx context.Context, args []string, input, output any) (err error) {
 	logger := zap.L().Named(fmt.Sprintf("watchman %x", rand.Int31()))
 
 	ctx, cancel := context.WithCancel(ctx)
@@ -79,9 +82,14 @@ func runClient(ctx context.Context, args []string, input any) (err error) {
 		return err
 	}
 
+	var raw json.RawMessage
 	var response map[string]any
 
-	decodeErr := json.NewDecoder(stdout).Decode(&response)
+	decodeErr := json.NewDecoder(stdout).Decode(&raw)
+
+	if decodeErr == nil {
+		decodeErr = json.Unmarshal(raw, &response)
+	}
 
 	if err := cmd.Wait(); err != nil {
 		return fmt.Errorf("client failed: %w", err)
@@ -97,6 +105,12 @@ func runClient(ctx context.Context, args []string, input any) (err error) {
 		return fmt.Errorf("%w: %v", ErrClientError, errmsg)
 	}
 
+	if output != nil {
+		if err := json.Unmarshal(raw, output); err != nil {
+			return fmt.Errorf("decoding %q output failed: %w", cmd.Args, err)
+		}
+	}
+
 	return nil
 }
 
@@ -115,7 +129,11 @@ func NewCommandClient(base []string) *CommandClient {
 }
 
 func (c *CommandClient) run(ctx context.Context, args []string, input any) error {
-	return runClient(ctx, append(append([]string(nil), c.Args...), args...), input)
+	return c.runWithOutput(ctx, args, input, nil)
+}
+
+func (c *CommandClient) runWithOutput(ctx context.Context, args []string, input, output any) error {
+	return runClient(ctx, append(append([]string(nil), c.Args...), args...), input, output)
 }
 
 func (c *CommandClient) Ping(ctx context.Context) error {
@@ -154,6 +172,20 @@ func (c *CommandClient) TriggerDel(ctx context.Context, root, name string) error
 	return nil
 }
 
+// TriggerList returns the definitions of all triggers configured on the given
+// root directory.
+func (c *CommandClient) TriggerList(ctx context.Context, root string) ([]map[string]any, error) {
+	var response struct {
+		Triggers []map[string]any `json:"triggers"`
+	}
+
+	if err := c.runWithOutput(ctx, []string{"trigger-list", filepath.Clean(root)}, nil, &response); err != nil {
+		return nil, fmt.Errorf("listing triggers on %q failed: %w", root, err)
+	}
+
+	return response.Triggers, nil
+}
+
 func (c *CommandClient) ShutdownServer(ctx context.Context) error {
 	return c.run(ctx, []string{"shutdown-server"}, nil)
 }
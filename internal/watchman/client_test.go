@@ -9,6 +9,7 @@ import (
 	"regexp"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/hansmi/baamhackl/internal/cmdemu"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zaptest"
@@ -39,6 +40,11 @@ var fakeCommand = cmdemu.Command{
 			}
 		}
 
+		if fs.NArg() > 2 && fs.Arg(0) == "call" && fs.Arg(fs.NArg()-2) == "trigger-list" {
+			fmt.Println(`{"triggers": [{"name": "first"}, {"name": "second"}]}`)
+			return nil
+		}
+
 		if fs.NArg() > 1 && fs.Arg(0) == "call" {
 			fmt.Println("{}")
 			return nil
@@ -83,7 +89,7 @@ func TestRunClient(t *testing.T) {
 
 			args := fakeCommand.MakeArgs(tc.args...)
 
-			if err := runClient(context.Background(), args, nil); tc.wantErr == nil {
+			if err := runClient(context.Background(), args, nil, nil); tc.wantErr == nil {
 				if err != nil {
 					t.Errorf("runClient() failed: %v", err)
 				}
@@ -119,4 +125,13 @@ func TestCommandClient(t *testing.T) {
 	if err := client.TriggerDel(ctx, t.TempDir(), "name"); err != nil {
 		t.Errorf("TriggerDel() failed: %v", err)
 	}
+
+	if got, err := client.TriggerList(ctx, t.TempDir()); err != nil {
+		t.Errorf("TriggerList() failed: %v", err)
+	} else if diff := cmp.Diff([]map[string]any{
+		{"name": "first"},
+		{"name": "second"},
+	}, got); diff != "" {
+		t.Errorf("TriggerList() diff (-want +got):\n%s", diff)
+	}
 }
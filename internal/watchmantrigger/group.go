@@ -2,14 +2,18 @@ package watchmantrigger
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"reflect"
+	"sort"
 	"sync"
 
 	"github.com/hansmi/baamhackl/internal/config"
 	"github.com/hansmi/baamhackl/internal/exepath"
 	"github.com/hansmi/baamhackl/internal/watchman"
 	"go.uber.org/multierr"
+	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -34,22 +38,22 @@ func newTriggerSetter(client watchman.Client, socketPath string) (*triggerSetter
 	}, nil
 }
 
-func (s *triggerSetter) do(ctx context.Context, h *config.Handler) error {
+func (s *triggerSetter) do(ctx context.Context, h *config.Handler) (map[string]any, error) {
 	cfg, err := newTriggerConfig(*h)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	if configContent, err := cfg.configDataJSON(); err != nil {
-		return err
+		return nil, err
 	} else if err := os.WriteFile(cfg.configFilePath, configContent, configFileLocalScopeMode); err != nil {
-		return err
+		return nil, err
 	} else if err := os.Chmod(cfg.configFilePath, configFileLocalScopeMode); err != nil {
-		return err
+		return nil, err
 	}
 
 	if err := s.client.WatchSet(ctx, h.Path); err != nil {
-		return err
+		return nil, err
 	}
 
 	args := map[string]any{
@@ -60,10 +64,49 @@ func (s *triggerSetter) do(ctx context.Context, h *config.Handler) error {
 	}
 
 	if err := s.client.TriggerSet(ctx, h.Path, args); err != nil {
-		return fmt.Errorf("trigger %q: %w", h.Name, err)
+		return nil, fmt.Errorf("trigger %q: %w", h.Name, err)
 	}
 
-	return nil
+	return args, nil
+}
+
+// normalizeJSON converts a value to its generic JSON representation, e.g.
+// string slices become []any.
+func normalizeJSON(value any) (any, error) {
+	buf, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var result any
+
+	if err := json.Unmarshal(buf, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// sameTrigger reports whether the trigger definition returned by Watchman
+// matches the expected arguments. Fields not set by Baamhackl are ignored.
+func sameTrigger(want, got map[string]any) (bool, error) {
+	for key, value := range want {
+		normalized, err := normalizeJSON(value)
+		if err != nil {
+			return false, err
+		}
+
+		if !reflect.DeepEqual(normalized, got[key]) {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+type configuredTrigger struct {
+	handler *config.Handler
+	args    map[string]any
 }
 
 // Group is a collection of triggers configured in Watchman. Calling DeleteAll
@@ -73,7 +116,31 @@ type Group struct {
 	SocketPath string
 
 	mu         sync.Mutex
-	configured []*config.Handler
+	configured []configuredTrigger
+}
+
+// add records a configured trigger, replacing a previous definition for the
+// same handler.
+func (g *Group) add(h *config.Handler, args map[string]any) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for idx, i := range g.configured {
+		if i.handler.Name == h.Name && i.handler.Path == h.Path {
+			g.configured[idx].args = args
+			return
+		}
+	}
+
+	g.configured = append(g.configured, configuredTrigger{h, args})
+}
+
+// snapshot returns a copy of the configured triggers.
+func (g *Group) snapshot() []configuredTrigger {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return append([]configuredTrigger(nil), g.configured...)
 }
 
 // SetAll configures triggers for all given handlers.
@@ -90,13 +157,12 @@ func (g *Group) SetAll(ctx context.Context, all []*config.Handler) error {
 		h := h
 
 		eg.Go(func() error {
-			if err := ts.do(gctx, h); err != nil {
+			args, err := ts.do(gctx, h)
+			if err != nil {
 				return err
 			}
 
-			g.mu.Lock()
-			g.configured = append(g.configured, h)
-			g.mu.Unlock()
+			g.add(h, args)
 
 			return nil
 		})
@@ -105,13 +171,105 @@ func (g *Group) SetAll(ctx context.Context, all []*config.Handler) error {
 	return eg.Wait()
 }
 
+// Names returns the sorted names of all configured triggers.
+func (g *Group) Names() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	seen := map[string]struct{}{}
+	names := []string{}
+
+	for _, i := range g.configured {
+		if _, ok := seen[i.handler.Name]; !ok {
+			seen[i.handler.Name] = struct{}{}
+			names = append(names, i.handler.Name)
+		}
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Verify compares the triggers configured in Watchman with the expected
+// definitions. The names of handlers whose trigger is missing or differs are
+// returned in sorted order, e.g. after a restart of the Watchman daemon. All
+// triggers on a root are considered missing if listing them fails, e.g.
+// because the root is no longer watched.
+func (g *Group) Verify(ctx context.Context) ([]string, error) {
+	byRoot := map[string][]configuredTrigger{}
+
+	for _, i := range g.snapshot() {
+		byRoot[i.handler.Path] = append(byRoot[i.handler.Path], i)
+	}
+
+	var mu sync.Mutex
+	var invalid []string
+
+	eg, gctx := errgroup.WithContext(ctx)
+	eg.SetLimit(maxConcurrent)
+
+	for root, triggers := range byRoot {
+		root, triggers := root, triggers
+
+		eg.Go(func() error {
+			list, err := g.Client.TriggerList(gctx, root)
+			if err != nil {
+				if gctx.Err() != nil {
+					return err
+				}
+
+				zap.L().Warn("Listing Watchman triggers failed",
+					zap.String("root", root),
+					zap.Error(err))
+
+				list = nil
+			}
+
+			existing := map[string]map[string]any{}
+
+			for _, i := range list {
+				if name, ok := i["name"].(string); ok {
+					existing[name] = i
+				}
+			}
+
+			for _, i := range triggers {
+				ok := false
+
+				if def, found := existing[i.handler.Name]; found {
+					if ok, err = sameTrigger(i.args, def); err != nil {
+						return err
+					}
+				}
+
+				if !ok {
+					mu.Lock()
+					invalid = append(invalid, i.handler.Name)
+					mu.Unlock()
+				}
+			}
+
+			return nil
+		})
+	}
+
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+
+	sort.Strings(invalid)
+
+	return invalid, nil
+}
+
 // RecrawlAll triggers a full recrawl on all watched directories.
 func (g *Group) RecrawlAll(ctx context.Context) error {
 	eg, gctx := errgroup.WithContext(ctx)
 	eg.SetLimit(maxConcurrent)
 
-	for _, h := range g.configured {
-		h := h
+	for _, i := range g.snapshot() {
+		h := i.handler
 
 		eg.Go(func() error {
 			return g.Client.Recrawl(gctx, h.Path)
@@ -132,8 +290,8 @@ func (g *Group) DeleteAll(ctx context.Context) error {
 	g.mu.Lock()
 	defer g.mu.Unlock()
 
-	for _, h := range g.configured {
-		h := h
+	for _, i := range g.configured {
+		h := i.handler
 
 		eg.Go(func() error {
 			select {
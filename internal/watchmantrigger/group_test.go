@@ -6,17 +6,18 @@ import (
 	"sync"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/hansmi/baamhackl/internal/config"
 )
 
 type fakeClient struct {
 	mu         sync.Mutex
-	configured map[string]map[string]struct{}
+	configured map[string]map[string]map[string]any
 }
 
 func newFakeClient() *fakeClient {
 	return &fakeClient{
-		configured: map[string]map[string]struct{}{},
+		configured: map[string]map[string]map[string]any{},
 	}
 }
 
@@ -29,7 +30,7 @@ func (c *fakeClient) WatchSet(ctx context.Context, root string) error {
 	defer c.mu.Unlock()
 
 	if c.configured[root] == nil {
-		c.configured[root] = map[string]struct{}{}
+		c.configured[root] = map[string]map[string]any{}
 	}
 
 	return nil
@@ -46,6 +47,11 @@ func (c *fakeClient) Recrawl(ctx context.Context, root string) error {
 func (c *fakeClient) TriggerSet(ctx context.Context, root string, args any) error {
 	name := args.(map[string]any)["name"].(string)
 
+	normalized, err := normalizeJSON(args)
+	if err != nil {
+		return err
+	}
+
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
@@ -54,7 +60,7 @@ func (c *fakeClient) TriggerSet(ctx context.Context, root string, args any) erro
 	} else if _, ok := watch[name]; ok {
 		return fmt.Errorf("trigger %q already set on root %q", name, root)
 	} else {
-		watch[name] = struct{}{}
+		watch[name] = normalized.(map[string]any)
 	}
 
 	return nil
@@ -75,6 +81,24 @@ func (c *fakeClient) TriggerDel(ctx context.Context, root, name string) error {
 	return nil
 }
 
+func (c *fakeClient) TriggerList(ctx context.Context, root string) ([]map[string]any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	watch := c.configured[root]
+	if watch == nil {
+		return nil, fmt.Errorf("watch on root %q not set", root)
+	}
+
+	var result []map[string]any
+
+	for _, i := range watch {
+		result = append(result, i)
+	}
+
+	return result, nil
+}
+
 func (c *fakeClient) ShutdownServer(context.Context) error {
 	return nil
 }
@@ -89,6 +113,7 @@ func TestGroup(t *testing.T) {
 	}
 
 	num := 0
+	wantNames := []string{}
 
 	for _, count := range []int{0, 5, 20, maxConcurrent} {
 		var handlers []*config.Handler
@@ -97,9 +122,10 @@ func TestGroup(t *testing.T) {
 
 		for i := 0; i < count; i++ {
 			handlers = append(handlers, &config.Handler{
-				Name: fmt.Sprintf("handler%d", num),
+				Name: fmt.Sprintf("handler%02d", num),
 				Path: path,
 			})
+			wantNames = append(wantNames, handlers[i].Name)
 			num++
 		}
 
@@ -107,6 +133,10 @@ func TestGroup(t *testing.T) {
 			t.Errorf("SetAll() failed: %v", err)
 		}
 
+		if diff := cmp.Diff(wantNames, g.Names()); diff != "" {
+			t.Errorf("Names() diff (-want +got):\n%s", diff)
+		}
+
 		defer func() {
 			client.mu.Lock()
 			defer client.mu.Unlock()
@@ -119,9 +149,83 @@ func TestGroup(t *testing.T) {
 		if err := g.RecrawlAll(ctx); err != nil {
 			t.Errorf("RecrawlAll() failed: %v", err)
 		}
+
+		if invalid, err := g.Verify(ctx); err != nil {
+			t.Errorf("Verify() failed: %v", err)
+		} else if len(invalid) != 0 {
+			t.Errorf("Verify() reported invalid triggers: %q", invalid)
+		}
 	}
 
 	if err := g.DeleteAll(ctx); err != nil {
 		t.Errorf("DeleteAll() failed: %v", err)
 	}
 }
+
+func TestGroupVerify(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newFakeClient()
+	g := Group{
+		Client: client,
+	}
+
+	path := t.TempDir()
+	handlers := []*config.Handler{
+		{Name: "first", Path: path},
+		{Name: "second", Path: path},
+		{Name: "third", Path: path},
+	}
+
+	if err := g.SetAll(ctx, handlers); err != nil {
+		t.Fatalf("SetAll() failed: %v", err)
+	}
+
+	// Simulate a daemon restart losing one trigger and changing another.
+	if err := client.TriggerDel(ctx, path, "first"); err != nil {
+		t.Fatalf("TriggerDel() failed: %v", err)
+	}
+
+	client.mu.Lock()
+	client.configured[path]["third"]["stdin"] = "/dev/null"
+	client.mu.Unlock()
+
+	invalid, err := g.Verify(ctx)
+	if err != nil {
+		t.Errorf("Verify() failed: %v", err)
+	}
+
+	if diff := cmp.Diff([]string{"first", "third"}, invalid); diff != "" {
+		t.Errorf("Verify() diff (-want +got):\n%s", diff)
+	}
+
+	if err := client.TriggerDel(ctx, path, "third"); err != nil {
+		t.Fatalf("TriggerDel() failed: %v", err)
+	}
+
+	if err := g.SetAll(ctx, []*config.Handler{handlers[0], handlers[2]}); err != nil {
+		t.Errorf("SetAll() failed: %v", err)
+	}
+
+	if diff := cmp.Diff([]string{"first", "second", "third"}, g.Names()); diff != "" {
+		t.Errorf("Names() diff (-want +got):\n%s", diff)
+	}
+
+	if invalid, err := g.Verify(ctx); err != nil {
+		t.Errorf("Verify() failed: %v", err)
+	} else if len(invalid) != 0 {
+		t.Errorf("Verify() reported invalid triggers: %q", invalid)
+	}
+
+	// Simulate a daemon restart without state, losing the watch.
+	client.mu.Lock()
+	delete(client.configured, path)
+	client.mu.Unlock()
+
+	if invalid, err := g.Verify(ctx); err != nil {
+		t.Errorf("Verify() failed: %v", err)
+	} else if diff := cmp.Diff([]string{"first", "second", "third"}, invalid); diff != "" {
+		t.Errorf("Verify() diff (-want +got):\n%s", diff)
+	}
+}
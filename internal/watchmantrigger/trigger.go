@@ -47,14 +47,27 @@ type triggerConfig struct {
 	expression     any
 }
 
-func newTriggerConfig(h config.Handler) (*triggerConfig, error) {
+// IgnoreDirs returns the infrastructure directories contained in the handler
+// path, relative to the path and in sorted order. Files within them are never
+// reported.
+func IgnoreDirs(h config.Handler) ([]string, error) {
 	var ignoreDirs []string
 
-	for _, i := range []string{
+	dirs := []string{
 		h.JournalDir,
 		h.SuccessDir,
 		h.FailureDir,
-	} {
+	}
+
+	if h.DuplicateAction == config.DuplicateActionMove {
+		dirs = append(dirs, h.DuplicatesDir)
+	}
+
+	if h.Stdin.Enabled {
+		dirs = append(dirs, h.Stdin.OutputDir)
+	}
+
+	for _, i := range dirs {
 		if r, err := relpath.Resolve(h.Path, i); err != nil {
 			return nil, err
 		} else if r.Contained() {
@@ -64,6 +77,15 @@ func newTriggerConfig(h config.Handler) (*triggerConfig, error) {
 
 	sort.Strings(ignoreDirs)
 
+	return ignoreDirs, nil
+}
+
+func newTriggerConfig(h config.Handler) (*triggerConfig, error) {
+	ignoreDirs, err := IgnoreDirs(h)
+	if err != nil {
+		return nil, err
+	}
+
 	return &triggerConfig{
 		configFilePath: filepath.Join(h.Path, configFileLocalScope),
 
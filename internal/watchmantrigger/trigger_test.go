@@ -132,6 +132,87 @@ func TestNewTriggerConfig(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "duplicates dir",
+			cfg: func() config.Handler {
+				o := config.HandlerDefaults
+				o.Path = tmpdir
+				o.DuplicateAction = config.DuplicateActionMove
+				return o
+			}(),
+			want: &triggerConfig{
+				configFilePath: filepath.Join(tmpdir, configFileLocalScope),
+				configData: map[string]any{
+					"gc_age_seconds":        3600,
+					"gc_interval_seconds":   3600,
+					"idle_reap_age_seconds": 60,
+					"ignore_dirs": []string{
+						"_/duplicates",
+						"_/failure",
+						"_/journal",
+						"_/success",
+					},
+					"settle":                    1000,
+					"suppress_recrawl_warnings": true,
+				},
+				expression: []any{
+					"allof",
+					[]string{"exists"},
+					[]string{"type", "f"},
+
+					[]any{"dirname", "", []any{"depth", "eq", 0}},
+
+					[]any{"not", []string{"dirname", "_/duplicates"}},
+					[]any{"not", []string{"dirname", "_/failure"}},
+					[]any{"not", []string{"dirname", "_/journal"}},
+					[]any{"not", []string{"dirname", "_/success"}},
+
+					[]any{"not", []string{"match", ".*", "basename"}},
+				},
+			},
+		},
+		{
+			name: "stdin output dir",
+			cfg: func() config.Handler {
+				o := config.HandlerDefaults
+				o.Path = tmpdir
+				o.Stdin = config.Stdin{
+					Enabled:   true,
+					OutputDir: "_/output",
+				}
+				return o
+			}(),
+			want: &triggerConfig{
+				configFilePath: filepath.Join(tmpdir, configFileLocalScope),
+				configData: map[string]any{
+					"gc_age_seconds":        3600,
+					"gc_interval_seconds":   3600,
+					"idle_reap_age_seconds": 60,
+					"ignore_dirs": []string{
+						"_/failure",
+						"_/journal",
+						"_/output",
+						"_/success",
+					},
+					"settle":                    1000,
+					"suppress_recrawl_warnings": true,
+				},
+				expression: []any{
+					"allof",
+					[]string{"exists"},
+					[]string{"type", "f"},
+
+					[]any{"dirname", "", []any{"depth", "eq", 0}},
+
+					[]any{"not", []string{"dirname", "_/failure"}},
+					[]any{"not", []string{"dirname", "_/journal"}},
+					[]any{"not", []string{"dirname", "_/output"}},
+					[]any{"not", []string{"dirname", "_/success"}},
+
+					[]any{"not", []string{"match", ".*", "basename"}},
+				},
+			},
+		},
 		{
 			name: "custom dirs absolute",
 			cfg: func() config.Handler {
@@ -11,6 +11,9 @@ import (
 	"os"
 
 	"github.com/google/subcommands"
+	"github.com/hansmi/baamhackl/checkconfig"
+	"github.com/hansmi/baamhackl/events"
+	"github.com/hansmi/baamhackl/internal/execshim"
 	"github.com/hansmi/baamhackl/move"
 	"github.com/hansmi/baamhackl/selftest"
 	"github.com/hansmi/baamhackl/sendfilechanges"
@@ -60,8 +63,12 @@ func main() {
 	subcommands.Register(&watch.Command{}, "")
 	subcommands.Register(&move.IntoCommand{}, "")
 	subcommands.Register(&selftest.Command{}, "")
+	subcommands.Register(&checkconfig.Command{}, "")
+
+	subcommands.Register(&events.Command{}, "")
 
 	subcommands.Register(&sendfilechanges.Command{}, "internal")
+	subcommands.Register(&execshim.Command{}, "internal")
 
 	logLevel := zap.LevelFlag("log_level", zap.InfoLevel, "Log level for stderr.")
 
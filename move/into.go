@@ -2,22 +2,40 @@ package move
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"flag"
+	"io"
+	"os"
 	"path/filepath"
 
 	"github.com/google/subcommands"
 	"github.com/hansmi/baamhackl/internal/cmdutil"
+	"github.com/hansmi/baamhackl/internal/relpath"
 	"github.com/hansmi/baamhackl/internal/uniquename"
 	"github.com/hansmi/baamhackl/internal/waryio"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 )
 
 var errRenameNotSupported = errors.New("prefered destination names are only supported with a single source file")
+var errRenameWithTemplate = errors.New("preferred destination name and template are mutually exclusive")
+
+// intoResult describes the destination of a single source file.
+type intoResult struct {
+	Source string `json:"source"`
+	Dest   string `json:"dest"`
+}
 
 // IntoCommand implements the "move-into" subcommand.
 type IntoCommand struct {
-	rename string
+	rename   string
+	template string
+	json     bool
+	dryRun   bool
+	copy     bool
+
+	stdout io.Writer
 }
 
 func (*IntoCommand) Name() string {
@@ -29,44 +47,190 @@ func (*IntoCommand) Synopsis() string {
 }
 
 func (c *IntoCommand) Usage() string {
-	return cmdutil.Usage(c, "<target_dir> <source...>", `Source files are moved into the target directory. File name conflicts with existing files are resolved by finding another, available name derived from the original name.`)
+	return cmdutil.Usage(c, "<target_dir> <source...>", `Source files are moved into the target directory. File name conflicts with existing files are resolved by finding another, available name derived from the original name. Regular files are copied if the target directory is on another filesystem; the source is removed once the copy is complete.
+
+Destination templates use the Go text/template syntax with the following fields:
+
+  .Name     Source file name
+  .Base     Source file name without extension
+  .Ext      Source file extension including the dot
+  .Now      Current time
+  .ModTime  Modification time of source file
+  .Seq      Lowest number, starting at 1, for which the destination doesn't exist yet
+
+Times are formatted using layouts, e.g. {{.Now.Format "2006/01"}}. Numbers can be zero-padded with {{pad 3 .Seq}}. Sequence numbers continue across invocations, e.g. a file named "invoice-002.pdf" is produced if "invoice-001.pdf" exists already.`)
 }
 
 func (c *IntoCommand) SetFlags(fs *flag.FlagSet) {
 	fs.StringVar(&c.rename, "rename", "", "Preferred destination name. When set only a single source file can be used.")
+	fs.StringVar(&c.template, "template", "", "Template for destination path relative to the target directory. Missing subdirectories are created.")
+	fs.BoolVar(&c.json, "json", false, "Write source and destination of all files as JSON to standard output.")
+	fs.BoolVar(&c.dryRun, "dry_run", false, "Resolve destination names without moving files or creating directories.")
+	fs.BoolVar(&c.copy, "copy", false, "Copy files instead of moving them. Only regular files are supported.")
+}
+
+// copyToAvailableName copies the regular file at source to a path produced by
+// g without overwriting existing files.
+func copyToAvailableName(source string, g waryio.StringIter) (string, error) {
+	var err error
+
+	for path, ok := g.Next(); ok; path, ok = g.Next() {
+		opts := waryio.DefaultCopyOptions
+		opts.SourcePath = source
+		opts.DestPath = path
+		opts.DestFlags |= os.O_EXCL
+		opts.DestSync = true
+
+		if err = waryio.Copy(opts); err == nil {
+			return path, nil
+		}
+
+		if !os.IsExist(err) {
+			return "", err
+		}
+	}
+
+	if err == nil {
+		err = waryio.ErrIterExhausted
+	}
+
+	return "", err
 }
 
-func (c *IntoCommand) execute(targetDir string, sourceFiles []string) error {
+// resolveAvailableName returns the first path produced by g which neither
+// exists nor was claimed before.
+func resolveAvailableName(g waryio.StringIter, claimed map[string]struct{}) (string, error) {
+	for path, ok := g.Next(); ok; path, ok = g.Next() {
+		if _, ok := claimed[path]; ok {
+			continue
+		}
+
+		if _, err := os.Lstat(path); err == nil {
+			continue
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+
+		claimed[path] = struct{}{}
+
+		return path, nil
+	}
+
+	return "", waryio.ErrIterExhausted
+}
+
+func (c *IntoCommand) execute(targetDir string, sourceFiles []string) ([]intoResult, error) {
 	logger := zap.L()
 
 	opts := uniquename.DefaultOptions
 	opts.TimePrefixEnabled = false
 
+	var tmpl *nameTemplate
+
+	if c.template != "" {
+		if c.rename != "" {
+			return nil, errRenameWithTemplate
+		}
+
+		var err error
+
+		if tmpl, err = newNameTemplate(c.template); err != nil {
+			return nil, err
+		}
+	}
+
+	var results []intoResult
+
+	claimed := map[string]struct{}{}
+
+	// Destinations chosen via sequence numbers, relative to the target
+	// directory.
+	claimedSeq := map[string]struct{}{}
+
 	for _, oldpath := range sourceFiles {
+		dir := targetDir
 		newname := filepath.Base(oldpath)
 		if c.rename != "" {
 			if len(sourceFiles) > 1 {
-				return errRenameNotSupported
+				return results, errRenameNotSupported
 			}
 			newname = c.rename
+		} else if tmpl != nil {
+			rel, err := tmpl.resolve(targetDir, oldpath, claimedSeq)
+			if err != nil {
+				return results, err
+			}
+
+			if c.dryRun {
+				if r, err := relpath.Resolve(targetDir, filepath.Dir(rel)); err != nil {
+					return results, err
+				} else {
+					dir = r.Path
+				}
+			} else if dir, err = waryio.EnsureRelDir(targetDir, filepath.Dir(rel), os.ModePerm); err != nil {
+				return results, err
+			}
+
+			newname = filepath.Base(rel)
 		}
-		newpath := filepath.Join(targetDir, newname)
+		newpath := filepath.Join(dir, newname)
 
 		g, err := uniquename.New(newpath, opts)
 		if err != nil {
-			return err
+			return results, err
+		}
+
+		var actual, message string
+
+		switch {
+		case c.dryRun:
+			if _, err := os.Lstat(oldpath); err != nil {
+				return results, err
+			}
+
+			actual, err = resolveAvailableName(g, claimed)
+			message = "Destination resolved (dry run)"
+
+		case c.copy:
+			actual, err = copyToAvailableName(oldpath, g)
+			message = "File copied successfully"
+
+		default:
+			actual, err = waryio.MoveToAvailableName(oldpath, g)
+			message = "File moved successfully"
 		}
 
-		actual, err := waryio.RenameToAvailableName(oldpath, g)
 		if err != nil {
-			return err
+			return results, err
 		}
 
-		logger.Info("File moved successfully",
+		logger.Info(message,
 			zap.String("src", oldpath), zap.String("dest", actual))
+
+		results = append(results, intoResult{
+			Source: oldpath,
+			Dest:   actual,
+		})
 	}
 
-	return nil
+	return results, nil
+}
+
+func (c *IntoCommand) writeJSON(results []intoResult) error {
+	w := c.stdout
+
+	if w == nil {
+		w = os.Stdout
+	}
+
+	if results == nil {
+		results = []intoResult{}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(results)
 }
 
 func (c *IntoCommand) Execute(ctx context.Context, fs *flag.FlagSet, _ ...any) subcommands.ExitStatus {
@@ -76,5 +240,12 @@ func (c *IntoCommand) Execute(ctx context.Context, fs *flag.FlagSet, _ ...any) s
 		return subcommands.ExitUsageError
 	}
 
-	return cmdutil.ExecuteStatus(c.execute(fs.Arg(0), fs.Args()[1:]))
+	results, err := c.execute(fs.Arg(0), fs.Args()[1:])
+
+	if c.json {
+		// Files processed before an error are reported too.
+		multierr.AppendInto(&err, c.writeJSON(results))
+	}
+
+	return cmdutil.ExecuteStatus(err)
 }
@@ -1,10 +1,14 @@
 package move
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hansmi/baamhackl/internal/testutil"
 )
 
 func TestExecute(t *testing.T) {
@@ -48,9 +52,27 @@ func TestExecute(t *testing.T) {
 			},
 			wantErr: errRenameNotSupported,
 		},
+		{
+			name: "template with subdirectories",
+			cmd: IntoCommand{
+				template: "new/sub/{{.Name}}",
+			},
+			sourceFiles: []string{
+				t.TempDir(),
+				t.TempDir(),
+			},
+		},
+		{
+			name: "template outside target",
+			cmd: IntoCommand{
+				template: "../{{.Name}}",
+			},
+			sourceFiles: []string{t.TempDir()},
+			wantErr:     errTemplateNotLocal,
+		},
 	} {
 		t.Run(tc.name, func(t *testing.T) {
-			err := tc.cmd.execute(t.TempDir(), tc.sourceFiles)
+			_, err := tc.cmd.execute(t.TempDir(), tc.sourceFiles)
 
 			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
 				t.Errorf("Error diff (-want +got):\n%s", diff)
@@ -58,3 +80,148 @@ func TestExecute(t *testing.T) {
 		})
 	}
 }
+
+func TestExecuteCrossDevice(t *testing.T) {
+	targetDir, err := os.MkdirTemp("/dev/shm", "move*")
+	if err != nil {
+		t.Skipf("Creating directory on second filesystem failed: %v", err)
+	}
+
+	t.Cleanup(func() {
+		os.RemoveAll(targetDir)
+	})
+
+	source := testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "file.txt"), "content")
+
+	var cmd IntoCommand
+
+	if _, err := cmd.execute(targetDir, []string{source}); err != nil {
+		t.Errorf("execute() failed: %v", err)
+	}
+
+	testutil.MustNotExist(t, source)
+	testutil.MustLstat(t, filepath.Join(targetDir, "file.txt"))
+}
+
+func TestExecuteModes(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		cmd         IntoCommand
+		wantSources bool
+		wantDests   bool
+	}{
+		{name: "move", wantDests: true},
+		{name: "copy", cmd: IntoCommand{copy: true}, wantSources: true, wantDests: true},
+		{name: "dry run", cmd: IntoCommand{dryRun: true}, wantSources: true},
+		{name: "dry run with template", cmd: IntoCommand{dryRun: true, template: "sub/dir/{{.Name}}"}, wantSources: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			targetDir := t.TempDir()
+			sourceDir := t.TempDir()
+
+			testutil.MustWriteFile(t, filepath.Join(targetDir, "first.txt"), "existing")
+
+			sourceFiles := []string{
+				testutil.MustWriteFile(t, filepath.Join(sourceDir, "first.txt"), "first"),
+				testutil.MustWriteFile(t, filepath.Join(testutil.MustMkdir(t, filepath.Join(sourceDir, "sub")), "first.txt"), "second"),
+				testutil.MustWriteFile(t, filepath.Join(sourceDir, "third.txt"), "third"),
+			}
+
+			results, err := tc.cmd.execute(targetDir, sourceFiles)
+			if err != nil {
+				t.Errorf("execute() failed: %v", err)
+			}
+
+			if diff := cmp.Diff(len(sourceFiles), len(results)); diff != "" {
+				t.Fatalf("Result count diff (-want +got):\n%s", diff)
+			}
+
+			dests := map[string]struct{}{}
+
+			for idx, i := range results {
+				if diff := cmp.Diff(sourceFiles[idx], i.Source); diff != "" {
+					t.Errorf("Source diff (-want +got):\n%s", diff)
+				}
+
+				if _, ok := dests[i.Dest]; ok {
+					t.Errorf("Destination %q used multiple times", i.Dest)
+				}
+
+				dests[i.Dest] = struct{}{}
+
+				if tc.wantSources {
+					testutil.MustLstat(t, i.Source)
+				} else {
+					testutil.MustNotExist(t, i.Source)
+				}
+
+				if tc.wantDests {
+					testutil.MustLstat(t, i.Dest)
+				} else {
+					testutil.MustNotExist(t, i.Dest)
+				}
+			}
+
+			if tc.cmd.template != "" {
+				testutil.MustNotExist(t, filepath.Join(targetDir, "sub"))
+			}
+		})
+	}
+}
+
+func TestExecuteCopyDirectory(t *testing.T) {
+	targetDir := t.TempDir()
+	cmd := IntoCommand{copy: true}
+
+	if _, err := cmd.execute(targetDir, []string{t.TempDir()}); err == nil {
+		t.Errorf("execute() succeeded copying a directory")
+	}
+
+	if entries, err := os.ReadDir(targetDir); err != nil {
+		t.Errorf("ReadDir() failed: %v", err)
+	} else if len(entries) != 0 {
+		t.Errorf("Target directory is not empty: %v", entries)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		results []intoResult
+		want    string
+	}{
+		{name: "empty", want: "[]\n"},
+		{
+			name: "files",
+			results: []intoResult{
+				{Source: "/src/a", Dest: "/dest/a"},
+				{Source: "/src/b", Dest: "/dest/b (1)"},
+			},
+			want: `[
+  {
+    "source": "/src/a",
+    "dest": "/dest/a"
+  },
+  {
+    "source": "/src/b",
+    "dest": "/dest/b (1)"
+  }
+]
+`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf strings.Builder
+
+			cmd := IntoCommand{stdout: &buf}
+
+			if err := cmd.writeJSON(tc.results); err != nil {
+				t.Errorf("writeJSON() failed: %v", err)
+			}
+
+			if diff := cmp.Diff(tc.want, buf.String()); diff != "" {
+				t.Errorf("Output diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package move
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/jonboulle/clockwork"
+)
+
+var clock clockwork.Clock = clockwork.NewRealClock()
+
+var errTemplateNotLocal = errors.New("template must produce a relative path within the target directory")
+
+var errTemplateSeqExhausted = errors.New("no available sequence number")
+
+// Highest sequence number tried before giving up.
+const maxTemplateSeq = 100000
+
+// templateData contains the values available to destination name templates.
+type templateData struct {
+	// Source file name, e.g. "scan.pdf".
+	Name string
+
+	// Source file name without extension, e.g. "scan".
+	Base string
+
+	// Source file extension including the leading dot, e.g. ".pdf".
+	Ext string
+
+	// Time when the command was started.
+	Now time.Time
+
+	// Modification time of the source file.
+	ModTime time.Time
+
+	// Lowest number, starting at 1, for which the destination doesn't exist
+	// yet. Each distinct destination pattern has its own sequence.
+	Seq int
+}
+
+var templateFuncs = template.FuncMap{
+	// Format a number with zero-padding to the given width.
+	"pad": func(width, value int) string {
+		return fmt.Sprintf("%0*d", width, value)
+	},
+}
+
+type nameTemplate struct {
+	tmpl *template.Template
+	now  time.Time
+}
+
+func newNameTemplate(text string) (*nameTemplate, error) {
+	tmpl, err := template.New("").Option("missingkey=error").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parsing template failed: %w", err)
+	}
+
+	return &nameTemplate{
+		tmpl: tmpl,
+		now:  clock.Now(),
+	}, nil
+}
+
+// render returns the destination path relative to the target directory for
+// the given source file.
+func (t *nameTemplate) render(source string, seq int) (string, error) {
+	fi, err := os.Lstat(source)
+	if err != nil {
+		return "", err
+	}
+
+	name := filepath.Base(source)
+	ext := filepath.Ext(name)
+
+	var buf bytes.Buffer
+
+	if err := t.tmpl.Execute(&buf, templateData{
+		Name:    name,
+		Base:    strings.TrimSuffix(name, ext),
+		Ext:     ext,
+		Now:     t.now,
+		ModTime: fi.ModTime(),
+		Seq:     seq,
+	}); err != nil {
+		return "", fmt.Errorf("executing template failed: %w", err)
+	}
+
+	result := buf.String()
+
+	if !filepath.IsLocal(result) || filepath.Base(result) == "." {
+		return "", fmt.Errorf("%w: %q", errTemplateNotLocal, result)
+	}
+
+	return filepath.Clean(result), nil
+}
+
+// resolve renders the destination path for the source file using the lowest
+// sequence number whose destination within targetDir neither exists nor was
+// claimed before. Templates whose output stops changing with the sequence
+// number are rendered no further.
+func (t *nameTemplate) resolve(targetDir, source string, claimed map[string]struct{}) (string, error) {
+	var first, previous string
+
+	for seq := 1; seq <= maxTemplateSeq; seq++ {
+		rel, err := t.render(source, seq)
+		if err != nil {
+			return "", err
+		}
+
+		if seq == 1 {
+			first = rel
+		} else if rel == first || rel == previous {
+			// Conflicts are resolved like for other destination names.
+			return rel, nil
+		}
+
+		previous = rel
+
+		if _, ok := claimed[rel]; ok {
+			continue
+		}
+
+		if _, err := os.Lstat(filepath.Join(targetDir, rel)); err == nil {
+			continue
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+
+		claimed[rel] = struct{}{}
+
+		return rel, nil
+	}
+
+	return "", fmt.Errorf("%w for %q after %d attempts", errTemplateSeqExhausted, source, maxTemplateSeq)
+}
@@ -0,0 +1,248 @@
+package move
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hansmi/baamhackl/internal/testutil"
+	"github.com/jonboulle/clockwork"
+)
+
+func TestNameTemplate(t *testing.T) {
+	testutil.ReplaceClock(t, &clock, clockwork.NewFakeClockAt(time.Date(2026, time.October, 18, 12, 0, 0, 0, time.UTC)))
+
+	mtime := time.Date(2026, time.September, 1, 8, 30, 0, 0, time.UTC)
+	source := testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "scan.tar.gz"), "")
+
+	if err := os.Chtimes(source, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		text    string
+		want    string
+		wantErr error
+	}{
+		{name: "static", text: "file.txt", want: "file.txt"},
+		{name: "name", text: "{{.Name}}", want: "scan.tar.gz"},
+		{name: "base and ext", text: "{{.Base}}-x{{.Ext}}", want: "scan.tar-x.gz"},
+		{
+			name: "invoice",
+			text: `{{.Now.Format "2006/01"}}/invoice-{{.ModTime.UTC.Format "2006-01-02"}}-{{pad 3 .Seq}}{{.Ext}}`,
+			want: "2026/10/invoice-2026-09-01-007.gz",
+		},
+		{name: "cleaned", text: "a/./b//{{.Name}}", want: "a/b/scan.tar.gz"},
+		{name: "empty", text: "{{/* nothing */}}", wantErr: errTemplateNotLocal},
+		{name: "absolute", text: "/tmp/{{.Name}}", wantErr: errTemplateNotLocal},
+		{name: "parent", text: "../{{.Name}}", wantErr: errTemplateNotLocal},
+		{name: "directory only", text: "dir/", want: "dir"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tmpl, err := newNameTemplate(tc.text)
+			if err != nil {
+				t.Fatalf("newNameTemplate() failed: %v", err)
+			}
+
+			got, err := tmpl.render(source, 7)
+
+			if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+				t.Errorf("Error diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Path diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNameTemplateInvalid(t *testing.T) {
+	for _, text := range []string{
+		"{{",
+		"{{.Missing}}",
+		"{{unknown}}",
+	} {
+		tmpl, err := newNameTemplate(text)
+		if err == nil {
+			_, err = tmpl.render(testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "file"), ""), 1)
+		}
+
+		if err == nil {
+			t.Errorf("Template %q succeeded", text)
+		}
+	}
+}
+
+func TestExecuteTemplate(t *testing.T) {
+	targetDir := t.TempDir()
+	sourceDir := t.TempDir()
+
+	testutil.MustMkdir(t, filepath.Join(targetDir, "sub"))
+
+	existing := testutil.MustWriteFile(t, filepath.Join(testutil.MustMkdir(t, filepath.Join(targetDir, "sub", "dir")), "file-01.txt"), "existing")
+
+	var sourceFiles []string
+
+	for _, name := range []string{"a.txt", "b.txt"} {
+		sourceFiles = append(sourceFiles, testutil.MustWriteFile(t, filepath.Join(sourceDir, name), name))
+	}
+
+	cmd := IntoCommand{
+		template: "sub/dir/file-{{pad 2 .Seq}}{{.Ext}}",
+	}
+
+	if _, err := cmd.execute(targetDir, sourceFiles); err != nil {
+		t.Errorf("execute() failed: %v", err)
+	}
+
+	for _, i := range sourceFiles {
+		testutil.MustNotExist(t, i)
+	}
+
+	if entries, err := os.ReadDir(filepath.Dir(existing)); err != nil {
+		t.Errorf("ReadDir() failed: %v", err)
+	} else if len(entries) != 3 {
+		t.Errorf("Directory has %d entries, want 3: %v", len(entries), entries)
+	}
+
+	// Existing files are not overwritten.
+	if content, err := os.ReadFile(existing); err != nil {
+		t.Errorf("ReadFile() failed: %v", err)
+	} else if diff := cmp.Diff("existing", string(content)); diff != "" {
+		t.Errorf("Content diff (-want +got):\n%s", diff)
+	}
+
+	testutil.MustLstat(t, filepath.Join(targetDir, "sub", "dir", "file-02.txt"))
+	testutil.MustLstat(t, filepath.Join(targetDir, "sub", "dir", "file-03.txt"))
+}
+
+func TestExecuteTemplateSequence(t *testing.T) {
+	targetDir := t.TempDir()
+
+	cmd := IntoCommand{
+		template: `invoice-{{.ModTime.UTC.Format "20060102"}}-{{pad 3 .Seq}}{{.Ext}}`,
+	}
+
+	var got []string
+
+	// Each invocation moves a single file like a handler command would.
+	for _, day := range []int{1, 1, 2, 1} {
+		source := testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "scan.pdf"), "")
+		mtime := time.Date(2026, time.September, day, 8, 0, 0, 0, time.UTC)
+
+		if err := os.Chtimes(source, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := cmd.execute(targetDir, []string{source})
+		if err != nil {
+			t.Fatalf("execute() failed: %v", err)
+		}
+
+		for _, i := range results {
+			got = append(got, filepath.Base(i.Dest))
+		}
+	}
+
+	if diff := cmp.Diff([]string{
+		"invoice-20260901-001.pdf",
+		"invoice-20260901-002.pdf",
+		"invoice-20260902-001.pdf",
+		"invoice-20260901-003.pdf",
+	}, got); diff != "" {
+		t.Errorf("Destination diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestExecuteTemplateSequenceConstant(t *testing.T) {
+	targetDir := t.TempDir()
+
+	testutil.MustWriteFile(t, filepath.Join(targetDir, "scan.pdf"), "")
+	testutil.MustWriteFile(t, filepath.Join(targetDir, "scan-x.pdf"), "")
+
+	// The output doesn't change anymore after the first sequence number.
+	cmd := IntoCommand{
+		template: `{{.Base}}{{if gt .Seq 1}}-x{{end}}{{.Ext}}`,
+	}
+
+	results, err := cmd.execute(targetDir, []string{
+		testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "scan.pdf"), ""),
+	})
+	if err != nil {
+		t.Fatalf("execute() failed: %v", err)
+	}
+
+	for _, i := range results {
+		if name := filepath.Base(i.Dest); name == "scan.pdf" || name == "scan-x.pdf" {
+			t.Errorf("Existing file %q was replaced", name)
+		}
+	}
+}
+
+func TestNameTemplateResolveExhausted(t *testing.T) {
+	tmpl, err := newNameTemplate(`out-{{.Seq}}.txt`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claimed := map[string]struct{}{}
+
+	for seq := 1; seq <= maxTemplateSeq; seq++ {
+		claimed[fmt.Sprintf("out-%d.txt", seq)] = struct{}{}
+	}
+
+	source := testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "scan.pdf"), "")
+
+	if _, err := tmpl.resolve(t.TempDir(), source, claimed); !errors.Is(err, errTemplateSeqExhausted) {
+		t.Errorf("resolve() returned %v, want %v", err, errTemplateSeqExhausted)
+	}
+}
+
+func TestExecuteTemplateSequenceDryRun(t *testing.T) {
+	targetDir := t.TempDir()
+
+	testutil.MustWriteFile(t, filepath.Join(targetDir, "out-1.txt"), "")
+
+	cmd := IntoCommand{
+		dryRun:   true,
+		template: "out-{{.Seq}}{{.Ext}}",
+	}
+
+	results, err := cmd.execute(targetDir, []string{
+		testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "a.txt"), ""),
+		testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "b.txt"), ""),
+	})
+	if err != nil {
+		t.Fatalf("execute() failed: %v", err)
+	}
+
+	var got []string
+
+	for _, i := range results {
+		got = append(got, filepath.Base(i.Dest))
+	}
+
+	if diff := cmp.Diff([]string{"out-2.txt", "out-3.txt"}, got); diff != "" {
+		t.Errorf("Destination diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestExecuteTemplateWithRename(t *testing.T) {
+	cmd := IntoCommand{
+		rename:   "name",
+		template: "{{.Name}}",
+	}
+
+	_, err := cmd.execute(t.TempDir(), []string{testutil.MustWriteFile(t, filepath.Join(t.TempDir(), "file"), "")})
+
+	if diff := cmp.Diff(errRenameWithTemplate, err, cmpopts.EquateErrors()); diff != "" {
+		t.Errorf("Error diff (-want +got):\n%s", diff)
+	}
+}
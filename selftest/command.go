@@ -16,6 +16,7 @@ type Command struct {
 	wmFlags     watchman.Flags
 	timeout     time.Duration
 	keepWorkDir bool
+	namespaces  bool
 }
 
 func (*Command) Name() string {
@@ -37,6 +38,7 @@ func (c *Command) SetFlags(fs *flag.FlagSet) {
 	c.wmFlags.SetFlags(fs)
 	fs.DurationVar(&c.timeout, "timeout", time.Minute, "Maximum duration for running all tests.")
 	fs.BoolVar(&c.keepWorkDir, "keep", false, "Leave the temporary directory behind to aid in debugging.")
+	fs.BoolVar(&c.namespaces, "namespaces", true, "Verify that handler commands can be isolated using namespaces. Skipped if user namespaces are unavailable.")
 }
 
 func (c *Command) execute(ctx context.Context) error {
@@ -46,7 +48,7 @@ func (c *Command) execute(ctx context.Context) error {
 	defer cancel()
 
 	err := withTempDir(c.keepWorkDir, func(tmpdir string) error {
-		r, err := newRunner(tmpdir)
+		r, err := newRunner(tmpdir, c.namespaces)
 		if err != nil {
 			return err
 		}
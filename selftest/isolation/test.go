@@ -0,0 +1,112 @@
+package isolation
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// Directories made available to the command.
+var systemDirs = []string{"/bin", "/lib", "/lib32", "/lib64", "/sbin", "/usr"}
+
+type isolationTest struct {
+	dir       string
+	targetDir string
+	secret    string
+	want      []byte
+}
+
+func New(dir string) (*isolationTest, error) {
+	var err error
+
+	t := &isolationTest{}
+
+	if t.dir, err = os.MkdirTemp(dir, "isolation*"); err != nil {
+		return nil, err
+	}
+
+	if t.targetDir, err = os.MkdirTemp(dir, "isolationtarget*"); err != nil {
+		return nil, err
+	}
+
+	t.secret = filepath.Join(dir, "isolationsecret.txt")
+
+	return t, nil
+}
+
+func (t *isolationTest) Name() string {
+	return "namespace isolation"
+}
+
+func (t *isolationTest) HandlerConfig() map[string]any {
+	var readOnly []string
+
+	for _, i := range systemDirs {
+		if _, err := os.Stat(i); err == nil {
+			readOnly = append(readOnly, i)
+		}
+	}
+
+	return map[string]any{
+		"name":        t.Name(),
+		"path":        t.dir,
+		"retry_count": 0,
+		"namespaces": map[string]any{
+			"enabled":    true,
+			"read_only":  readOnly,
+			"read_write": []string{t.targetDir},
+		},
+		"command": []string{
+			"/usr/bin/env", "SECRET=" + t.secret, "TARGET_DIR=" + t.targetDir,
+			"/bin/sh", "-x", "-e", "-c", `
+for path in "${SECRET:?}" "${BAAMHACKL_ORIGINAL:?}"; do
+	if test -e "$path"; then
+		echo "${path} is visible within the namespace" >&2
+		exit 1
+	fi
+done
+
+cp -v "${BAAMHACKL_INPUT:?}" done.txt
+
+"${BAAMHACKL_PROGRAM:?}" move-into "${TARGET_DIR:?}" done.txt
+`},
+	}
+}
+
+func (t *isolationTest) Setup() error {
+	if err := os.WriteFile(t.secret, []byte("secret\n"), 0o644); err != nil {
+		return err
+	}
+
+	t.want = []byte(rand.Text())
+
+	return os.WriteFile(filepath.Join(t.dir, "trigger"), t.want, 0o644)
+}
+
+func (t *isolationTest) Run(ctx context.Context) error {
+	b := backoff.NewExponentialBackOff()
+	b.RandomizationFactor = 0.1
+	b.MaxInterval = time.Second
+	b.MaxElapsedTime = 10 * time.Second
+
+	path := filepath.Join(t.targetDir, "done.txt")
+
+	return backoff.Retry(func() error {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		if !bytes.Equal(t.want, content) {
+			return errors.New("content of copied file differs")
+		}
+
+		return nil
+	}, backoff.WithContext(b, ctx))
+}
@@ -10,12 +10,15 @@ import (
 
 	"github.com/goccy/go-yaml"
 	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/execshim"
 	"github.com/hansmi/baamhackl/internal/waryio"
 	"github.com/hansmi/baamhackl/internal/watchman"
 	"github.com/hansmi/baamhackl/selftest/commandenv"
+	"github.com/hansmi/baamhackl/selftest/isolation"
 	"github.com/hansmi/baamhackl/selftest/multifile"
 	"github.com/hansmi/baamhackl/watch"
 	"go.uber.org/multierr"
+	"go.uber.org/zap"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -51,7 +54,7 @@ type runner struct {
 	tests    []test
 }
 
-func newRunner(dir string) (*runner, error) {
+func newRunner(dir string, namespaces bool) (*runner, error) {
 	var err error
 
 	r := &runner{
@@ -74,6 +77,18 @@ func newRunner(dir string) (*runner, error) {
 		r.tests = append(r.tests, t)
 	}
 
+	if namespaces {
+		if err := execshim.NamespacesSupported(); errors.Is(err, execshim.ErrNamespacesUnsupported) {
+			zap.L().Warn("Skipping namespace isolation test", zap.Error(err))
+		} else if err != nil {
+			return nil, err
+		} else if t, err := isolation.New(r.baseDir); err != nil {
+			return nil, err
+		} else {
+			r.tests = append(r.tests, t)
+		}
+	}
+
 	return r, nil
 }
 
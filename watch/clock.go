@@ -0,0 +1,5 @@
+package watch
+
+import "github.com/jonboulle/clockwork"
+
+var clock clockwork.Clock = clockwork.NewRealClock()
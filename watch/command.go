@@ -11,15 +11,21 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
 	"syscall"
 	"time"
 
 	"github.com/google/subcommands"
+	"github.com/hansmi/baamhackl/internal/cgroup"
 	"github.com/hansmi/baamhackl/internal/cleanupgroup"
 	"github.com/hansmi/baamhackl/internal/cmdutil"
 	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/credential"
+	"github.com/hansmi/baamhackl/internal/eventbus"
+	"github.com/hansmi/baamhackl/internal/execshim"
 	"github.com/hansmi/baamhackl/internal/service"
 	"github.com/hansmi/baamhackl/internal/signalwait"
+	"github.com/hansmi/baamhackl/internal/tracing"
 	"github.com/hansmi/baamhackl/internal/watchman"
 	"github.com/hansmi/baamhackl/internal/watchmantrigger"
 	"github.com/prometheus/client_golang/prometheus"
@@ -57,9 +63,35 @@ func createTempDir(parent string) (string, func() error, error) {
 	}, nil
 }
 
+// setupCgroups prepares per-command cgroups if any handler configures cgroup
+// limits. Commands run without the limits if no delegated cgroup v2 hierarchy
+// is available.
+func setupCgroups(logger *zap.Logger, handlers []*config.Handler) *cgroup.Manager {
+	if !slices.ContainsFunc(handlers, func(h *config.Handler) bool {
+		return h.Cgroup.Enabled()
+	}) {
+		return nil
+	}
+
+	m, err := cgroup.Setup()
+	if err != nil {
+		logger.Warn("Cgroup limits are not applied", zap.Error(err))
+		return nil
+	}
+
+	return m
+}
+
+// httpEndpoints is implemented by types providing additional HTTP handlers
+// on the metrics server.
+type httpEndpoints interface {
+	register(*http.ServeMux)
+}
+
 // serveMetrics builds a Prometheus registry including the router metrics and
-// serves all values under /metrics on the given listener.
-func serveMetrics(logger *zap.Logger, listener net.Listener, routerMetrics prometheus.Collector) (func(context.Context) error, error) {
+// serves all values under /metrics on the given listener. Further endpoints,
+// e.g. for health checks, are added to the same server.
+func serveMetrics(logger *zap.Logger, listener net.Listener, routerMetrics prometheus.Collector, endpoints ...httpEndpoints) (func(context.Context) error, error) {
 	reg := prometheus.NewPedanticRegistry()
 	reg.MustRegister(
 		collectors.NewBuildInfoCollector(),
@@ -75,9 +107,20 @@ func serveMetrics(logger *zap.Logger, listener net.Listener, routerMetrics prome
 		MaxRequestsInFlight: 3,
 	}))
 
+	for _, i := range endpoints {
+		i.register(mux)
+	}
+
+	// Long-running requests, e.g. event streams, are cancelled on shutdown.
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+
 	server := &http.Server{
 		Handler: mux,
+		BaseContext: func(net.Listener) context.Context {
+			return baseCtx
+		},
 	}
+	server.RegisterOnShutdown(cancelBase)
 
 	closed := make(chan struct{})
 
@@ -104,13 +147,13 @@ func serveMetrics(logger *zap.Logger, listener net.Listener, routerMetrics prome
 	}, nil
 }
 
-func listenAndServeMetrics(logger *zap.Logger, addr string, routerMetrics prometheus.Collector) (string, func(context.Context) error, error) {
+func listenAndServeMetrics(logger *zap.Logger, addr string, routerMetrics prometheus.Collector, endpoints ...httpEndpoints) (string, func(context.Context) error, error) {
 	listener, err := net.Listen("tcp", addr)
 	if err != nil {
 		return "", nil, err
 	}
 
-	stop, err := serveMetrics(logger, listener, routerMetrics)
+	stop, err := serveMetrics(logger, listener, routerMetrics, endpoints...)
 
 	if err != nil {
 		listener.Close()
@@ -133,6 +176,12 @@ type Command struct {
 	pruneInterval    time.Duration
 	shutdownTimeout  time.Duration
 	metricsAddress   string
+	probeInterval    time.Duration
+	triggerInterval  time.Duration
+	startupScan      bool
+	statusPage       bool
+	eventStream      bool
+	tracingOpts      tracing.Options
 	configFlag       config.Flag
 }
 
@@ -160,13 +209,35 @@ func (c *Command) SetFlags(fs *flag.FlagSet) {
 	fs.DurationVar(&c.pruneInterval, "prune_interval", time.Hour,
 		"How often to delete old journal entries.")
 	fs.StringVar(&c.metricsAddress, "metrics_address", "",
-		"Address on which to expose metrics (e.g. 127.0.0.1:8080). Leave empty to disable metrics.")
+		"Address on which to expose metrics as well as health and readiness endpoints (e.g. 127.0.0.1:8080). Leave empty to disable metrics.")
+	fs.DurationVar(&c.probeInterval, "watchman_probe_interval", 30*time.Second,
+		"How often to verify that Watchman is reachable. Use 0s to disable.")
+	fs.DurationVar(&c.triggerInterval, "trigger_check_interval", time.Minute,
+		"How often to verify that the Watchman triggers still exist, e.g. after a restart of the Watchman daemon. Missing triggers are registered again. Use 0s to disable.")
+	fs.BoolVar(&c.startupScan, "startup_scan", false,
+		"Scan all handler directories on startup and queue existing files, oldest first.")
+	fs.BoolVar(&c.statusPage, "status_page", false,
+		"Serve a read-only HTML status page under /status on the metrics address.")
+	fs.BoolVar(&c.eventStream, "event_stream", false,
+		"Stream task lifecycle events under /events on the metrics address.")
+	fs.StringVar(&c.tracingOpts.OTLPEndpoint, "trace_otlp_endpoint", "",
+		"URL of an OpenTelemetry collector receiving spans via OTLP/HTTP (e.g. http://localhost:4318). Leave empty to disable.")
+	fs.StringVar(&c.tracingOpts.File, "trace_file", "",
+		"Path to a file receiving spans as newline-delimited JSON. Leave empty to disable.")
 	c.configFlag.SetFlags(fs)
 }
 
 func (c *Command) ExecuteWithClient(ctx context.Context, client watchman.Client) (err error) {
 	logger := zap.L()
 
+	if c.statusPage && c.metricsAddress == "" {
+		return errors.New("status page requires a metrics address")
+	}
+
+	if c.eventStream && c.metricsAddress == "" {
+		return errors.New("event stream requires a metrics address")
+	}
+
 	cfg, err := c.configFlag.Load()
 	if err != nil {
 		return err
@@ -176,6 +247,14 @@ func (c *Command) ExecuteWithClient(ctx context.Context, client watchman.Client)
 		return err
 	}
 
+	if err := credential.Check(cfg.Handlers); err != nil {
+		return err
+	}
+
+	if err := execshim.CheckLandlock(cfg.Handlers); err != nil {
+		return err
+	}
+
 	if err := watchman.WaitForReady(ctx, client); err != nil {
 		return err
 	}
@@ -194,14 +273,61 @@ func (c *Command) ExecuteWithClient(ctx context.Context, client watchman.Client)
 		multierr.AppendInto(&err, cleanup.CallWithTimeout(c.shutdownTimeout))
 	}()
 
+	// Registered first to flush spans after everything else has stopped.
+	stopTracing, err := tracing.Setup(ctx, c.tracingOpts)
+	if err != nil {
+		return fmt.Errorf("tracing setup failed: %w", err)
+	}
+
+	cleanup.Append(stopTracing)
+
+	events := eventbus.New(eventbus.DefaultHistory)
+
 	r := newRouter(routerOptions{
 		handlers: cfg.Handlers,
+		events:   events,
+		cgroups:  setupCgroups(logger, cfg.Handlers),
 	})
 	r.start(int(c.slotCount))
 	cleanup.Append(r.stop)
 
+	socketPath := filepath.Join(tmpdir, "server.socket")
+
+	triggerGroup := &watchmantrigger.Group{
+		Client:     client,
+		SocketPath: socketPath,
+	}
+
+	health := &healthChecker{
+		router:   r,
+		triggers: triggerGroup,
+	}
+
+	var supervisor *triggerSupervisor
+
+	if c.triggerInterval > 0 {
+		supervisor = newTriggerSupervisor(triggerGroup, cfg.Handlers, c.triggerInterval)
+		r.registerMetrics(supervisor.metrics())
+		health.triggers = supervisor
+	}
+
+	if c.probeInterval > 0 {
+		health.probe = newWatchmanProbe(client, c.probeInterval)
+		cleanup.Append(health.probe.start())
+	}
+
 	if c.metricsAddress != "" {
-		metricsURL, stop, err := listenAndServeMetrics(logger, c.metricsAddress, r.metrics())
+		endpoints := []httpEndpoints{health}
+
+		if c.statusPage {
+			endpoints = append(endpoints, &statusPage{router: r})
+		}
+
+		if c.eventStream {
+			endpoints = append(endpoints, &eventStream{bus: events})
+		}
+
+		metricsURL, stop, err := listenAndServeMetrics(logger, c.metricsAddress, r.metrics(), endpoints...)
 		if err != nil {
 			return err
 		}
@@ -211,12 +337,6 @@ func (c *Command) ExecuteWithClient(ctx context.Context, client watchman.Client)
 		logger.Info("Metrics server ready", zap.String("address", metricsURL))
 	}
 
-	socketPath := filepath.Join(tmpdir, "server.socket")
-
-	triggerGroup := watchmantrigger.Group{
-		Client:     client,
-		SocketPath: socketPath,
-	}
 	cleanup.Append(triggerGroup.DeleteAll)
 
 	srv, err := service.ListenAndServe(socketPath, r)
@@ -226,16 +346,31 @@ func (c *Command) ExecuteWithClient(ctx context.Context, client watchman.Client)
 
 	defer multierr.AppendInvoke(&err, multierr.Close(srv))
 
+	health.setSocketListening(true)
+
 	logger.Info("Socket is ready", zap.String("path", socketPath))
 
 	if err := triggerGroup.SetAll(ctx, cfg.Handlers); err != nil {
 		return err
 	}
 
+	if c.startupScan {
+		// Errors are not fatal as Watchman still reports all files.
+		if count, err := r.reconcile(ctx); err != nil {
+			logger.Error("Startup scan failed", zap.Int("queued", count), zap.Error(err))
+		} else {
+			logger.Info("Startup scan finished", zap.Int("queued", count))
+		}
+	}
+
 	if err := triggerGroup.RecrawlAll(ctx); err != nil {
 		return err
 	}
 
+	if supervisor != nil {
+		cleanup.Append(supervisor.start())
+	}
+
 	r.startPruning(c.pruneInterval)
 
 	if err := waitForSignal(ctx); err != nil {
@@ -0,0 +1,91 @@
+package watch
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/diskspace"
+	"github.com/hansmi/baamhackl/internal/relpath"
+	"go.uber.org/zap"
+)
+
+// How long to defer tasks while free disk space is insufficient.
+const diskSpaceRetryDelay = time.Minute
+
+// diskSpaceGuard verifies that the filesystems used by a handler have enough
+// free space before commands are started.
+type diskSpaceGuard struct {
+	threshold diskspace.Threshold
+	paths     []string
+	stat      func(string) (diskspace.Usage, error)
+
+	mu  sync.Mutex
+	low bool
+}
+
+func newDiskSpaceGuard(cfg *config.Handler) *diskSpaceGuard {
+	g := &diskSpaceGuard{
+		threshold: diskspace.Threshold{
+			MinFreeBytes:   cfg.MinFreeBytes,
+			MinFreePercent: cfg.MinFreePercent,
+		},
+		paths: []string{cfg.Path},
+		stat:  diskspace.Stat,
+	}
+
+	if r, err := relpath.Resolve(cfg.Path, cfg.JournalDir); err == nil && !r.Contained() {
+		g.paths = append(g.paths, r.Path)
+	}
+
+	return g
+}
+
+// isLow returns whether free space was insufficient on the most recent check.
+func (g *diskSpaceGuard) isLow() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.low
+}
+
+// check returns an error wrapping diskspace.ErrLow when at least one
+// filesystem has insufficient free space. Changes are logged only once.
+// Failures to determine the available space are logged and ignored.
+func (g *diskSpaceGuard) check(logger *zap.Logger) error {
+	if !g.threshold.Enabled() {
+		return nil
+	}
+
+	var lowErr error
+
+	for _, path := range g.paths {
+		usage, err := g.stat(path)
+		if err == nil {
+			err = g.threshold.Check(usage)
+		}
+
+		if errors.Is(err, diskspace.ErrLow) {
+			lowErr = err
+			break
+		} else if err != nil {
+			logger.Error("Determining free disk space failed", zap.Error(err))
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if low := lowErr != nil; low != g.low {
+		if low {
+			logger.Warn("Deferring tasks until enough disk space is available", zap.Error(lowErr))
+		} else {
+			logger.Info("Enough disk space available, resuming tasks")
+		}
+
+		g.low = low
+	}
+
+	return lowErr
+}
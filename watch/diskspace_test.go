@@ -0,0 +1,137 @@
+package watch
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/diskspace"
+	"github.com/hansmi/baamhackl/internal/eventbus"
+	"github.com/hansmi/baamhackl/internal/handlertask"
+	"github.com/hansmi/baamhackl/internal/scheduler"
+	"github.com/hansmi/baamhackl/internal/testutil"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestNewDiskSpaceGuard(t *testing.T) {
+	tmpdir := t.TempDir()
+	journalDir := t.TempDir()
+
+	for _, tc := range []struct {
+		name       string
+		journalDir string
+		want       []string
+	}{
+		{
+			name:       "default",
+			journalDir: config.HandlerDefaults.JournalDir,
+			want:       []string{tmpdir},
+		},
+		{
+			name:       "external journal",
+			journalDir: journalDir,
+			want:       []string{tmpdir, journalDir},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.HandlerDefaults
+			cfg.Path = tmpdir
+			cfg.JournalDir = tc.journalDir
+
+			g := newDiskSpaceGuard(&cfg)
+
+			if diff := cmp.Diff(tc.want, g.paths); diff != "" {
+				t.Errorf("Paths diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDiskSpaceGuard(t *testing.T) {
+	cfg := config.HandlerDefaults
+	cfg.Path = t.TempDir()
+	cfg.MinFreePercent = 10
+
+	var free uint64
+
+	g := newDiskSpaceGuard(&cfg)
+	g.stat = func(path string) (diskspace.Usage, error) {
+		return diskspace.Usage{Path: path, Free: free, Total: 100}, nil
+	}
+
+	loggerCore, observed := observer.New(zapcore.InfoLevel)
+	logger := zap.New(loggerCore)
+
+	for _, tc := range []struct {
+		free         uint64
+		wantErr      error
+		wantLogCount int
+	}{
+		{free: 50},
+		{free: 5, wantErr: diskspace.ErrLow, wantLogCount: 1},
+		{free: 6, wantErr: diskspace.ErrLow, wantLogCount: 1},
+		{free: 10, wantLogCount: 2},
+		{free: 99, wantLogCount: 2},
+	} {
+		free = tc.free
+
+		err := g.check(logger)
+
+		if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
+			t.Errorf("Error diff (-want +got):\n%s", diff)
+		}
+
+		if diff := cmp.Diff(tc.wantErr != nil, g.isLow()); diff != "" {
+			t.Errorf("Low state diff (-want +got):\n%s", diff)
+		}
+
+		if diff := cmp.Diff(tc.wantLogCount, observed.Len()); diff != "" {
+			t.Errorf("Log message count diff (-want +got):\n%s", diff)
+		}
+	}
+}
+
+func TestHandlerInvokeTaskDiskSpaceLow(t *testing.T) {
+	cfg := config.HandlerDefaults
+	cfg.Path = t.TempDir()
+	cfg.MinFreeBytes = 1024
+
+	h := newHandler(&cfg, eventbus.Emitter{})
+	h.diskSpace.stat = func(path string) (diskspace.Usage, error) {
+		return diskspace.Usage{Path: path, Free: 1, Total: 1024 * 1024}, nil
+	}
+	h.invoke = func(context.Context, *handlertask.Task, func()) error {
+		t.Errorf("Task invoked despite low disk space")
+		return nil
+	}
+
+	err := h.invokeTask(context.Background(), &pendingTask{
+		Task: handlertask.New(handlertask.Options{
+			Name: "test",
+		}),
+	})
+
+	if te := scheduler.AsTaskError(err); te.Permanent() || !te.Deferred {
+		t.Errorf("invokeTask() returned %#v, want deferral", te)
+	}
+
+	if diff := cmp.Diff(diskspace.ErrLow, err, cmpopts.EquateErrors()); diff != "" {
+		t.Errorf("Error diff (-want +got):\n%s", diff)
+	}
+
+	testutil.CollectAndCompare(t, h.metrics(), `
+		# HELP disk_space_low Whether tasks are deferred due to insufficient free disk space.
+		# TYPE disk_space_low gauge
+		disk_space_low 1
+		# HELP retries_total Number of retries.
+		# TYPE retries_total counter
+		retries_total 0
+		`,
+		"disk_space_low",
+		"retries_total",
+	)
+}
@@ -0,0 +1,138 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hansmi/baamhackl/internal/eventbus"
+	"go.uber.org/zap"
+)
+
+// Interval for keepalive comments on idle server-sent event streams.
+const eventStreamKeepalive = 30 * time.Second
+
+// eventStream serves lifecycle events via HTTP, either as server-sent events
+// or as newline-delimited JSON.
+type eventStream struct {
+	bus *eventbus.Bus
+}
+
+// writeServerSentEvent formats an event according to the server-sent events
+// specification. The event type is used as the event name.
+func writeServerSentEvent(w io.Writer, e eventbus.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Type, data)
+
+	return err
+}
+
+// startCursor determines the sequence number after which events are sent.
+// Clients may resume a stream using the Last-Event-ID header or the "after"
+// parameter. By default only new events are sent.
+func (s *eventStream) startCursor(r *http.Request) (uint64, error) {
+	value := r.Header.Get("Last-Event-ID")
+
+	if value == "" {
+		value = r.URL.Query().Get("after")
+	}
+
+	if value == "" {
+		return s.bus.LastSeq(), nil
+	}
+
+	return strconv.ParseUint(value, 10, 64)
+}
+
+func (s *eventStream) serve(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	sse := false
+
+	switch query.Get("format") {
+	case "sse":
+		sse = true
+	case "ndjson":
+	case "":
+		sse = strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	default:
+		http.Error(w, "Unsupported format", http.StatusBadRequest)
+		return
+	}
+
+	cursor, err := s.startCursor(r)
+	if err != nil {
+		http.Error(w, "Invalid event ID", http.StatusBadRequest)
+		return
+	}
+
+	filter := eventbus.HandlerFilter(query["handler"])
+
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	rc := http.NewResponseController(w)
+	enc := json.NewEncoder(w)
+
+	for {
+		if err := rc.Flush(); err != nil {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), eventStreamKeepalive)
+		events, next, err := s.bus.Wait(ctx, cursor, filter)
+		cancel()
+
+		cursor = next
+
+		if err != nil {
+			if r.Context().Err() != nil {
+				return
+			}
+
+			if errors.Is(err, context.DeadlineExceeded) {
+				if sse {
+					io.WriteString(w, ": keepalive\n\n")
+				}
+				continue
+			}
+
+			zap.L().Error("Waiting for events failed", zap.Error(err))
+			return
+		}
+
+		for _, e := range events {
+			if sse {
+				err = writeServerSentEvent(w, e)
+			} else {
+				err = enc.Encode(e)
+			}
+
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// register adds the /events endpoint to the given mux.
+func (s *eventStream) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /events", s.serve)
+}
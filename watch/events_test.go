@@ -0,0 +1,167 @@
+package watch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hansmi/baamhackl/internal/eventbus"
+)
+
+func TestEventStream(t *testing.T) {
+	bus := eventbus.New(0)
+
+	first := eventbus.Emitter{Bus: bus, Handler: "first"}
+	second := eventbus.Emitter{Bus: bus, Handler: "second"}
+
+	first.WithName("a.txt").Emit(eventbus.Event{Type: eventbus.FileReported})
+	second.WithName("b.txt").Emit(eventbus.Event{Type: eventbus.FileReported})
+	first.WithName("a.txt").Emit(eventbus.Event{Type: eventbus.TaskQueued})
+
+	mux := http.NewServeMux()
+	(&eventStream{bus: bus}).register(mux)
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	for _, tc := range []struct {
+		name            string
+		query           string
+		header          http.Header
+		wantStatus      int
+		wantContentType string
+		wantLines       []string
+	}{
+		{
+			name:            "ndjson",
+			query:           "?after=0&handler=first",
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/x-ndjson",
+			wantLines: []string{
+				`{"seq":1,"type":"file_reported","handler":"first","name":"a.txt"}`,
+				`{"seq":3,"type":"task_queued","handler":"first","name":"a.txt"}`,
+			},
+		},
+		{
+			name:  "sse",
+			query: "?handler=second",
+			header: http.Header{
+				"Accept":        {"text/event-stream"},
+				"Last-Event-ID": {"0"},
+			},
+			wantStatus:      http.StatusOK,
+			wantContentType: "text/event-stream",
+			wantLines: []string{
+				"id: 2",
+				"event: file_reported",
+				`data: {"seq":2,"type":"file_reported","handler":"second","name":"b.txt"}`,
+				"",
+			},
+		},
+		{
+			name:            "resume",
+			query:           "?format=ndjson&after=2",
+			wantStatus:      http.StatusOK,
+			wantContentType: "application/x-ndjson",
+			wantLines: []string{
+				`{"seq":3,"type":"task_queued","handler":"first","name":"a.txt"}`,
+			},
+		},
+		{
+			name:       "bad format",
+			query:      "?format=xml",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "bad cursor",
+			query:      "?after=x",
+			wantStatus: http.StatusBadRequest,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/events"+tc.query, nil)
+			if err != nil {
+				t.Fatalf("NewRequest() failed: %v", err)
+			}
+
+			for key, values := range tc.header {
+				req.Header[key] = values
+			}
+
+			resp, err := server.Client().Do(req)
+			if err != nil {
+				t.Fatalf("Do() failed: %v", err)
+			}
+
+			defer resp.Body.Close()
+
+			if diff := cmp.Diff(tc.wantStatus, resp.StatusCode); diff != "" {
+				t.Errorf("Status diff (-want +got):\n%s", diff)
+			}
+
+			if resp.StatusCode != http.StatusOK {
+				return
+			}
+
+			if diff := cmp.Diff(tc.wantContentType, resp.Header.Get("Content-Type")); diff != "" {
+				t.Errorf("Content type diff (-want +got):\n%s", diff)
+			}
+
+			scanner := bufio.NewScanner(resp.Body)
+
+			var lines []string
+
+			for len(lines) < len(tc.wantLines) && scanner.Scan() {
+				lines = append(lines, normalizeEventLine(t, scanner.Text()))
+			}
+
+			if diff := cmp.Diff(tc.wantLines, lines); diff != "" {
+				t.Errorf("Stream diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// normalizeEventLine removes the event time from JSON-encoded events.
+func normalizeEventLine(t *testing.T, line string) string {
+	t.Helper()
+
+	prefix := ""
+
+	if rest, ok := strings.CutPrefix(line, "data: "); ok {
+		prefix, line = "data: ", rest
+	}
+
+	if !strings.HasPrefix(line, "{") {
+		return prefix + line
+	}
+
+	var e eventbus.Event
+
+	if err := json.Unmarshal([]byte(line), &e); err != nil {
+		t.Fatalf("Unmarshal(%q) failed: %v", line, err)
+	}
+
+	e.Time = time.Time{}
+
+	data, err := json.Marshal(struct {
+		Seq     uint64        `json:"seq"`
+		Type    eventbus.Type `json:"type"`
+		Handler string        `json:"handler"`
+		Name    string        `json:"name"`
+	}{e.Seq, e.Type, e.Handler, e.Name})
+	if err != nil {
+		t.Fatalf("Marshal() failed: %v", err)
+	}
+
+	return prefix + string(data)
+}
@@ -5,44 +5,175 @@ import (
 	"errors"
 	"path/filepath"
 	"sync"
+	"time"
 
+	"github.com/hansmi/baamhackl/internal/cgroup"
 	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/eventbus"
+	"github.com/hansmi/baamhackl/internal/fuzzduration"
 	"github.com/hansmi/baamhackl/internal/handlertask"
 	"github.com/hansmi/baamhackl/internal/journal"
 	"github.com/hansmi/baamhackl/internal/scheduler"
 	"github.com/hansmi/baamhackl/internal/service"
+	"github.com/hansmi/baamhackl/internal/tracing"
 	"github.com/hansmi/baamhackl/internal/waryio"
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
 )
 
+// pendingTask tracks the progress of a task until it's finished.
+type pendingTask struct {
+	*handlertask.Task
+
+	// Time when the file change was reported.
+	reported time.Time
+
+	// Time when the first attempt started. Zero until then.
+	started time.Time
+
+	// Whether an attempt is currently running.
+	running bool
+
+	// Number of attempts started so far. Deferrals are not counted.
+	attempts int
+
+	// Earliest time for the next attempt after a failure.
+	nextRetry time.Time
+
+	// Error returned by the most recent attempt.
+	lastErr error
+
+	// Span covering all attempts. Nil in tests.
+	span trace.Span
+}
+
+// Number of finished tasks retained per handler for the status page.
+const recentCompletionLimit = 25
+
+// taskCompletion records a task which has finished, either successfully or
+// with a permanent failure.
+type taskCompletion struct {
+	// Identifier unique within the handler.
+	id int
+
+	name     string
+	journal  string
+	attempts int
+	finished time.Time
+	err      error
+}
+
 type handler struct {
 	mu      sync.Mutex
 	name    string
 	cfg     *config.Handler
-	pending map[string]*handlertask.Task
+	pending map[string]*pendingTask
 	journal *journal.Journal
 	mc      *handlerMetricsCollector
+	events  eventbus.Emitter
+
+	// Time of the most recent successful completion.
+	lastSuccess time.Time
+
+	// Most recently finished tasks, newest first.
+	recent           []taskCompletion
+	nextCompletionID int
+
+	diskSpace *diskSpaceGuard
+
+	// Manager for per-command cgroups. Nil if cgroups aren't available.
+	cgroups *cgroup.Manager
 
 	invoke func(context.Context, *handlertask.Task, func()) error
 }
 
-func newHandler(cfg *config.Handler) *handler {
+func newHandler(cfg *config.Handler, events eventbus.Emitter) *handler {
 	h := &handler{
 		name:    cfg.Name,
 		cfg:     cfg,
 		journal: journal.New(cfg),
-		pending: map[string]*handlertask.Task{},
+		pending: map[string]*pendingTask{},
+		events:  events,
+
+		diskSpace: newDiskSpaceGuard(cfg),
+
 		invoke: func(ctx context.Context, t *handlertask.Task, acquireLock func()) error {
 			return t.Run(ctx, acquireLock)
 		},
 	}
 
 	h.mc = newHandlerMetricsCollector(h)
+	h.journal.SetEvents(events)
 
 	return h
 }
 
+// handlerState summarizes the tasks of a handler at a point in time.
+type handlerState struct {
+	pending   int
+	running   int
+	retryWait int
+
+	// Report time of the oldest pending task.
+	oldestReported time.Time
+
+	// Time of the most recent successful completion.
+	lastSuccess time.Time
+}
+
+func (h *handler) state() handlerState {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := handlerState{
+		pending:     len(h.pending),
+		lastSuccess: h.lastSuccess,
+	}
+
+	for _, t := range h.pending {
+		if t.running {
+			s.running++
+		} else if !t.started.IsZero() {
+			s.retryWait++
+		}
+
+		if s.oldestReported.IsZero() || t.reported.Before(s.oldestReported) {
+			s.oldestReported = t.reported
+		}
+	}
+
+	return s
+}
+
+// recordCompletion adds a finished task to the list of recent completions. The
+// handler lock must be held.
+func (h *handler) recordCompletion(c taskCompletion) {
+	c.id = h.nextCompletionID
+	h.nextCompletionID++
+
+	h.recent = append([]taskCompletion{c}, h.recent...)
+
+	if len(h.recent) > recentCompletionLimit {
+		h.recent = h.recent[:recentCompletionLimit]
+	}
+}
+
+// completion returns the recently finished task with the given identifier.
+func (h *handler) completion(id int) (taskCompletion, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, c := range h.recent {
+		if c.id == id {
+			return c, true
+		}
+	}
+
+	return taskCompletion{}, false
+}
+
 func (h *handler) metrics() prometheus.Collector {
 	return h.mc
 }
@@ -53,10 +184,43 @@ func (h *handler) newTask(name string) *handlertask.Task {
 		Journal: h.journal,
 		Name:    name,
 		Metrics: h.mc,
+		Events:  h.events,
+		Cgroups: h.cgroups,
 	})
 }
 
-func (h *handler) invokeTask(ctx context.Context, t *handlertask.Task) error {
+func (h *handler) invokeTask(ctx context.Context, t *pendingTask) (err error) {
+	if t.span != nil {
+		ctx = trace.ContextWithSpan(ctx, t.span)
+	}
+
+	ctx, span := tracing.Start(ctx, "scheduler.task",
+		tracing.HandlerKey.String(h.name),
+		tracing.FileKey.String(t.Name()),
+	)
+	defer func() {
+		tracing.End(span, err)
+	}()
+
+	if err := h.diskSpace.check(zap.L().With(zap.String("handler", h.name))); err != nil {
+		// Wait for space to become available without consuming an attempt.
+		return &scheduler.TaskError{
+			Err:        err,
+			RetryDelay: fuzzduration.Random(diskSpaceRetryDelay, 0.1),
+			Deferred:   true,
+		}
+	}
+
+	h.mu.Lock()
+	if t.started.IsZero() {
+		t.started = clock.Now()
+		h.mc.ReportTaskStarted(t.started.Sub(t.reported))
+	}
+	t.running = true
+	t.attempts++
+	t.nextRetry = time.Time{}
+	h.mu.Unlock()
+
 	locked := false
 
 	defer func() {
@@ -73,23 +237,66 @@ func (h *handler) invokeTask(ctx context.Context, t *handlertask.Task) error {
 		}
 	}
 
-	err := h.invoke(ctx, t, acquireLock)
+	err = h.invoke(ctx, t.Task, acquireLock)
+
+	acquireLock()
+
+	t.running = false
+	t.lastErr = err
+
+	if te := scheduler.AsTaskError(err); te.Permanent() {
+		now := clock.Now()
 
-	if scheduler.AsTaskError(err).Permanent() {
 		h.mc.ReportFinalTaskStatus(err)
+		h.mc.ReportTaskCompleted(now.Sub(t.reported))
+
+		if err == nil {
+			h.lastSuccess = now
+		}
+
+		if t.span != nil {
+			t.span.SetAttributes(attribute.Int("baamhackl.attempts", t.attempts))
+			tracing.End(t.span, err)
+		}
+
+		finished := eventbus.Event{
+			Type:    eventbus.TaskFinished,
+			Name:    t.Name(),
+			Success: eventbus.Bool(err == nil),
+			Path:    t.JournalDir(),
+		}
+
+		if err != nil {
+			finished.Error = err.Error()
+		}
 
-		acquireLock()
+		h.events.Emit(finished)
+
+		h.recordCompletion(taskCompletion{
+			name:     t.Name(),
+			journal:  t.JournalDir(),
+			attempts: t.attempts,
+			finished: now,
+			err:      err,
+		})
 
 		// Remove from pending tasks
 		delete(h.pending, t.Name())
 	} else {
+		t.nextRetry = clock.Now().Add(te.RetryDelay)
+
 		h.mc.ReportTaskRetry()
 	}
 
 	return err
 }
 
-func (h *handler) handle(sched *scheduler.Scheduler, req service.FileChangedRequest) error {
+func (h *handler) handle(ctx context.Context, sched *scheduler.Scheduler, req service.FileChangedRequest) (err error) {
+	ctx, span := tracing.Start(ctx, "handler.handle")
+	defer func() {
+		tracing.End(span, err)
+	}()
+
 	logger := zap.L()
 
 	if ok, err := waryio.SameStat(req.RootDir, h.cfg.Path); err != nil {
@@ -103,21 +310,64 @@ func (h *handler) handle(sched *scheduler.Scheduler, req service.FileChangedRequ
 	h.mu.Lock()
 	defer h.mu.Unlock()
 
+	queued := false
+
 	if t := h.pending[name]; t == nil {
-		t = h.newTask(name)
+		t = &pendingTask{
+			Task:     h.newTask(name),
+			reported: clock.Now(),
+		}
+
+		// The span ends when the task is finished.
+		_, t.span = tracing.Start(ctx, "file",
+			tracing.HandlerKey.String(h.name),
+			tracing.FileKey.String(name),
+		)
+
 		h.pending[name] = t
 		sched.Add(func(ctx context.Context) error {
 			return h.invokeTask(ctx, t)
 		})
+
+		queued = true
 	} else {
 		logger.Debug("File already in queue", zap.String("name", name))
 	}
 
+	h.pending[name].ReportChange(req.Change)
+
 	h.mc.ReportFileChange()
+	h.events.Emit(eventbus.Event{
+		Type: eventbus.FileReported,
+		Name: name,
+	})
+
+	if queued {
+		h.events.Emit(eventbus.Event{
+			Type: eventbus.TaskQueued,
+			Name: name,
+		})
+	}
 
 	return nil
 }
 
+// compress replaces old journal entries with archives. Entries belonging to
+// pending tasks are left untouched.
+func (h *handler) compress(ctx context.Context) error {
+	var active []string
+
+	h.mu.Lock()
+	for _, t := range h.pending {
+		if dir := t.JournalDir(); dir != "" {
+			active = append(active, dir)
+		}
+	}
+	h.mu.Unlock()
+
+	return h.journal.Compress(ctx, zap.L(), active)
+}
+
 func (h *handler) prune(ctx context.Context) error {
 	h.mu.Lock()
 	defer h.mu.Unlock()
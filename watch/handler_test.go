@@ -3,6 +3,7 @@ package watch
 import (
 	"context"
 	"errors"
+	"fmt"
 	"path/filepath"
 	"testing"
 	"time"
@@ -10,6 +11,7 @@ import (
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/eventbus"
 	"github.com/hansmi/baamhackl/internal/handlertask"
 	"github.com/hansmi/baamhackl/internal/scheduler"
 	"github.com/hansmi/baamhackl/internal/service"
@@ -43,7 +45,7 @@ func TestHandlerInvokeTask(t *testing.T) {
 			cfg.Path = t.TempDir()
 
 			for _, lockEarly := range []bool{false, true} {
-				h := newHandler(&cfg)
+				h := newHandler(&cfg, eventbus.Emitter{})
 				h.invoke = func(ctx context.Context, t *handlertask.Task, acquireLock func()) error {
 					if lockEarly {
 						acquireLock()
@@ -55,18 +57,63 @@ func TestHandlerInvokeTask(t *testing.T) {
 				ctx, cancel := context.WithCancel(context.Background())
 				t.Cleanup(cancel)
 
-				err := h.invokeTask(ctx, handlertask.New(handlertask.Options{
-					Name: "test",
-				}))
+				err := h.invokeTask(ctx, &pendingTask{
+					Task: handlertask.New(handlertask.Options{
+						Name: "test",
+					}),
+				})
 
 				if diff := cmp.Diff(tc.wantErr, err, cmpopts.EquateErrors()); diff != "" {
 					t.Errorf("Error diff (-want +got):\n%s", diff)
 				}
+
+				if c, ok := h.completion(0); !ok {
+					t.Errorf("Completion not recorded")
+				} else {
+					if diff := cmp.Diff("test", c.name); diff != "" {
+						t.Errorf("Completion name diff (-want +got):\n%s", diff)
+					}
+
+					if diff := cmp.Diff(1, c.attempts); diff != "" {
+						t.Errorf("Completion attempts diff (-want +got):\n%s", diff)
+					}
+
+					if diff := cmp.Diff(tc.wantErr, c.err, cmpopts.EquateErrors()); diff != "" {
+						t.Errorf("Completion error diff (-want +got):\n%s", diff)
+					}
+				}
 			}
 		})
 	}
 }
 
+func TestHandlerRecordCompletion(t *testing.T) {
+	cfg := config.HandlerDefaults
+	cfg.Path = t.TempDir()
+
+	h := newHandler(&cfg, eventbus.Emitter{})
+
+	for i := range recentCompletionLimit + 5 {
+		h.recordCompletion(taskCompletion{
+			name: fmt.Sprint(i),
+		})
+	}
+
+	if diff := cmp.Diff(recentCompletionLimit, len(h.recent)); diff != "" {
+		t.Errorf("Completion count diff (-want +got):\n%s", diff)
+	}
+
+	if _, ok := h.completion(0); ok {
+		t.Errorf("Oldest completion was retained")
+	}
+
+	if c, ok := h.completion(recentCompletionLimit + 4); !ok {
+		t.Errorf("Newest completion is missing")
+	} else if diff := cmp.Diff(fmt.Sprint(recentCompletionLimit+4), c.name); diff != "" {
+		t.Errorf("Completion name diff (-want +got):\n%s", diff)
+	}
+}
+
 func TestHandler(t *testing.T) {
 	sched := scheduler.New()
 	sched.Start()
@@ -190,8 +237,9 @@ func TestHandler(t *testing.T) {
 			cfg.Path = rootDir
 
 			calls := 0
+			bus := eventbus.New(0)
 
-			h := newHandler(&cfg)
+			h := newHandler(&cfg, eventbus.Emitter{Bus: bus, Handler: "test"})
 			h.invoke = func(ctx context.Context, task *handlertask.Task, acquireLock func()) error {
 				acquireLock()
 
@@ -210,7 +258,7 @@ func TestHandler(t *testing.T) {
 			}
 			req.Change.Name = filepath.Join("dir", tc.name)
 
-			if err := h.handle(sched, req); err != nil {
+			if err := h.handle(context.Background(), sched, req); err != nil {
 				t.Errorf("handle(%+v) failed: %v", req, err)
 			}
 
@@ -223,12 +271,39 @@ func TestHandler(t *testing.T) {
 				t.Errorf("Attempt count diff (-want +got):\n%s", diff)
 			}
 
-			if diff := cmp.Diff(map[string]*handlertask.Task{}, h.pending); diff != "" {
+			if diff := cmp.Diff(0, len(h.pending)); diff != "" {
 				t.Errorf("Pending tasks diff (-want +got):\n%s", diff)
 			}
 			h.mu.Unlock()
 
 			testutil.CollectAndCompare(t, h.metrics(), tc.wantMetrics, tc.metricNames...)
+
+			events, _, err := bus.Wait(context.Background(), 0, nil)
+			if err != nil {
+				t.Errorf("Wait() failed: %v", err)
+			}
+
+			var eventTypes []eventbus.Type
+
+			for _, e := range events {
+				eventTypes = append(eventTypes, e.Type)
+
+				if diff := cmp.Diff(req.Change.Name, e.Name); diff != "" {
+					t.Errorf("Event name diff (-want +got):\n%s", diff)
+				}
+			}
+
+			if diff := cmp.Diff([]eventbus.Type{
+				eventbus.FileReported,
+				eventbus.TaskQueued,
+				eventbus.TaskFinished,
+			}, eventTypes); diff != "" {
+				t.Errorf("Event diff (-want +got):\n%s", diff)
+			}
+
+			if success := events[len(events)-1].Success; success == nil || *success != (tc.wantErr == nil) {
+				t.Errorf("Task finished event has wrong outcome: %+v", events[len(events)-1])
+			}
 		})
 	}
 }
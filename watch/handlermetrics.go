@@ -21,6 +21,19 @@ func makeHandlerTimeBuckets(cfg *config.Handler) []float64 {
 	return makeSecondsBuckets(timeBucketsMin, timeBucketsMax, 10)
 }
 
+// makeHandlerLatencyBuckets returns buckets for the time files spend waiting,
+// ranging from a second up to the journal retention.
+func makeHandlerLatencyBuckets(cfg *config.Handler) []float64 {
+	latencyBucketsMin := time.Second
+	latencyBucketsMax := cfg.JournalRetention
+
+	if latencyBucketsMax <= latencyBucketsMin {
+		latencyBucketsMax = latencyBucketsMin + 1
+	}
+
+	return makeSecondsBuckets(latencyBucketsMin, latencyBucketsMax, 15)
+}
+
 type handlerMetricsCollector struct {
 	mu sync.Mutex
 
@@ -30,7 +43,15 @@ type handlerMetricsCollector struct {
 
 	fileChangeCount prometheus.Counter
 
-	pendingTasksDesc *prometheus.Desc
+	pendingTasksDesc     *prometheus.Desc
+	runningTasksDesc     *prometheus.Desc
+	retryWaitTasksDesc   *prometheus.Desc
+	oldestPendingAgeDesc *prometheus.Desc
+	lastSuccessDesc      *prometheus.Desc
+	diskSpaceLowDesc     *prometheus.Desc
+
+	taskStartLatency      prometheus.Histogram
+	taskCompletionLatency prometheus.Histogram
 
 	retryCount    prometheus.Counter
 	finishedCount prometheus.Counter
@@ -40,6 +61,8 @@ type handlerMetricsCollector struct {
 	commandWallTime      prometheus.Histogram
 	commandUserTime      prometheus.Histogram
 	commandSystemTime    prometheus.Histogram
+	commandMemoryPeak    prometheus.Histogram
+	commandOOMKillCount  prometheus.Counter
 
 	nested []prometheus.Collector
 }
@@ -48,6 +71,7 @@ var _ prometheus.Collector = (*handlerMetricsCollector)(nil)
 
 func newHandlerMetricsCollector(h *handler) *handlerMetricsCollector {
 	timeBuckets := makeHandlerTimeBuckets(h.cfg)
+	latencyBuckets := makeHandlerLatencyBuckets(h.cfg)
 
 	c := &handlerMetricsCollector{
 		h: h,
@@ -69,6 +93,32 @@ func newHandlerMetricsCollector(h *handler) *handlerMetricsCollector {
 	c.pendingTasksDesc = prometheus.NewDesc("pending_total",
 		"Number of currently waiting tasks.", nil, nil)
 
+	c.runningTasksDesc = prometheus.NewDesc("running_tasks",
+		"Number of tasks with a currently running attempt.", nil, nil)
+
+	c.retryWaitTasksDesc = prometheus.NewDesc("retry_waiting_tasks",
+		"Number of tasks waiting for their next attempt after a failure.", nil, nil)
+
+	c.oldestPendingAgeDesc = prometheus.NewDesc("oldest_pending_age_seconds",
+		"Time since the change of the oldest pending file was reported.", nil, nil)
+
+	c.lastSuccessDesc = prometheus.NewDesc("last_success_timestamp_seconds",
+		"Time of the most recent successful completion as a Unix timestamp.", nil, nil)
+
+	c.taskStartLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "task_start_latency",
+		Help:    "Histogram with the time from a reported file change to the start of its first attempt.",
+		Buckets: latencyBuckets,
+	})
+	c.taskCompletionLatency = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "task_completion_latency",
+		Help:    "Histogram with the time from a reported file change to its final completion.",
+		Buckets: latencyBuckets,
+	})
+
+	c.diskSpaceLowDesc = prometheus.NewDesc("disk_space_low",
+		"Whether tasks are deferred due to insufficient free disk space.", nil, nil)
+
 	c.retryCount = prometheus.NewCounter(prometheus.CounterOpts{
 		Name: "retries_total",
 		Help: "Number of retries.",
@@ -104,6 +154,16 @@ func newHandlerMetricsCollector(h *handler) *handlerMetricsCollector {
 		Buckets: timeBuckets,
 	})
 
+	c.commandMemoryPeak = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "command_memory_peak_bytes",
+		Help:    "Histogram with the peak memory usage of commands running in a dedicated cgroup.",
+		Buckets: prometheus.ExponentialBuckets(1<<20, 4, 10),
+	})
+	c.commandOOMKillCount = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "command_oom_kills_total",
+		Help: "Number of processes killed for exceeding the cgroup memory limit.",
+	})
+
 	c.nested = append(c.nested,
 		c.fileChangeCount,
 
@@ -111,10 +171,15 @@ func newHandlerMetricsCollector(h *handler) *handlerMetricsCollector {
 		c.commandWallTime,
 		c.commandUserTime,
 		c.commandSystemTime,
+		c.commandMemoryPeak,
+		c.commandOOMKillCount,
 
 		c.retryCount,
 		c.finishedCount,
 		c.failureCount,
+
+		c.taskStartLatency,
+		c.taskCompletionLatency,
 	)
 
 	return c
@@ -135,6 +200,13 @@ func (c *handlerMetricsCollector) ReportProcessState(exitCode int, wallTime, use
 	c.mu.Unlock()
 }
 
+func (c *handlerMetricsCollector) ReportCgroupStats(memoryPeak, oomKills uint64) {
+	c.mu.Lock()
+	c.commandMemoryPeak.Observe(float64(memoryPeak))
+	c.commandOOMKillCount.Add(float64(oomKills))
+	c.mu.Unlock()
+}
+
 func (c *handlerMetricsCollector) ReportTaskRetry() {
 	c.retryCount.Inc()
 }
@@ -148,9 +220,26 @@ func (c *handlerMetricsCollector) ReportFinalTaskStatus(err error) {
 	c.mu.Unlock()
 }
 
+func (c *handlerMetricsCollector) ReportTaskStarted(latency time.Duration) {
+	c.mu.Lock()
+	c.taskStartLatency.Observe(latency.Seconds())
+	c.mu.Unlock()
+}
+
+func (c *handlerMetricsCollector) ReportTaskCompleted(latency time.Duration) {
+	c.mu.Lock()
+	c.taskCompletionLatency.Observe(latency.Seconds())
+	c.mu.Unlock()
+}
+
 func (c *handlerMetricsCollector) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.infoMetric.Desc()
 	ch <- c.pendingTasksDesc
+	ch <- c.runningTasksDesc
+	ch <- c.retryWaitTasksDesc
+	ch <- c.oldestPendingAgeDesc
+	ch <- c.lastSuccessDesc
+	ch <- c.diskSpaceLowDesc
 
 	for _, i := range c.nested {
 		i.Describe(ch)
@@ -166,7 +255,29 @@ func (c *handlerMetricsCollector) Collect(ch chan<- prometheus.Metric) {
 	}
 	c.mu.Unlock()
 
-	c.h.mu.Lock()
-	ch <- prometheus.MustNewConstMetric(c.pendingTasksDesc, prometheus.GaugeValue, float64(len(c.h.pending)))
-	c.h.mu.Unlock()
+	state := c.h.state()
+
+	var oldestAge time.Duration
+	var lastSuccess float64
+
+	if !state.oldestReported.IsZero() {
+		oldestAge = clock.Since(state.oldestReported)
+	}
+
+	if !state.lastSuccess.IsZero() {
+		lastSuccess = float64(state.lastSuccess.UnixNano()) / 1e9
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.pendingTasksDesc, prometheus.GaugeValue, float64(state.pending))
+	ch <- prometheus.MustNewConstMetric(c.runningTasksDesc, prometheus.GaugeValue, float64(state.running))
+	ch <- prometheus.MustNewConstMetric(c.retryWaitTasksDesc, prometheus.GaugeValue, float64(state.retryWait))
+	ch <- prometheus.MustNewConstMetric(c.oldestPendingAgeDesc, prometheus.GaugeValue, oldestAge.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.lastSuccessDesc, prometheus.GaugeValue, lastSuccess)
+
+	diskSpaceLow := 0.0
+	if c.h.diskSpace.isLow() {
+		diskSpaceLow = 1
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.diskSpaceLowDesc, prometheus.GaugeValue, diskSpaceLow)
 }
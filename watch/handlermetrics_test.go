@@ -1,13 +1,19 @@
 package watch
 
 import (
+	"context"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/eventbus"
+	"github.com/hansmi/baamhackl/internal/handlertask"
+	"github.com/hansmi/baamhackl/internal/scheduler"
 	"github.com/hansmi/baamhackl/internal/testutil"
+	"github.com/jonboulle/clockwork"
 )
 
 func TestMakeHandlerTimeBuckets(t *testing.T) {
@@ -49,7 +55,7 @@ func TestReportProcessState(t *testing.T) {
 	cfg := config.HandlerDefaults
 	cfg.Path = t.TempDir()
 
-	mc := newHandlerMetricsCollector(newHandler(&cfg))
+	mc := newHandlerMetricsCollector(newHandler(&cfg, eventbus.Emitter{}))
 
 	testutil.CollectAndCompare(t, mc, `
 		# HELP handler_info Information about the handler.
@@ -135,3 +141,132 @@ func TestReportProcessState(t *testing.T) {
 		"command_system_time",
 	)
 }
+
+func TestHandlerQueueMetrics(t *testing.T) {
+	start := time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+	fc := clockwork.NewFakeClockAt(start)
+	testutil.ReplaceClock(t, &clock, fc)
+
+	cfg := config.HandlerDefaults
+	cfg.Path = t.TempDir()
+	cfg.JournalRetention = time.Minute
+
+	h := newHandler(&cfg, eventbus.Emitter{})
+
+	pt := &pendingTask{
+		Task: handlertask.New(handlertask.Options{
+			Name: "test",
+		}),
+		reported: fc.Now(),
+	}
+	h.pending[pt.Name()] = pt
+
+	fc.Advance(5 * time.Second)
+
+	gaugeNames := []string{
+		"running_tasks",
+		"retry_waiting_tasks",
+		"oldest_pending_age_seconds",
+		"last_success_timestamp_seconds",
+	}
+
+	wantGauges := func(running, retryWait int, oldest float64, lastSuccess time.Time) string {
+		lastSuccessValue := 0.0
+
+		if !lastSuccess.IsZero() {
+			lastSuccessValue = float64(lastSuccess.Unix())
+		}
+
+		return fmt.Sprintf(`
+			# HELP running_tasks Number of tasks with a currently running attempt.
+			# TYPE running_tasks gauge
+			running_tasks %d
+			# HELP retry_waiting_tasks Number of tasks waiting for their next attempt after a failure.
+			# TYPE retry_waiting_tasks gauge
+			retry_waiting_tasks %d
+			# HELP oldest_pending_age_seconds Time since the change of the oldest pending file was reported.
+			# TYPE oldest_pending_age_seconds gauge
+			oldest_pending_age_seconds %g
+			# HELP last_success_timestamp_seconds Time of the most recent successful completion as a Unix timestamp.
+			# TYPE last_success_timestamp_seconds gauge
+			last_success_timestamp_seconds %g
+			`, running, retryWait, oldest, lastSuccessValue)
+	}
+
+	testutil.CollectAndCompare(t, h.metrics(), wantGauges(0, 0, 5, time.Time{}), gaugeNames...)
+
+	h.invoke = func(ctx context.Context, task *handlertask.Task, acquireLock func()) error {
+		testutil.CollectAndCompare(t, h.metrics(), wantGauges(1, 0, 5, time.Time{}), gaugeNames...)
+
+		fc.Advance(10 * time.Second)
+
+		return &scheduler.TaskError{
+			Err:        errTest,
+			RetryDelay: time.Minute,
+		}
+	}
+
+	if err := h.invokeTask(context.Background(), pt); err == nil {
+		t.Errorf("invokeTask() succeeded")
+	}
+
+	testutil.CollectAndCompare(t, h.metrics(), wantGauges(0, 1, 15, time.Time{}), gaugeNames...)
+
+	fc.Advance(time.Minute)
+
+	h.invoke = func(ctx context.Context, task *handlertask.Task, acquireLock func()) error {
+		testutil.CollectAndCompare(t, h.metrics(), wantGauges(1, 0, 75, time.Time{}), gaugeNames...)
+
+		fc.Advance(20 * time.Second)
+
+		return nil
+	}
+
+	if err := h.invokeTask(context.Background(), pt); err != nil {
+		t.Errorf("invokeTask() failed: %v", err)
+	}
+
+	testutil.CollectAndCompare(t, h.metrics(), wantGauges(0, 0, 0, fc.Now()), gaugeNames...)
+
+	testutil.CollectAndCompare(t, h.metrics(), `
+		# HELP task_start_latency Histogram with the time from a reported file change to the start of its first attempt.
+		# TYPE task_start_latency histogram
+		task_start_latency_bucket{le="1"} 0
+		task_start_latency_bucket{le="2"} 0
+		task_start_latency_bucket{le="3"} 0
+		task_start_latency_bucket{le="4"} 0
+		task_start_latency_bucket{le="6"} 1
+		task_start_latency_bucket{le="8"} 1
+		task_start_latency_bucket{le="10"} 1
+		task_start_latency_bucket{le="14"} 1
+		task_start_latency_bucket{le="19"} 1
+		task_start_latency_bucket{le="25"} 1
+		task_start_latency_bucket{le="33"} 1
+		task_start_latency_bucket{le="45"} 1
+		task_start_latency_bucket{le="60"} 1
+		task_start_latency_bucket{le="+Inf"} 1
+		task_start_latency_sum 5
+		task_start_latency_count 1
+		# HELP task_completion_latency Histogram with the time from a reported file change to its final completion.
+		# TYPE task_completion_latency histogram
+		task_completion_latency_bucket{le="1"} 0
+		task_completion_latency_bucket{le="2"} 0
+		task_completion_latency_bucket{le="3"} 0
+		task_completion_latency_bucket{le="4"} 0
+		task_completion_latency_bucket{le="6"} 0
+		task_completion_latency_bucket{le="8"} 0
+		task_completion_latency_bucket{le="10"} 0
+		task_completion_latency_bucket{le="14"} 0
+		task_completion_latency_bucket{le="19"} 0
+		task_completion_latency_bucket{le="25"} 0
+		task_completion_latency_bucket{le="33"} 0
+		task_completion_latency_bucket{le="45"} 0
+		task_completion_latency_bucket{le="60"} 0
+		task_completion_latency_bucket{le="+Inf"} 1
+		task_completion_latency_sum 95
+		task_completion_latency_count 1
+		`,
+		"task_start_latency",
+		"task_completion_latency",
+	)
+}
@@ -0,0 +1,290 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Number of consecutive failed Watchman probes after which the process is
+// considered unhealthy.
+const watchmanProbeMaxFailures = 3
+
+type pinger interface {
+	Ping(context.Context) error
+}
+
+// watchmanProbe periodically verifies that the Watchman daemon is reachable.
+type watchmanProbe struct {
+	client   pinger
+	interval time.Duration
+	timeout  time.Duration
+
+	mu       sync.Mutex
+	checked  time.Time
+	err      error
+	failures int
+}
+
+func newWatchmanProbe(client pinger, interval time.Duration) *watchmanProbe {
+	timeout := interval
+
+	if timeout <= 0 || timeout > 10*time.Second {
+		timeout = 10 * time.Second
+	}
+
+	return &watchmanProbe{
+		client:   client,
+		interval: interval,
+		timeout:  timeout,
+	}
+}
+
+func (p *watchmanProbe) probe(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	err := p.client.Ping(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err == nil {
+		if p.failures > 0 {
+			zap.L().Info("Watchman is reachable again")
+		}
+
+		p.failures = 0
+	} else {
+		if p.failures == 0 {
+			zap.L().Warn("Watchman liveness probe failed", zap.Error(err))
+		}
+
+		p.failures++
+	}
+
+	p.checked = clock.Now()
+	p.err = err
+
+	return err
+}
+
+// start runs the probe immediately and then periodically in a separate
+// goroutine until the returned function is called.
+func (p *watchmanProbe) start() func(context.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	p.probe(ctx)
+
+	go func() {
+		defer close(done)
+
+		if p.interval <= 0 {
+			return
+		}
+
+		ticker := clock.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.Chan():
+				p.probe(ctx)
+			}
+		}
+	}()
+
+	return func(stopCtx context.Context) error {
+		cancel()
+
+		select {
+		case <-done:
+		case <-stopCtx.Done():
+			return stopCtx.Err()
+		}
+
+		return nil
+	}
+}
+
+// status returns the result of the most recent probe.
+func (p *watchmanProbe) status() (time.Time, int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.checked, p.failures, p.err
+}
+
+type healthCheck struct {
+	OK    bool       `json:"ok"`
+	Error string     `json:"error,omitempty"`
+	Time  *time.Time `json:"time,omitempty"`
+}
+
+type handlerHealth struct {
+	Path              string     `json:"path"`
+	TriggerRegistered bool       `json:"trigger_registered"`
+	Pending           int        `json:"pending"`
+	Running           int        `json:"running"`
+	RetryWaiting      int        `json:"retry_waiting"`
+	DiskSpaceLow      bool       `json:"disk_space_low"`
+	OldestPending     *time.Time `json:"oldest_pending,omitempty"`
+	LastSuccess       *time.Time `json:"last_success,omitempty"`
+}
+
+type healthReport struct {
+	OK       bool                     `json:"ok"`
+	Checks   map[string]healthCheck   `json:"checks"`
+	Handlers map[string]handlerHealth `json:"handlers"`
+}
+
+func timePointer(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+
+	return &t
+}
+
+// healthChecker evaluates the state of the process for the /healthz and
+// /readyz endpoints.
+type healthChecker struct {
+	router   *router
+	probe    *watchmanProbe
+	triggers interface{ Names() []string }
+
+	mu              sync.Mutex
+	socketListening bool
+}
+
+func (c *healthChecker) setSocketListening(listening bool) {
+	c.mu.Lock()
+	c.socketListening = listening
+	c.mu.Unlock()
+}
+
+func (c *healthChecker) report(ready bool) healthReport {
+	r := healthReport{
+		Checks:   map[string]healthCheck{},
+		Handlers: map[string]handlerHealth{},
+	}
+
+	add := func(name string, ok bool, msg string, ts time.Time) {
+		r.Checks[name] = healthCheck{
+			OK:    ok,
+			Error: msg,
+			Time:  timePointer(ts),
+		}
+	}
+
+	if c.router.sched.Running() {
+		add("scheduler", true, "", time.Time{})
+	} else {
+		add("scheduler", false, "scheduler loop is not running", time.Time{})
+	}
+
+	if c.probe != nil {
+		checked, failures, err := c.probe.status()
+
+		if checked.IsZero() {
+			add("watchman", false, "not probed yet", checked)
+		} else if err != nil && (ready || failures >= watchmanProbeMaxFailures) {
+			add("watchman", false, err.Error(), checked)
+		} else {
+			add("watchman", true, "", checked)
+		}
+	}
+
+	registered := map[string]bool{}
+
+	if c.triggers != nil {
+		for _, name := range c.triggers.Names() {
+			registered[name] = true
+		}
+	}
+
+	if ready {
+		c.mu.Lock()
+		socketListening := c.socketListening
+		c.mu.Unlock()
+
+		if socketListening {
+			add("socket", true, "", time.Time{})
+		} else {
+			add("socket", false, "socket is not listening", time.Time{})
+		}
+
+		missing := 0
+
+		for name := range c.router.handlerByName {
+			if !registered[name] {
+				missing++
+			}
+		}
+
+		if missing == 0 {
+			add("triggers", true, "", time.Time{})
+		} else {
+			add("triggers", false, "not all triggers are registered", time.Time{})
+		}
+	}
+
+	for name, h := range c.router.handlerByName {
+		state := h.state()
+
+		r.Handlers[name] = handlerHealth{
+			Path:              filepath.Clean(h.cfg.Path),
+			TriggerRegistered: registered[name],
+			Pending:           state.pending,
+			Running:           state.running,
+			RetryWaiting:      state.retryWait,
+			DiskSpaceLow:      h.diskSpace.isLow(),
+			OldestPending:     timePointer(state.oldestReported),
+			LastSuccess:       timePointer(state.lastSuccess),
+		}
+	}
+
+	r.OK = true
+
+	for _, i := range r.Checks {
+		r.OK = r.OK && i.OK
+	}
+
+	return r
+}
+
+func (c *healthChecker) serveReport(w http.ResponseWriter, ready bool) {
+	r := c.report(ready)
+
+	status := http.StatusOK
+
+	if !r.OK {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(r)
+}
+
+// register adds the /healthz and /readyz endpoints to the given mux.
+func (c *healthChecker) register(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		c.serveReport(w, false)
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, _ *http.Request) {
+		c.serveReport(w, true)
+	})
+}
@@ -0,0 +1,301 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/testutil"
+	"github.com/jonboulle/clockwork"
+)
+
+type fakePinger struct {
+	err error
+}
+
+func (p *fakePinger) Ping(context.Context) error {
+	return p.err
+}
+
+type fakeTriggerNames []string
+
+func (n fakeTriggerNames) Names() []string {
+	return n
+}
+
+func TestWatchmanProbe(t *testing.T) {
+	fc := clockwork.NewFakeClock()
+	testutil.ReplaceClock(t, &clock, fc)
+
+	client := &fakePinger{}
+	p := newWatchmanProbe(client, time.Minute)
+
+	if checked, _, _ := p.status(); !checked.IsZero() {
+		t.Errorf("Probe reports check before running")
+	}
+
+	for _, tc := range []struct {
+		err          error
+		wantFailures int
+	}{
+		{},
+		{err: errTest, wantFailures: 1},
+		{err: errTest, wantFailures: 2},
+		{},
+	} {
+		client.err = tc.err
+
+		if err := p.probe(context.Background()); !errors.Is(err, tc.err) {
+			t.Errorf("probe() returned %v, want %v", err, tc.err)
+		}
+
+		checked, failures, err := p.status()
+
+		if !checked.Equal(fc.Now()) {
+			t.Errorf("Check time is %v, want %v", checked, fc.Now())
+		}
+
+		if diff := cmp.Diff(tc.wantFailures, failures); diff != "" {
+			t.Errorf("Failure count diff (-want +got):\n%s", diff)
+		}
+
+		if !errors.Is(err, tc.err) {
+			t.Errorf("status() returned %v, want %v", err, tc.err)
+		}
+	}
+}
+
+func TestWatchmanProbeStartStop(t *testing.T) {
+	p := newWatchmanProbe(&fakePinger{}, time.Hour)
+
+	stop := p.start()
+
+	if checked, _, err := p.status(); checked.IsZero() || err != nil {
+		t.Errorf("Initial probe missing (%v, %v)", checked, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := stop(ctx); err != nil {
+		t.Errorf("stop() failed: %v", err)
+	}
+}
+
+func TestHealthChecker(t *testing.T) {
+	cfg := config.HandlerDefaults
+	cfg.Name = "test"
+	cfg.Path = t.TempDir()
+
+	r := newRouter(routerOptions{
+		handlers: []*config.Handler{&cfg},
+	})
+
+	client := &fakePinger{}
+
+	c := &healthChecker{
+		router: r,
+		probe:  newWatchmanProbe(client, time.Minute),
+	}
+
+	checkNames := func(report healthReport) map[string]bool {
+		result := map[string]bool{}
+
+		for name, check := range report.Checks {
+			result[name] = check.OK
+		}
+
+		return result
+	}
+
+	for _, tc := range []struct {
+		name        string
+		setup       func()
+		wantHealthy map[string]bool
+		wantReady   map[string]bool
+	}{
+		{
+			name: "not started",
+			wantHealthy: map[string]bool{
+				"scheduler": false,
+				"watchman":  false,
+			},
+			wantReady: map[string]bool{
+				"scheduler": false,
+				"watchman":  false,
+				"socket":    false,
+				"triggers":  false,
+			},
+		},
+		{
+			name: "running",
+			setup: func() {
+				r.start(1)
+				c.probe.probe(context.Background())
+				c.setSocketListening(true)
+				c.triggers = fakeTriggerNames{"test"}
+			},
+			wantHealthy: map[string]bool{
+				"scheduler": true,
+				"watchman":  true,
+			},
+			wantReady: map[string]bool{
+				"scheduler": true,
+				"watchman":  true,
+				"socket":    true,
+				"triggers":  true,
+			},
+		},
+		{
+			name: "watchman failed once",
+			setup: func() {
+				client.err = errTest
+				c.probe.probe(context.Background())
+			},
+			wantHealthy: map[string]bool{
+				"scheduler": true,
+				"watchman":  true,
+			},
+			wantReady: map[string]bool{
+				"scheduler": true,
+				"watchman":  false,
+				"socket":    true,
+				"triggers":  true,
+			},
+		},
+		{
+			name: "watchman failed repeatedly",
+			setup: func() {
+				for range watchmanProbeMaxFailures {
+					c.probe.probe(context.Background())
+				}
+			},
+			wantHealthy: map[string]bool{
+				"scheduler": true,
+				"watchman":  false,
+			},
+			wantReady: map[string]bool{
+				"scheduler": true,
+				"watchman":  false,
+				"socket":    true,
+				"triggers":  true,
+			},
+		},
+		{
+			name: "stopped",
+			setup: func() {
+				client.err = nil
+				c.probe.probe(context.Background())
+				c.triggers = fakeTriggerNames{}
+
+				if err := r.stop(context.Background()); err != nil {
+					t.Errorf("stop() failed: %v", err)
+				}
+			},
+			wantHealthy: map[string]bool{
+				"scheduler": false,
+				"watchman":  true,
+			},
+			wantReady: map[string]bool{
+				"scheduler": false,
+				"watchman":  true,
+				"socket":    true,
+				"triggers":  false,
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.setup != nil {
+				tc.setup()
+			}
+
+			for _, ready := range []bool{false, true} {
+				want := tc.wantHealthy
+
+				if ready {
+					want = tc.wantReady
+				}
+
+				report := c.report(ready)
+
+				if diff := cmp.Diff(want, checkNames(report)); diff != "" {
+					t.Errorf("Checks (ready=%t) diff (-want +got):\n%s", ready, diff)
+				}
+
+				wantOK := true
+
+				for _, ok := range want {
+					wantOK = wantOK && ok
+				}
+
+				if report.OK != wantOK {
+					t.Errorf("Report (ready=%t) is %t, want %t", ready, report.OK, wantOK)
+				}
+
+				if _, ok := report.Handlers["test"]; !ok {
+					t.Errorf("Report lacks handler: %+v", report.Handlers)
+				}
+			}
+		})
+	}
+}
+
+func TestHealthCheckerHTTP(t *testing.T) {
+	cfg := config.HandlerDefaults
+	cfg.Name = "test"
+	cfg.Path = t.TempDir()
+
+	r := newRouter(routerOptions{
+		handlers: []*config.Handler{&cfg},
+	})
+	r.start(1)
+
+	t.Cleanup(func() {
+		r.stop(context.Background())
+	})
+
+	c := &healthChecker{
+		router: r,
+	}
+
+	mux := http.NewServeMux()
+	c.register(mux)
+
+	for _, tc := range []struct {
+		path       string
+		wantStatus int
+	}{
+		{path: "/healthz", wantStatus: http.StatusOK},
+		{path: "/readyz", wantStatus: http.StatusServiceUnavailable},
+	} {
+		t.Run(tc.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			if diff := cmp.Diff(tc.wantStatus, rec.Code); diff != "" {
+				t.Errorf("Status diff (-want +got):\n%s", diff)
+			}
+
+			if diff := cmp.Diff("application/json", rec.Header().Get("Content-Type")); diff != "" {
+				t.Errorf("Content type diff (-want +got):\n%s", diff)
+			}
+
+			var got healthReport
+
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Errorf("Unmarshal() failed: %v", err)
+			}
+
+			if diff := cmp.Diff(cfg.Path, got.Handlers["test"].Path); diff != "" {
+				t.Errorf("Handler path diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package watch
+
+import (
+	"context"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/service"
+	"github.com/hansmi/baamhackl/internal/watchman"
+	"github.com/hansmi/baamhackl/internal/watchmantrigger"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+)
+
+// scanHandlerDir lists the files in a handler directory which would be
+// reported by its Watchman trigger. The same filters as in the trigger
+// expression are applied.
+func scanHandlerDir(cfg *config.Handler) ([]watchman.FileChange, error) {
+	ignoreDirs, err := watchmantrigger.IgnoreDirs(*cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	var result []watchman.FileChange
+
+	err = filepath.WalkDir(cfg.Path, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(cfg.Path, path)
+		if err != nil {
+			return err
+		}
+
+		if d.IsDir() {
+			if rel == "." {
+				return nil
+			}
+
+			if !cfg.Recursive || slices.Contains(ignoreDirs, rel) {
+				return filepath.SkipDir
+			}
+
+			return nil
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		if !cfg.IncludeHidden && strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		size := uint64(fi.Size())
+
+		if (cfg.MinSizeBytes > 0 && size < cfg.MinSizeBytes) ||
+			(cfg.MaxSizeBytes > 0 && size > cfg.MaxSizeBytes) {
+			return nil
+		}
+
+		result = append(result, watchman.FileChange{
+			Name:  rel,
+			Size:  fi.Size(),
+			MTime: fi.ModTime(),
+		})
+
+		return nil
+	})
+
+	return result, err
+}
+
+// reconcile scans all handler directories and queues every eligible file,
+// oldest first. Files which arrived while Baamhackl wasn't running are
+// processed independently of Watchman's state. The number of queued files is
+// returned.
+func (r *router) reconcile(ctx context.Context) (int, error) {
+	logger := zap.L()
+
+	var allErrors error
+	var requests []service.FileChangedRequest
+
+	for _, h := range r.handlerByName {
+		changes, err := scanHandlerDir(h.cfg)
+		if err != nil {
+			multierr.AppendInto(&allErrors, err)
+			continue
+		}
+
+		logger.Debug("Scanned handler directory",
+			zap.String("handler", h.name),
+			zap.Int("count", len(changes)))
+
+		for _, i := range changes {
+			requests = append(requests, service.FileChangedRequest{
+				HandlerName: h.name,
+				RootDir:     h.cfg.Path,
+				Change:      i,
+			})
+		}
+	}
+
+	slices.SortStableFunc(requests, func(a, b service.FileChangedRequest) int {
+		if c := a.Change.MTime.Compare(b.Change.MTime); c != 0 {
+			return c
+		}
+
+		if c := strings.Compare(a.HandlerName, b.HandlerName); c != 0 {
+			return c
+		}
+
+		return strings.Compare(a.Change.Name, b.Change.Name)
+	})
+
+	count := 0
+
+	for _, req := range requests {
+		if err := ctx.Err(); err != nil {
+			return count, multierr.Append(allErrors, err)
+		}
+
+		if err := r.FileChanged(req); err != nil {
+			multierr.AppendInto(&allErrors, err)
+			continue
+		}
+
+		count++
+	}
+
+	return count, allErrors
+}
@@ -0,0 +1,158 @@
+package watch
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/eventbus"
+	"github.com/hansmi/baamhackl/internal/testutil"
+)
+
+func writeFileWithMTime(t *testing.T, path, content string, mtime time.Time) {
+	t.Helper()
+
+	testutil.MustWriteFile(t, path, content)
+
+	if err := os.Chtimes(path, mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScanHandlerDir(t *testing.T) {
+	base := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	tmpdir := t.TempDir()
+
+	testutil.MustMkdir(t, filepath.Join(tmpdir, "sub"))
+	testutil.MustMkdir(t, filepath.Join(tmpdir, "_"))
+	testutil.MustMkdir(t, filepath.Join(tmpdir, "_", "journal"))
+
+	writeFileWithMTime(t, filepath.Join(tmpdir, "top.txt"), "top", base)
+	writeFileWithMTime(t, filepath.Join(tmpdir, ".hidden"), "hidden", base)
+	writeFileWithMTime(t, filepath.Join(tmpdir, "empty"), "", base)
+	writeFileWithMTime(t, filepath.Join(tmpdir, "sub", "nested.txt"), "nested", base)
+	writeFileWithMTime(t, filepath.Join(tmpdir, "_", "journal", "log.txt"), "log", base)
+
+	if err := os.Symlink("top.txt", filepath.Join(tmpdir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		modify func(*config.Handler)
+		want   []string
+	}{
+		{
+			name: "defaults",
+			want: []string{"empty", "top.txt"},
+		},
+		{
+			name: "recursive",
+			modify: func(h *config.Handler) {
+				h.Recursive = true
+			},
+			want: []string{"empty", "sub/nested.txt", "top.txt"},
+		},
+		{
+			name: "hidden",
+			modify: func(h *config.Handler) {
+				h.IncludeHidden = true
+			},
+			want: []string{".hidden", "empty", "top.txt"},
+		},
+		{
+			name: "size",
+			modify: func(h *config.Handler) {
+				h.Recursive = true
+				h.MinSizeBytes = 1
+				h.MaxSizeBytes = 3
+			},
+			want: []string{"top.txt"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := config.HandlerDefaults
+			cfg.Path = tmpdir
+
+			if tc.modify != nil {
+				tc.modify(&cfg)
+			}
+
+			changes, err := scanHandlerDir(&cfg)
+			if err != nil {
+				t.Errorf("scanHandlerDir() failed: %v", err)
+			}
+
+			var got []string
+
+			for _, i := range changes {
+				got = append(got, i.Name)
+
+				if !i.MTime.Equal(base) {
+					t.Errorf("Modification time of %q is %v, want %v", i.Name, i.MTime, base)
+				}
+			}
+
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("Scanned files diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestRouterReconcile(t *testing.T) {
+	base := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	first := config.HandlerDefaults
+	first.Name = "first"
+	first.Path = t.TempDir()
+
+	second := config.HandlerDefaults
+	second.Name = "second"
+	second.Path = t.TempDir()
+
+	writeFileWithMTime(t, filepath.Join(first.Path, "newest"), "", base.Add(time.Hour))
+	writeFileWithMTime(t, filepath.Join(first.Path, "oldest"), "", base)
+	writeFileWithMTime(t, filepath.Join(second.Path, "middle"), "", base.Add(time.Minute))
+
+	bus := eventbus.New(0)
+
+	r := newRouter(routerOptions{
+		handlers: []*config.Handler{&first, &second},
+		events:   bus,
+	})
+
+	count, err := r.reconcile(context.Background())
+	if err != nil {
+		t.Errorf("reconcile() failed: %v", err)
+	}
+
+	if diff := cmp.Diff(3, count); diff != "" {
+		t.Errorf("Count diff (-want +got):\n%s", diff)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	events, _, err := bus.Wait(ctx, 0, func(e eventbus.Event) bool {
+		return e.Type == eventbus.TaskQueued
+	})
+	if err != nil {
+		t.Fatalf("Wait() failed: %v", err)
+	}
+
+	var got []string
+
+	for _, e := range events {
+		got = append(got, e.Handler+"/"+e.Name)
+	}
+
+	if diff := cmp.Diff([]string{"first/oldest", "second/middle", "first/newest"}, got); diff != "" {
+		t.Errorf("Queue order diff (-want +got):\n%s", diff)
+	}
+}
@@ -7,10 +7,13 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/hansmi/baamhackl/internal/cgroup"
 	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/eventbus"
 	"github.com/hansmi/baamhackl/internal/fuzzduration"
 	"github.com/hansmi/baamhackl/internal/scheduler"
 	"github.com/hansmi/baamhackl/internal/service"
+	"github.com/hansmi/baamhackl/internal/tracing"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/multierr"
 	"go.uber.org/zap"
@@ -18,6 +21,12 @@ import (
 
 type routerOptions struct {
 	handlers []*config.Handler
+
+	// Destination for lifecycle events. May be nil.
+	events *eventbus.Bus
+
+	// Manager for per-command cgroups. May be nil.
+	cgroups *cgroup.Manager
 }
 
 type router struct {
@@ -26,6 +35,8 @@ type router struct {
 	pruneInterval time.Duration
 
 	registry *prometheus.Registry
+
+	events *eventbus.Bus
 }
 
 func newRouter(opts routerOptions) *router {
@@ -34,12 +45,17 @@ func newRouter(opts routerOptions) *router {
 		sched:         scheduler.New(),
 		pruneInterval: time.Hour,
 		registry:      prometheus.NewPedanticRegistry(),
+		events:        opts.events,
 	}
 
 	prefixedReg := prometheus.WrapRegistererWithPrefix("baamhackl_", r.registry)
 
 	for _, cfg := range opts.handlers {
-		h := newHandler(cfg)
+		h := newHandler(cfg, eventbus.Emitter{
+			Bus:     opts.events,
+			Handler: cfg.Name,
+		})
+		h.cgroups = opts.cgroups
 		r.handlerByName[cfg.Name] = h
 		prometheus.WrapRegistererWith(prometheus.Labels{
 			"handler": cfg.Name,
@@ -62,7 +78,20 @@ func (r *router) metrics() prometheus.Collector {
 	return r.registry
 }
 
-func (r *router) FileChanged(req service.FileChangedRequest) error {
+// registerMetrics adds a collector not specific to a handler.
+func (r *router) registerMetrics(c prometheus.Collector) {
+	prometheus.WrapRegistererWithPrefix("baamhackl_", r.registry).MustRegister(c)
+}
+
+func (r *router) FileChanged(req service.FileChangedRequest) (err error) {
+	ctx, span := tracing.Start(context.Background(), "router.FileChanged",
+		tracing.HandlerKey.String(req.HandlerName),
+		tracing.FileKey.String(req.Change.Name),
+	)
+	defer func() {
+		tracing.End(span, err)
+	}()
+
 	logger := zap.L()
 	logger.Debug("Received file change", zap.Reflect("req", req))
 
@@ -83,7 +112,41 @@ func (r *router) FileChanged(req service.FileChangedRequest) error {
 		return fmt.Errorf("handler %q not found", req.HandlerName)
 	}
 
-	return h.handle(r.sched, req)
+	return h.handle(ctx, r.sched, req)
+}
+
+// Longest time a client may wait for events in a single request.
+const maxEventsTimeout = time.Minute
+
+func (r *router) Events(req service.EventsRequest) (service.EventsResponse, error) {
+	if r.events == nil {
+		return service.EventsResponse{}, errors.New("events are not available")
+	}
+
+	after := req.After
+
+	if req.Tail {
+		after = r.events.LastSeq()
+	}
+
+	timeout := req.Timeout
+
+	if timeout <= 0 || timeout > maxEventsTimeout {
+		timeout = maxEventsTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	events, next, err := r.events.Wait(ctx, after, eventbus.HandlerFilter(req.Handlers))
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return service.EventsResponse{}, err
+	}
+
+	return service.EventsResponse{
+		Events: events,
+		Next:   next,
+	}, nil
 }
 
 func (r *router) startPruning(interval time.Duration) {
@@ -106,6 +169,7 @@ func (r *router) pruneAll(ctx context.Context) error {
 		}
 
 		multierr.AppendInto(&allErrors, h.prune(ctx))
+		multierr.AppendInto(&allErrors, h.compress(ctx))
 	}
 
 	r.schedulePruning(r.pruneInterval)
@@ -2,15 +2,22 @@ package watch
 
 import (
 	"context"
+	"fmt"
 	"regexp"
 	"testing"
 	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/eventbus"
+	"github.com/hansmi/baamhackl/internal/handlertask"
 	"github.com/hansmi/baamhackl/internal/service"
 	"github.com/hansmi/baamhackl/internal/testutil"
 	"github.com/hansmi/baamhackl/internal/watchman"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func TestRouterStartStop(t *testing.T) {
@@ -153,3 +160,129 @@ func TestRouterMetrics(t *testing.T) {
 		"baamhackl_retries_total",
 	)
 }
+
+func TestRouterEvents(t *testing.T) {
+	tmpdir := t.TempDir()
+
+	if _, err := newRouter(routerOptions{}).Events(service.EventsRequest{}); err == nil {
+		t.Errorf("Events() without bus succeeded")
+	}
+
+	r := newRouter(routerOptions{
+		handlers: []*config.Handler{
+			{Name: "first", Path: tmpdir},
+			{Name: "second", Path: tmpdir},
+		},
+		events: eventbus.New(0),
+	})
+
+	for _, name := range []string{"first", "second"} {
+		req := service.FileChangedRequest{
+			HandlerName: name,
+			RootDir:     tmpdir,
+		}
+		req.Change.Name = "file.txt"
+
+		if err := r.FileChanged(req); err != nil {
+			t.Errorf("FileChanged(%+v) failed: %v", req, err)
+		}
+	}
+
+	resp, err := r.Events(service.EventsRequest{
+		Handlers: []string{"second"},
+	})
+	if err != nil {
+		t.Errorf("Events() failed: %v", err)
+	}
+
+	var got []string
+
+	for _, e := range resp.Events {
+		got = append(got, fmt.Sprintf("%s %s %s", e.Handler, e.Type, e.Name))
+	}
+
+	if diff := cmp.Diff([]string{
+		"second file_reported file.txt",
+		"second task_queued file.txt",
+	}, got); diff != "" {
+		t.Errorf("Events diff (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(uint64(4), resp.Next); diff != "" {
+		t.Errorf("Cursor diff (-want +got):\n%s", diff)
+	}
+
+	// No new events
+	resp, err = r.Events(service.EventsRequest{
+		Tail:    true,
+		Timeout: time.Millisecond,
+	})
+	if err != nil {
+		t.Errorf("Events() failed: %v", err)
+	}
+
+	if diff := cmp.Diff(service.EventsResponse{Next: 4}, resp); diff != "" {
+		t.Errorf("Response diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestRouterTracing(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	prev := otel.GetTracerProvider()
+	otel.SetTracerProvider(tp)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(prev)
+	})
+
+	tmpdir := t.TempDir()
+
+	r := newRouter(routerOptions{
+		handlers: []*config.Handler{
+			{Name: "test", Path: tmpdir},
+		},
+	})
+	r.handlerByName["test"].invoke = func(context.Context, *handlertask.Task, func()) error {
+		return nil
+	}
+	r.start(1)
+
+	t.Cleanup(func() {
+		r.stop(context.Background())
+	})
+
+	req := service.FileChangedRequest{
+		HandlerName: "test",
+		RootDir:     tmpdir,
+	}
+	req.Change.Name = "file.txt"
+
+	if err := r.FileChanged(req); err != nil {
+		t.Errorf("FileChanged(%+v) failed: %v", req, err)
+	}
+
+	if err := r.sched.Quiesce(context.Background()); err != nil {
+		t.Errorf("Quiesce() failed: %v", err)
+	}
+
+	parents := map[string]string{}
+	names := map[trace.SpanID]string{}
+
+	for _, s := range recorder.Ended() {
+		names[s.SpanContext().SpanID()] = s.Name()
+	}
+
+	for _, s := range recorder.Ended() {
+		parents[s.Name()] = names[s.Parent().SpanID()]
+	}
+
+	if diff := cmp.Diff(map[string]string{
+		"router.FileChanged": "",
+		"handler.handle":     "router.FileChanged",
+		"file":               "handler.handle",
+		"scheduler.task":     "file",
+	}, parents); diff != "" {
+		t.Errorf("Span parents diff (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,371 @@
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hansmi/baamhackl/internal/journal"
+	"go.uber.org/zap"
+)
+
+// Name of the task log within journal entries.
+const statusLogFile = "log.txt"
+
+// Names of the command output within the per-attempt directories of journal
+// entries. Commands write either a combined file or one file per stream.
+var statusOutputFiles = [][]string{
+	{"command_output.txt"},
+	{"command_stdout.txt", "command_stderr.txt"},
+}
+
+type statusPendingTask struct {
+	Name      string
+	Reported  time.Time
+	Attempts  int
+	Running   bool
+	NextRetry time.Time
+	LastError string
+}
+
+type statusCompletion struct {
+	Name     string
+	Finished time.Time
+	Attempts int
+	Error    string
+	LogURL   string
+
+	// Links to the command output of each attempt.
+	OutputURLs []string
+}
+
+type statusHandler struct {
+	Name         string
+	Path         string
+	DiskSpaceLow bool
+	Pending      []statusPendingTask
+	Recent       []statusCompletion
+}
+
+type statusData struct {
+	Generated time.Time
+	Handlers  []statusHandler
+}
+
+func formatStatusTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+
+	return err.Error()
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"time": formatStatusTime,
+	"add":  func(a, b int) int { return a + b },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>baamhackl status</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.5em; text-align: left; vertical-align: top; }
+.failed { color: #b00; }
+</style>
+</head>
+<body>
+<h1>baamhackl status</h1>
+<p>Generated at {{ time .Generated }}.</p>
+{{- range .Handlers }}
+<h2>{{ .Name }}</h2>
+<p>Path: <code>{{ .Path }}</code>{{ if .DiskSpaceLow }}; <span class="failed">tasks deferred due to low disk space</span>{{ end }}</p>
+<h3>Pending</h3>
+{{- if .Pending }}
+<table>
+<tr><th>File</th><th>Reported</th><th>Attempts</th><th>State</th><th>Last error</th></tr>
+{{- range .Pending }}
+<tr>
+<td><code>{{ .Name }}</code></td>
+<td>{{ time .Reported }}</td>
+<td>{{ .Attempts }}</td>
+<td>{{ if .Running }}running{{ else if not .NextRetry.IsZero }}retry after {{ time .NextRetry }}{{ else }}waiting{{ end }}</td>
+<td>{{ .LastError }}</td>
+</tr>
+{{- end }}
+</table>
+{{- else }}
+<p>No pending tasks.</p>
+{{- end }}
+<h3>Recently finished</h3>
+{{- if .Recent }}
+<table>
+<tr><th>File</th><th>Finished</th><th>Attempts</th><th>Result</th><th>Journal</th></tr>
+{{- range .Recent }}
+<tr>
+<td><code>{{ .Name }}</code></td>
+<td>{{ time .Finished }}</td>
+<td>{{ .Attempts }}</td>
+<td>{{ if .Error }}<span class="failed">{{ .Error }}</span>{{ else }}success{{ end }}</td>
+<td>
+{{- if .LogURL }}<a href="{{ .LogURL }}">log</a>{{ end }}
+{{- range $idx, $url := .OutputURLs }} <a href="{{ $url }}">output #{{ add $idx 1 }}</a>{{ end -}}
+</td>
+</tr>
+{{- end }}
+</table>
+{{- else }}
+<p>No finished tasks.</p>
+{{- end }}
+{{- end }}
+</body>
+</html>
+`))
+
+// statusPage serves a read-only overview of all handlers and their tasks
+// together with the journal files of recently finished tasks.
+type statusPage struct {
+	router *router
+}
+
+func statusEntryURL(handlerName string, id int, parts ...string) string {
+	p := []string{"/status", url.PathEscape(handlerName), strconv.Itoa(id)}
+
+	return strings.Join(append(p, parts...), "/")
+}
+
+func (p *statusPage) collect() statusData {
+	data := statusData{
+		Generated: clock.Now(),
+	}
+
+	for name, h := range p.router.handlerByName {
+		sh := statusHandler{
+			Name:         name,
+			Path:         filepath.Clean(h.cfg.Path),
+			DiskSpaceLow: h.diskSpace.isLow(),
+		}
+
+		h.mu.Lock()
+
+		for _, t := range h.pending {
+			sh.Pending = append(sh.Pending, statusPendingTask{
+				Name:      t.Name(),
+				Reported:  t.reported,
+				Attempts:  t.attempts,
+				Running:   t.running,
+				NextRetry: t.nextRetry,
+				LastError: errorString(t.lastErr),
+			})
+		}
+
+		for _, c := range h.recent {
+			sc := statusCompletion{
+				Name:     c.name,
+				Finished: c.finished,
+				Attempts: c.attempts,
+				Error:    errorString(c.err),
+			}
+
+			if c.journal != "" {
+				sc.LogURL = statusEntryURL(name, c.id, "log")
+
+				for attempt := range c.attempts {
+					sc.OutputURLs = append(sc.OutputURLs, statusEntryURL(name, c.id, "output", strconv.Itoa(attempt)))
+				}
+			}
+
+			sh.Recent = append(sh.Recent, sc)
+		}
+
+		h.mu.Unlock()
+
+		slices.SortFunc(sh.Pending, func(a, b statusPendingTask) int {
+			if c := a.Reported.Compare(b.Reported); c != 0 {
+				return c
+			}
+
+			return strings.Compare(a.Name, b.Name)
+		})
+
+		data.Handlers = append(data.Handlers, sh)
+	}
+
+	slices.SortFunc(data.Handlers, func(a, b statusHandler) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return data
+}
+
+func setStatusHeaders(w http.ResponseWriter, contentType string) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+}
+
+func (p *statusPage) serveOverview(w http.ResponseWriter, _ *http.Request) {
+	setStatusHeaders(w, "text/html; charset=utf-8")
+
+	if err := statusTemplate.Execute(w, p.collect()); err != nil {
+		zap.L().Error("Rendering status page failed", zap.Error(err))
+	}
+}
+
+// openEntryFile opens a file within a journal entry. Entries compressed since
+// the task finished are found by their archive name.
+func openEntryFile(entry, name string) (io.ReadCloser, error) {
+	fh, err := journal.OpenEntryFile(entry, name)
+
+	if errors.Is(err, os.ErrNotExist) && !journal.IsArchive(entry) {
+		fh, err = journal.OpenEntryFile(entry+journal.ArchiveSuffix, name)
+	}
+
+	return fh, err
+}
+
+// openEntryFiles opens the first group of files within a journal entry of
+// which at least one file exists.
+func openEntryFiles(entry string, groups [][]string) (names []string, files []io.ReadCloser, err error) {
+	for _, group := range groups {
+		for _, name := range group {
+			fh, err := openEntryFile(entry, name)
+			if err != nil {
+				if errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+
+				for _, i := range files {
+					i.Close()
+				}
+
+				return nil, nil, err
+			}
+
+			names = append(names, name)
+			files = append(files, fh)
+		}
+
+		if len(files) > 0 {
+			return names, files, nil
+		}
+	}
+
+	return nil, nil, os.ErrNotExist
+}
+
+// serveEntryFile sends a file from the journal entry of a recently finished
+// task. Only entries known to the handler are accessible and the file name is
+// never taken from the request. Alternative groups of files can be given; the
+// files of the first group found are sent one after another.
+func (p *statusPage) serveEntryFile(w http.ResponseWriter, r *http.Request, name func(taskCompletion) ([][]string, bool)) {
+	h, ok := p.router.handlerByName[r.PathValue("handler")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	id, err := strconv.Atoi(r.PathValue("id"))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	c, ok := h.completion(id)
+	if !ok || c.journal == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	groups, ok := name(c)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	names, files, err := openEntryFiles(c.journal, groups)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			http.NotFound(w, r)
+		} else {
+			zap.L().Error("Opening journal file failed", zap.Error(err))
+			http.Error(w, "Opening journal file failed", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	defer func() {
+		for _, fh := range files {
+			fh.Close()
+		}
+	}()
+
+	setStatusHeaders(w, "text/plain; charset=utf-8")
+
+	for idx, fh := range files {
+		if len(files) > 1 {
+			if idx > 0 {
+				io.WriteString(w, "\n")
+			}
+
+			fmt.Fprintf(w, "==> %s <==\n", filepath.Base(names[idx]))
+		}
+
+		if _, err := io.Copy(w, fh); err != nil {
+			zap.L().Error("Sending journal file failed", zap.Error(err))
+			return
+		}
+	}
+}
+
+// register adds the status page and its journal file views to the given mux.
+// Only GET (and implicitly HEAD) requests are accepted.
+func (p *statusPage) register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /status", p.serveOverview)
+	mux.HandleFunc("GET /status/{handler}/{id}/log", func(w http.ResponseWriter, r *http.Request) {
+		p.serveEntryFile(w, r, func(taskCompletion) ([][]string, bool) {
+			return [][]string{{statusLogFile}}, true
+		})
+	})
+	mux.HandleFunc("GET /status/{handler}/{id}/output/{attempt}", func(w http.ResponseWriter, r *http.Request) {
+		p.serveEntryFile(w, r, func(c taskCompletion) ([][]string, bool) {
+			attempt, err := strconv.Atoi(r.PathValue("attempt"))
+			if err != nil || attempt < 0 || attempt >= c.attempts {
+				return nil, false
+			}
+
+			var groups [][]string
+
+			for _, group := range statusOutputFiles {
+				var names []string
+
+				for _, name := range group {
+					names = append(names, fmt.Sprintf("%d/%s", attempt, name))
+				}
+
+				groups = append(groups, names)
+			}
+
+			return groups, true
+		})
+	})
+}
@@ -0,0 +1,202 @@
+package watch
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/handlertask"
+	"github.com/hansmi/baamhackl/internal/testutil"
+	"github.com/jonboulle/clockwork"
+)
+
+func TestStatusPage(t *testing.T) {
+	fc := clockwork.NewFakeClockAt(time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC))
+	testutil.ReplaceClock(t, &clock, fc)
+
+	cfg := config.HandlerDefaults
+	cfg.Name = "test<&>"
+	cfg.Path = t.TempDir()
+
+	r := newRouter(routerOptions{
+		handlers: []*config.Handler{&cfg},
+	})
+
+	h := r.handlerByName[cfg.Name]
+
+	h.pending["<script>pending</script>"] = &pendingTask{
+		Task: handlertask.New(handlertask.Options{
+			Name: "<script>pending</script>",
+		}),
+		reported:  fc.Now(),
+		attempts:  2,
+		nextRetry: fc.Now().Add(time.Minute),
+		lastErr:   errTest,
+	}
+
+	entry := t.TempDir()
+	archived := filepath.Join(t.TempDir(), "archived")
+
+	testutil.MustWriteFile(t, filepath.Join(entry, "log.txt"), "log content")
+	testutil.MustMkdir(t, filepath.Join(entry, "0"))
+	testutil.MustWriteFile(t, filepath.Join(entry, "0", "command_output.txt"), "<b>output</b>")
+	testutil.MustMkdir(t, filepath.Join(entry, "1"))
+	testutil.MustWriteFile(t, filepath.Join(entry, "1", "command_stdout.txt"), "out\n")
+	testutil.MustWriteFile(t, filepath.Join(entry, "1", "command_stderr.txt"), "err\n")
+	testutil.MustWriteFile(t, filepath.Join(entry, "secret.txt"), "secret")
+
+	h.recordCompletion(taskCompletion{
+		name:     `"quoted" & <b>bold</b>`,
+		journal:  entry,
+		attempts: 2,
+		finished: fc.Now(),
+	})
+	h.recordCompletion(taskCompletion{
+		name:     "archived",
+		journal:  archived,
+		attempts: 1,
+		finished: fc.Now(),
+		err:      errTest,
+	})
+
+	mux := http.NewServeMux()
+	(&statusPage{router: r}).register(mux)
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	t.Run("overview", func(t *testing.T) {
+		rec := serve(http.MethodGet, "/status")
+
+		if diff := cmp.Diff(http.StatusOK, rec.Code); diff != "" {
+			t.Errorf("Status diff (-want +got):\n%s", diff)
+		}
+
+		if diff := cmp.Diff("text/html; charset=utf-8", rec.Header().Get("Content-Type")); diff != "" {
+			t.Errorf("Content type diff (-want +got):\n%s", diff)
+		}
+
+		body := rec.Body.String()
+
+		for _, want := range []string{
+			"<h2>test&lt;&amp;&gt;</h2>",
+			"<code>&lt;script&gt;pending&lt;/script&gt;</code>",
+			"retry after 2020-01-01T00:01:00Z",
+			errTest.Error(),
+			"<code>&#34;quoted&#34; &amp; &lt;b&gt;bold&lt;/b&gt;</code>",
+			`href="/status/test%3C&amp;%3E/0/log"`,
+			`href="/status/test%3C&amp;%3E/0/output/0"`,
+		} {
+			if !strings.Contains(body, want) {
+				t.Errorf("Page does not contain %q:\n%s", want, body)
+			}
+		}
+
+		for _, unwanted := range []string{"<script>", "<b>"} {
+			if strings.Contains(body, unwanted) {
+				t.Errorf("Page contains unescaped %q:\n%s", unwanted, body)
+			}
+		}
+	})
+
+	for _, tc := range []struct {
+		name       string
+		method     string
+		target     string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "log",
+			target:     "/status/test%3C&%3E/0/log",
+			wantStatus: http.StatusOK,
+			wantBody:   "log content",
+		},
+		{
+			name:       "output",
+			target:     "/status/test%3C&%3E/0/output/0",
+			wantStatus: http.StatusOK,
+			wantBody:   "<b>output</b>",
+		},
+		{
+			name:       "separate output",
+			target:     "/status/test%3C&%3E/0/output/1",
+			wantStatus: http.StatusOK,
+			wantBody:   "==> command_stdout.txt <==\nout\n\n==> command_stderr.txt <==\nerr\n",
+		},
+		{
+			name:       "attempt out of range",
+			target:     "/status/test%3C&%3E/0/output/2",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "negative attempt",
+			target:     "/status/test%3C&%3E/0/output/-1",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "missing entry",
+			target:     "/status/test%3C&%3E/1/log",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "unknown completion",
+			target:     "/status/test%3C&%3E/99/log",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "unknown handler",
+			target:     "/status/other/0/log",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "arbitrary file",
+			target:     "/status/test%3C&%3E/0/secret.txt",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "post",
+			method:     http.MethodPost,
+			target:     "/status",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+		{
+			name:       "delete",
+			method:     http.MethodDelete,
+			target:     "/status/test%3C&%3E/0/log",
+			wantStatus: http.StatusMethodNotAllowed,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			rec := serve(method, tc.target)
+
+			if diff := cmp.Diff(tc.wantStatus, rec.Code); diff != "" {
+				t.Errorf("Status diff (-want +got):\n%s", diff)
+			}
+
+			if tc.wantStatus == http.StatusOK {
+				if diff := cmp.Diff(tc.wantBody, rec.Body.String()); diff != "" {
+					t.Errorf("Body diff (-want +got):\n%s", diff)
+				}
+
+				if diff := cmp.Diff("text/plain; charset=utf-8", rec.Header().Get("Content-Type")); diff != "" {
+					t.Errorf("Content type diff (-want +got):\n%s", diff)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,166 @@
+package watch
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+type triggerGroup interface {
+	Names() []string
+	Verify(context.Context) ([]string, error)
+	SetAll(context.Context, []*config.Handler) error
+	RecrawlAll(context.Context) error
+}
+
+// triggerSupervisor periodically verifies that the Watchman triggers for all
+// handlers still exist with the expected definition. Triggers are registered
+// again when they're missing, e.g. after the Watchman daemon was restarted
+// without its state.
+type triggerSupervisor struct {
+	group    triggerGroup
+	handlers map[string]*config.Handler
+	interval time.Duration
+	timeout  time.Duration
+
+	reregistrationCount *prometheus.CounterVec
+
+	mu      sync.Mutex
+	invalid []string
+}
+
+func newTriggerSupervisor(group triggerGroup, handlers []*config.Handler, interval time.Duration) *triggerSupervisor {
+	timeout := interval
+
+	if timeout <= 0 || timeout > 30*time.Second {
+		timeout = 30 * time.Second
+	}
+
+	s := &triggerSupervisor{
+		group:    group,
+		handlers: map[string]*config.Handler{},
+		interval: interval,
+		timeout:  timeout,
+		reregistrationCount: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "trigger_reregistrations_total",
+			Help: "Number of times a missing or modified Watchman trigger was registered again.",
+		}, []string{"handler"}),
+	}
+
+	for _, h := range handlers {
+		s.handlers[h.Name] = h
+	}
+
+	return s
+}
+
+func (s *triggerSupervisor) metrics() prometheus.Collector {
+	return s.reregistrationCount
+}
+
+func (s *triggerSupervisor) check(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+
+	invalid, err := s.group.Verify(ctx)
+	if err != nil {
+		zap.L().Warn("Verifying Watchman triggers failed", zap.Error(err))
+		return err
+	}
+
+	s.mu.Lock()
+	s.invalid = invalid
+	s.mu.Unlock()
+
+	if len(invalid) == 0 {
+		return nil
+	}
+
+	zap.L().Warn("Watchman triggers are missing or modified, registering again",
+		zap.Strings("handlers", invalid))
+
+	var handlers []*config.Handler
+
+	for _, name := range invalid {
+		if h := s.handlers[name]; h != nil {
+			handlers = append(handlers, h)
+		}
+	}
+
+	if err := s.group.SetAll(ctx, handlers); err != nil {
+		zap.L().Error("Registering Watchman triggers failed", zap.Error(err))
+		return err
+	}
+
+	for _, h := range handlers {
+		s.reregistrationCount.WithLabelValues(h.Name).Inc()
+	}
+
+	s.mu.Lock()
+	s.invalid = nil
+	s.mu.Unlock()
+
+	// Files changed while the triggers were missing are reported again.
+	if err := s.group.RecrawlAll(ctx); err != nil {
+		zap.L().Error("Recrawling watched directories failed", zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+// start runs the check periodically in a separate goroutine until the
+// returned function is called.
+func (s *triggerSupervisor) start() func(context.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		if s.interval <= 0 {
+			return
+		}
+
+		ticker := clock.NewTicker(s.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.Chan():
+				s.check(ctx)
+			}
+		}
+	}()
+
+	return func(stopCtx context.Context) error {
+		cancel()
+
+		select {
+		case <-done:
+		case <-stopCtx.Done():
+			return stopCtx.Err()
+		}
+
+		return nil
+	}
+}
+
+// Names returns the handlers with a registered trigger, excluding those found
+// to be missing or modified by the most recent check.
+func (s *triggerSupervisor) Names() []string {
+	s.mu.Lock()
+	invalid := s.invalid
+	s.mu.Unlock()
+
+	return slices.DeleteFunc(s.group.Names(), func(name string) bool {
+		return slices.Contains(invalid, name)
+	})
+}
@@ -0,0 +1,259 @@
+package watch
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/hansmi/baamhackl/internal/config"
+	"github.com/hansmi/baamhackl/internal/testutil"
+	"github.com/hansmi/baamhackl/internal/watchmantrigger"
+)
+
+type fakeTriggerGroup struct {
+	names     []string
+	invalid   []string
+	verifyErr error
+	set       []string
+	recrawls  int
+}
+
+func (g *fakeTriggerGroup) Names() []string {
+	return append([]string(nil), g.names...)
+}
+
+func (g *fakeTriggerGroup) Verify(context.Context) ([]string, error) {
+	return g.invalid, g.verifyErr
+}
+
+func (g *fakeTriggerGroup) SetAll(_ context.Context, handlers []*config.Handler) error {
+	for _, h := range handlers {
+		g.set = append(g.set, h.Name)
+	}
+
+	g.invalid = nil
+
+	return nil
+}
+
+func (g *fakeTriggerGroup) RecrawlAll(context.Context) error {
+	g.recrawls++
+	return nil
+}
+
+// fakeWatchmanClient keeps trigger definitions per watched root in memory.
+type fakeWatchmanClient struct {
+	mu       sync.Mutex
+	roots    map[string]map[string]map[string]any
+	recrawls int
+}
+
+func (c *fakeWatchmanClient) Ping(context.Context) error {
+	return nil
+}
+
+func (c *fakeWatchmanClient) WatchSet(_ context.Context, root string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.roots == nil {
+		c.roots = map[string]map[string]map[string]any{}
+	}
+
+	if c.roots[root] == nil {
+		c.roots[root] = map[string]map[string]any{}
+	}
+
+	return nil
+}
+
+func (c *fakeWatchmanClient) Recrawl(_ context.Context, root string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.roots[root] == nil {
+		return fmt.Errorf("unable to resolve root %s: directory is not watched", root)
+	}
+
+	c.recrawls++
+
+	return nil
+}
+
+func (c *fakeWatchmanClient) TriggerSet(_ context.Context, root string, args any) error {
+	buf, err := json.Marshal(args)
+	if err != nil {
+		return err
+	}
+
+	var def map[string]any
+
+	if err := json.Unmarshal(buf, &def); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.roots[root] == nil {
+		return fmt.Errorf("unable to resolve root %s: directory is not watched", root)
+	}
+
+	c.roots[root][def["name"].(string)] = def
+
+	return nil
+}
+
+func (c *fakeWatchmanClient) TriggerDel(_ context.Context, root, name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.roots[root], name)
+
+	return nil
+}
+
+func (c *fakeWatchmanClient) TriggerList(_ context.Context, root string) ([]map[string]any, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.roots[root] == nil {
+		return nil, fmt.Errorf("unable to resolve root %s: directory is not watched", root)
+	}
+
+	var result []map[string]any
+
+	for _, def := range c.roots[root] {
+		result = append(result, def)
+	}
+
+	return result, nil
+}
+
+func (c *fakeWatchmanClient) ShutdownServer(context.Context) error {
+	return nil
+}
+
+func TestTriggerSupervisor(t *testing.T) {
+	group := &fakeTriggerGroup{
+		names: []string{"first", "second"},
+	}
+
+	s := newTriggerSupervisor(group, []*config.Handler{
+		{Name: "first"},
+		{Name: "second"},
+	}, time.Minute)
+
+	if err := s.check(context.Background()); err != nil {
+		t.Errorf("check() failed: %v", err)
+	}
+
+	if len(group.set) != 0 || group.recrawls != 0 {
+		t.Errorf("Triggers registered without need: %q, %d recrawls", group.set, group.recrawls)
+	}
+
+	// Verification failure
+	group.verifyErr = errTest
+
+	if err := s.check(context.Background()); !errors.Is(err, errTest) {
+		t.Errorf("check() returned %v, want %v", err, errTest)
+	}
+
+	// Missing trigger
+	group.verifyErr = nil
+	group.invalid = []string{"second"}
+
+	if err := s.check(context.Background()); err != nil {
+		t.Errorf("check() failed: %v", err)
+	}
+
+	if diff := cmp.Diff([]string{"second"}, group.set); diff != "" {
+		t.Errorf("Registered triggers diff (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(1, group.recrawls); diff != "" {
+		t.Errorf("Recrawl count diff (-want +got):\n%s", diff)
+	}
+
+	if diff := cmp.Diff([]string{"first", "second"}, s.Names()); diff != "" {
+		t.Errorf("Names() diff (-want +got):\n%s", diff)
+	}
+
+	testutil.CollectAndCompare(t, s.metrics(), `
+# HELP trigger_reregistrations_total Number of times a missing or modified Watchman trigger was registered again.
+# TYPE trigger_reregistrations_total counter
+trigger_reregistrations_total{handler="second"} 1
+`)
+
+	t.Run("lost watch", func(t *testing.T) {
+		ctx := context.Background()
+		client := &fakeWatchmanClient{}
+		group := &watchmantrigger.Group{Client: client}
+		handlers := []*config.Handler{
+			{Name: "first", Path: t.TempDir()},
+			{Name: "second", Path: t.TempDir()},
+		}
+
+		if err := group.SetAll(ctx, handlers); err != nil {
+			t.Fatalf("SetAll() failed: %v", err)
+		}
+
+		// Watchman restarted without saved state.
+		client.mu.Lock()
+		client.roots = nil
+		client.mu.Unlock()
+
+		s := newTriggerSupervisor(group, handlers, time.Minute)
+
+		if err := s.check(ctx); err != nil {
+			t.Errorf("check() failed: %v", err)
+		}
+
+		for _, h := range handlers {
+			if list, err := client.TriggerList(ctx, h.Path); err != nil {
+				t.Errorf("TriggerList() failed: %v", err)
+			} else if len(list) != 1 {
+				t.Errorf("TriggerList(%q) returned %d triggers, want 1", h.Path, len(list))
+			}
+		}
+
+		if diff := cmp.Diff(2, client.recrawls); diff != "" {
+			t.Errorf("Recrawl count diff (-want +got):\n%s", diff)
+		}
+
+		if invalid, err := group.Verify(ctx); err != nil {
+			t.Errorf("Verify() failed: %v", err)
+		} else if len(invalid) != 0 {
+			t.Errorf("Verify() reported invalid triggers: %q", invalid)
+		}
+	})
+}
+
+func TestTriggerSupervisorNames(t *testing.T) {
+	group := &fakeTriggerGroup{
+		names:   []string{"first", "second", "third"},
+		invalid: []string{"first", "third"},
+	}
+
+	s := newTriggerSupervisor(group, nil, time.Minute)
+	s.invalid = group.invalid
+
+	if diff := cmp.Diff([]string{"second"}, s.Names()); diff != "" {
+		t.Errorf("Names() diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestTriggerSupervisorStartStop(t *testing.T) {
+	s := newTriggerSupervisor(&fakeTriggerGroup{}, nil, time.Hour)
+
+	stop := s.start()
+
+	if err := stop(context.Background()); err != nil {
+		t.Errorf("stop() failed: %v", err)
+	}
+}